@@ -0,0 +1,189 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Arrow format constants, from Schema.fbs and Message.fbs
+const (
+	arrowMetadataV5 = 4
+
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	arrowTypeTimestamp     = 10
+
+	arrowPrecisionDouble = 2
+	arrowUnitMicrosecond = 2
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+)
+
+const arrowMagic = "ARROW1"
+
+// WriteArrow writes a table in the Arrow IPC file format, as a single
+// record batch.
+func WriteArrow(w io.Writer, t *Table) error {
+	var file bytes.Buffer
+	file.WriteString(arrowMagic)
+	file.Write([]byte{0, 0})
+
+	schema := arrowSchema(t)
+	arrowMessage(&file, arrowHeaderSchema, schema, nil)
+
+	body, nodes, buffers := arrowBody(t)
+	batch := &fbTable{fields: []interface{}{
+		fbInt64(int64(t.Rows)),
+		fbStructs{data: nodes, n: len(t.Columns), align: 8},
+		fbStructs{data: buffers, n: len(buffers) / 16, align: 8},
+	}}
+	offset := int64(file.Len())
+	metaLen := arrowMessage(&file, arrowHeaderRecordBatch, batch, body)
+
+	// End of stream
+	file.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+
+	block := make([]byte, 24)
+	binary.LittleEndian.PutUint64(block[0:], uint64(offset))
+	binary.LittleEndian.PutUint32(block[8:], uint32(metaLen))
+	binary.LittleEndian.PutUint64(block[16:], uint64(len(body)))
+	footer := fbFinish(&fbTable{fields: []interface{}{
+		fbInt16(arrowMetadataV5),
+		arrowSchema(t),
+		fbStructs{n: 0, align: 8},
+		fbStructs{data: block, n: 1, align: 8},
+	}})
+	file.Write(footer)
+	binary.Write(&file, binary.LittleEndian, int32(len(footer)))
+	file.WriteString(arrowMagic)
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// arrowMessage writes an encapsulated message, returning the length of its
+// metadata including the prefix.
+func arrowMessage(buf *bytes.Buffer, headerType int8, header *fbTable, body []byte) int {
+	fb := fbFinish(&fbTable{fields: []interface{}{
+		fbInt16(arrowMetadataV5),
+		fbInt8(headerType),
+		header,
+		fbInt64(int64(len(body))),
+	}})
+	buf.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	binary.Write(buf, binary.LittleEndian, int32(len(fb)))
+	buf.Write(fb)
+	buf.Write(body)
+	return 8 + len(fb)
+}
+
+func arrowSchema(t *Table) *fbTable {
+	fields := fbTables{}
+	for _, c := range t.Columns {
+		var typeType int8
+		var typ *fbTable
+		switch c.Type {
+		case Type_Int64:
+			typeType = arrowTypeInt
+			typ = &fbTable{fields: []interface{}{fbInt32(64), fbBool(true)}}
+		case Type_Float64:
+			typeType = arrowTypeFloatingPoint
+			typ = &fbTable{fields: []interface{}{fbInt16(arrowPrecisionDouble)}}
+		case Type_Bool:
+			typeType = arrowTypeBool
+			typ = &fbTable{}
+		case Type_Timestamp:
+			typeType = arrowTypeTimestamp
+			typ = &fbTable{fields: []interface{}{fbInt16(arrowUnitMicrosecond), fbString("UTC")}}
+		default:
+			typeType = arrowTypeUtf8
+			typ = &fbTable{}
+		}
+		fields = append(fields, &fbTable{fields: []interface{}{
+			fbString(c.Name),
+			fbBool(true),
+			fbInt8(typeType),
+			typ,
+			nil,
+			fbTables{},
+		}})
+	}
+	return &fbTable{fields: []interface{}{
+		fbInt16(0), // little-endian
+		fields,
+	}}
+}
+
+// arrowBody returns the record batch body, and its FieldNode and Buffer
+// structs.
+func arrowBody(t *Table) ([]byte, []byte, []byte) {
+	var body, nodes, buffers bytes.Buffer
+	addBuffer := func(data []byte) {
+		binary.Write(&buffers, binary.LittleEndian, int64(body.Len()))
+		binary.Write(&buffers, binary.LittleEndian, int64(len(data)))
+		body.Write(data)
+		for body.Len()%8 != 0 {
+			body.WriteByte(0)
+		}
+	}
+
+	for _, c := range t.Columns {
+		n := len(c.Values)
+		validity := make([]byte, (n+7)/8)
+		nulls := 0
+		for idx, v := range c.Values {
+			if v == nil {
+				nulls++
+			} else {
+				validity[idx/8] |= 1 << uint(idx%8)
+			}
+		}
+		binary.Write(&nodes, binary.LittleEndian, int64(n))
+		binary.Write(&nodes, binary.LittleEndian, int64(nulls))
+		addBuffer(validity)
+
+		switch c.Type {
+		case Type_String:
+			offsets := make([]byte, 4*(n+1))
+			var data bytes.Buffer
+			for idx, v := range c.Values {
+				if s, ok := v.(string); ok {
+					data.WriteString(s)
+				}
+				binary.LittleEndian.PutUint32(offsets[4*(idx+1):], uint32(data.Len()))
+			}
+			addBuffer(offsets)
+			addBuffer(data.Bytes())
+		case Type_Bool:
+			bits := make([]byte, (n+7)/8)
+			for idx, v := range c.Values {
+				if b, ok := v.(bool); ok && b {
+					bits[idx/8] |= 1 << uint(idx%8)
+				}
+			}
+			addBuffer(bits)
+		default:
+			data := make([]byte, 8*n)
+			for idx, v := range c.Values {
+				var x uint64
+				switch value := v.(type) {
+				case int64:
+					x = uint64(value)
+				case float64:
+					x = math.Float64bits(value)
+				case time.Time:
+					x = uint64(micros(value))
+				}
+				binary.LittleEndian.PutUint64(data[8*idx:], x)
+			}
+			addBuffer(data)
+		}
+	}
+	return body.Bytes(), nodes.Bytes(), buffers.Bytes()
+}
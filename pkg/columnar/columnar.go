@@ -0,0 +1,401 @@
+// Package columnar lays out run metadata as a table, one row per run, and
+// writes it in columnar formats for dataframe libraries: Parquet, with
+// WriteParquet, and the Arrow IPC file format, with WriteArrow. WriteCSV
+// writes a selection of the columns as CSV or TSV.
+//
+// The columns, in order, are:
+//
+//	commit_id                  string     workspace commit holding the run
+//	run_id                     string
+//	run_index                  int64      position of the run in the commit
+//	authority                  string     "workload", "derived" or "correction"
+//	description                string
+//	workload_file              string
+//	success                    bool
+//	error_message              string
+//	exec_start                 timestamp
+//	exec_end                   timestamp
+//	duration_seconds           float64
+//	input_files                string     JSON list of "FILE@VERSION"
+//	output_files               string     JSON list of filenames
+//	dataset_input_files        string     JSON object of dataset name to list of "FILE@VERSION"
+//	dataset_output_files       string     JSON object of dataset name to list of filenames
+//	labels                     string     JSON object
+//	commit_submitter_id        string
+//	commit_success             bool
+//	commit_message             string
+//	commit_workload_type       string
+//	commit_workload_image      string
+//	commit_workload_image_hash string
+//	commit_workload_command    string     JSON list
+//	commit_exec_start          timestamp
+//	commit_exec_end            timestamp
+//	commit_runner_name         string
+//	commit_runner_version      string
+//	commit_inputs              string     JSON object of dataset name to "DOT@VERSION"
+//	commit_outputs             string     JSON object of dataset name to "DOT@VERSION"
+//
+// followed by a param_NAME column for each parameter name, then a
+// metric_NAME column for each summary name, each group sorted by name.
+// These columns are int64 if every value of the key is an integer, float64
+// if every value is a number, bool if every value is "true" or "false",
+// and string otherwise. Timestamps are microseconds since the epoch, in
+// UTC. Every column is nullable; missing and empty values are null.
+//
+// As these types are inferred from the runs exported, a column's type can
+// change from one export to the next: a single "adam" among the numbers
+// of a parameter makes its column a string column. Set
+// BuildOptions.StringValues to make every param_ and metric_ column a
+// string column, for a schema that doesn't depend on the values.
+package columnar
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// type Type is the type of a column.
+type Type int
+
+const (
+	Type_String Type = iota
+	Type_Int64
+	Type_Float64
+	Type_Bool
+	Type_Timestamp
+)
+
+// type Column is a named, typed column. Each value is nil or, according to
+// the column's type, a string, int64, float64, bool or time.Time.
+type Column struct {
+	Name   string
+	Type   Type
+	Values []interface{}
+}
+
+// type Table is a set of columns of equal length.
+type Table struct {
+	Columns []Column
+	Rows    int
+}
+
+// Column returns the column with a name, or nil.
+func (t *Table) Column(name string) *Column {
+	for idx := range t.Columns {
+		if t.Columns[idx].Name == name {
+			return &t.Columns[idx]
+		}
+	}
+	return nil
+}
+
+// type RunFilter decides whether to include a run.
+type RunFilter func(c metadata.WorkspaceCommit, run metadata.RunMetadata) bool
+
+// Build lays out the runs of a sequence of workspace commits as a table.
+func Build(commits []metadata.WorkspaceCommit) *Table {
+	return BuildFiltered(commits, nil)
+}
+
+// BuildFiltered lays out the runs accepted by a filter as a table. Only
+// the parameters and summary keys of those runs become columns. A nil
+// filter accepts every run.
+func BuildFiltered(commits []metadata.WorkspaceCommit, filter RunFilter) *Table {
+	return BuildWithOptions(commits, BuildOptions{Filter: filter})
+}
+
+// type BuildOptions controls how runs are laid out as a table.
+type BuildOptions struct {
+	// Filter selects the runs to include; nil includes every run. Only
+	// the parameters and summary keys of those runs become columns.
+	Filter RunFilter
+
+	// StringValues makes every param_ and metric_ column a string
+	// column, holding the values as they were recorded, rather than
+	// inferring their types.
+	StringValues bool
+}
+
+// BuildWithOptions lays out the runs of a sequence of workspace commits as
+// a table.
+func BuildWithOptions(commits []metadata.WorkspaceCommit, opts BuildOptions) *Table {
+	keep := func(c metadata.WorkspaceCommit, run metadata.RunMetadata) bool {
+		return opts.Filter == nil || opts.Filter(c, run)
+	}
+	typeOf := func(values []string) Type {
+		if opts.StringValues {
+			return Type_String
+		}
+		return inferType(values)
+	}
+	t := &Table{}
+	columns := map[string]int{}
+	add := func(name string, typ Type) {
+		columns[name] = len(t.Columns)
+		t.Columns = append(t.Columns, Column{Name: name, Type: typ, Values: []interface{}{}})
+	}
+	for _, c := range fixedColumns {
+		add(c.name, c.typ)
+	}
+
+	params := map[string][]string{}
+	metrics := map[string][]string{}
+	for _, c := range commits {
+		for _, run := range c.Metadata.Runs {
+			if !keep(c, run) {
+				continue
+			}
+			for k := range run.Parameters {
+				params[k] = append(params[k], run.Parameters[k])
+			}
+			for k := range run.Summary {
+				metrics[k] = append(metrics[k], run.Summary[k])
+			}
+		}
+	}
+	paramTypes := map[string]Type{}
+	for _, k := range sortedKeys(params) {
+		paramTypes[k] = typeOf(params[k])
+		add("param_"+k, paramTypes[k])
+	}
+	metricTypes := map[string]Type{}
+	for _, k := range sortedKeys(metrics) {
+		metricTypes[k] = typeOf(metrics[k])
+		add("metric_"+k, metricTypes[k])
+	}
+
+	for _, c := range commits {
+		for idx, run := range c.Metadata.Runs {
+			if !keep(c, run) {
+				continue
+			}
+			row := make([]interface{}, len(t.Columns))
+			for i, fc := range fixedColumns {
+				row[i] = nullable(fc.value(c, idx, run))
+			}
+			for k, v := range run.Parameters {
+				row[columns["param_"+k]] = parseValue(v, paramTypes[k])
+			}
+			for k, v := range run.Summary {
+				row[columns["metric_"+k]] = parseValue(v, metricTypes[k])
+			}
+			for i := range t.Columns {
+				t.Columns[i].Values = append(t.Columns[i].Values, row[i])
+			}
+			t.Rows++
+		}
+	}
+	return t
+}
+
+type fixedColumn struct {
+	name  string
+	typ   Type
+	value func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{}
+}
+
+var fixedColumns = []fixedColumn{
+	{"commit_id", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} { return c.CommitID }},
+	{"run_id", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} { return run.RunID }},
+	{"run_index", Type_Int64, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} { return int64(idx) }},
+	{"authority", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return run.Authority.String()
+	}},
+	{"description", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return run.Description
+	}},
+	{"workload_file", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return run.WorkloadFile
+	}},
+	{"success", Type_Bool, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} { return run.Success }},
+	{"error_message", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		if run.ErrorMessage == nil {
+			return nil
+		}
+		return *run.ErrorMessage
+	}},
+	{"exec_start", Type_Timestamp, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} { return run.ExecStart }},
+	{"exec_end", Type_Timestamp, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} { return run.ExecEnd }},
+	{"duration_seconds", Type_Float64, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		if run.ExecStart.IsZero() || run.ExecEnd.IsZero() {
+			return nil
+		}
+		return run.ExecEnd.Sub(run.ExecStart).Seconds()
+	}},
+	{"input_files", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return jsonValue(inputFiles(run.WorkspaceInputFiles))
+	}},
+	{"output_files", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return jsonValue(run.WorkspaceOutputFiles)
+	}},
+	{"dataset_input_files", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		m := map[string][]string{}
+		for name, ifs := range run.DatasetInputFiles {
+			m[name] = inputFiles(ifs)
+		}
+		return jsonValue(m)
+	}},
+	{"dataset_output_files", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return jsonValue(run.DatasetOutputFiles)
+	}},
+	{"labels", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return jsonValue(run.Labels)
+	}},
+	{"commit_submitter_id", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.SubmitterID
+	}},
+	{"commit_success", Type_Bool, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.Success
+	}},
+	{"commit_message", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.Message
+	}},
+	{"commit_workload_type", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.WorkloadType
+	}},
+	{"commit_workload_image", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.WorkloadImage
+	}},
+	{"commit_workload_image_hash", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.WorkloadImageHash
+	}},
+	{"commit_workload_command", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return jsonValue(c.Metadata.WorkloadCommand)
+	}},
+	{"commit_exec_start", Type_Timestamp, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.ExecStart
+	}},
+	{"commit_exec_end", Type_Timestamp, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.ExecEnd
+	}},
+	{"commit_runner_name", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.RunnerName
+	}},
+	{"commit_runner_version", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return c.Metadata.RunnerVersion
+	}},
+	{"commit_inputs", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return jsonValue(datasetVersions(c.Metadata.Inputs))
+	}},
+	{"commit_outputs", Type_String, func(c metadata.WorkspaceCommit, idx int, run metadata.RunMetadata) interface{} {
+		return jsonValue(datasetVersions(c.Metadata.Outputs))
+	}},
+}
+
+func inputFiles(ifs []metadata.InputFile) []string {
+	result := make([]string, len(ifs))
+	for idx, inf := range ifs {
+		result[idx] = inf.Filename + "@" + inf.Version
+	}
+	return result
+}
+
+func datasetVersions(m map[string]metadata.DatasetVersion) map[string]string {
+	result := map[string]string{}
+	for name, dsv := range m {
+		result[name] = string(dsv.ID) + "@" + dsv.Version
+	}
+	return result
+}
+
+// jsonValue encodes lists and maps as JSON, or returns nil if they are
+// empty.
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []string:
+		if len(value) == 0 {
+			return nil
+		}
+	case map[string]string:
+		if len(value) == 0 {
+			return nil
+		}
+	case map[string][]string:
+		if len(value) == 0 {
+			return nil
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// nullable maps empty strings and zero times to nil.
+func nullable(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string:
+		if value == "" {
+			return nil
+		}
+	case time.Time:
+		if value.IsZero() {
+			return nil
+		}
+	}
+	return v
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// inferType returns the narrowest type that every non-empty value parses
+// as.
+func inferType(values []string) Type {
+	isInt, isFloat, isBool := true, true, true
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			isInt = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			isFloat = false
+		}
+		if v != "true" && v != "false" {
+			isBool = false
+		}
+	}
+	switch {
+	case isInt:
+		return Type_Int64
+	case isFloat:
+		return Type_Float64
+	case isBool:
+		return Type_Bool
+	default:
+		return Type_String
+	}
+}
+
+func parseValue(v string, typ Type) interface{} {
+	if v == "" {
+		return nil
+	}
+	switch typ {
+	case Type_Int64:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	case Type_Float64:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case Type_Bool:
+		return v == "true"
+	default:
+		return v
+	}
+}
+
+// micros returns a time as microseconds since the epoch.
+func micros(t time.Time) int64 {
+	return t.Unix()*1000000 + int64(t.Nanosecond()/1000)
+}
@@ -0,0 +1,253 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testCommits() []metadata.WorkspaceCommit {
+	oops := "out of memory"
+	return []metadata.WorkspaceCommit{
+		metadata.WorkspaceCommit{
+			CommitID: "c1",
+			Metadata: metadata.CommitMetadata{
+				SubmitterID:   "452342",
+				Success:       true,
+				WorkloadImage: "busybox",
+				Inputs:        map[string]metadata.DatasetVersion{"b": metadata.DatasetVersion{ID: "dot-b", Version: "b1"}},
+				Runs: []metadata.RunMetadata{
+					metadata.RunMetadata{
+						RunID:               "r1",
+						Success:             true,
+						Parameters:          map[string]string{"smoothing": "1", "mode": "fast"},
+						Summary:             map[string]string{"rms_error": "0.057"},
+						WorkspaceInputFiles: []metadata.InputFile{metadata.InputFile{Filename: "foo.csv", Version: "w0"}},
+						ExecStart:           time.Date(2018, 10, 4, 13, 6, 7, 0, time.UTC),
+						ExecEnd:             time.Date(2018, 10, 4, 13, 6, 9, 500000000, time.UTC),
+					},
+					metadata.RunMetadata{
+						RunID:        "r2",
+						Authority:    metadata.RunAuthority_Correction,
+						ErrorMessage: &oops,
+						Parameters:   map[string]string{"smoothing": "2"},
+						Summary:      map[string]string{"rms_error": "1", "converged": "false"},
+					},
+				},
+			},
+		},
+		metadata.WorkspaceCommit{
+			CommitID: "c2",
+			Metadata: metadata.CommitMetadata{
+				SubmitterID: "452342",
+				Runs: []metadata.RunMetadata{
+					metadata.RunMetadata{
+						RunID:      "r3",
+						Success:    true,
+						Parameters: map[string]string{"smoothing": "3.5"},
+						Summary:    map[string]string{"converged": "true"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func testColumn(t *testing.T, table *Table, name string, typ Type, values []interface{}) {
+	c := table.Column(name)
+	if c == nil {
+		t.Errorf("Missing column %s", name)
+		return
+	}
+	if c.Type != typ {
+		t.Errorf("Column %s: wanted type %#v, got %#v", name, typ, c.Type)
+	}
+	if !reflect.DeepEqual(values, c.Values) {
+		t.Errorf("Column %s: wanted %#v, got %#v", name, values, c.Values)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	table := Build(testCommits())
+	if table.Rows != 3 {
+		t.Errorf("Wanted 3 rows, got %d", table.Rows)
+	}
+
+	names := []string{}
+	for _, c := range table.Columns[len(fixedColumns):] {
+		names = append(names, c.Name)
+	}
+	wanted := []string{"param_mode", "param_smoothing", "metric_converged", "metric_rms_error"}
+	if !reflect.DeepEqual(wanted, names) {
+		t.Errorf("Wanted %#v, got %#v", wanted, names)
+	}
+	if table.Columns[0].Name != "commit_id" || table.Columns[1].Name != "run_id" {
+		t.Errorf("Wanted commit_id and run_id first, got %s and %s", table.Columns[0].Name, table.Columns[1].Name)
+	}
+
+	testColumn(t, table, "commit_id", Type_String, []interface{}{"c1", "c1", "c2"})
+	testColumn(t, table, "run_index", Type_Int64, []interface{}{int64(0), int64(1), int64(0)})
+	testColumn(t, table, "authority", Type_String, []interface{}{"workload", "correction", "workload"})
+	testColumn(t, table, "error_message", Type_String, []interface{}{nil, "out of memory", nil})
+	testColumn(t, table, "exec_start", Type_Timestamp, []interface{}{time.Date(2018, 10, 4, 13, 6, 7, 0, time.UTC), nil, nil})
+	testColumn(t, table, "duration_seconds", Type_Float64, []interface{}{2.5, nil, nil})
+	testColumn(t, table, "input_files", Type_String, []interface{}{`["foo.csv@w0"]`, nil, nil})
+	testColumn(t, table, "commit_inputs", Type_String, []interface{}{`{"b":"dot-b@b1"}`, `{"b":"dot-b@b1"}`, nil})
+	testColumn(t, table, "param_mode", Type_String, []interface{}{"fast", nil, nil})
+	testColumn(t, table, "param_smoothing", Type_Float64, []interface{}{1.0, 2.0, 3.5})
+	testColumn(t, table, "metric_converged", Type_Bool, []interface{}{nil, false, true})
+	testColumn(t, table, "metric_rms_error", Type_Float64, []interface{}{0.057, 1.0, nil})
+}
+
+func TestInferType(t *testing.T) {
+	cases := []struct {
+		values []string
+		typ    Type
+	}{
+		{[]string{"1", "-2", ""}, Type_Int64},
+		{[]string{"1", "2.5", "1e3"}, Type_Float64},
+		{[]string{"true", "false"}, Type_Bool},
+		{[]string{"1", "true"}, Type_String},
+		{[]string{"adam"}, Type_String},
+	}
+	for _, c := range cases {
+		if typ := inferType(c.values); typ != c.typ {
+			t.Errorf("%#v: wanted %#v, got %#v", c.values, c.typ, typ)
+		}
+	}
+}
+
+func TestThriftWriter(t *testing.T) {
+	w := &thriftWriter{}
+	w.i32(1, 3)
+	w.binary(4, []byte("ab"))
+	w.beginStruct(20)
+	w.boolean(1, true)
+	w.endStruct()
+	w.i64(21, -1)
+	w.stop()
+	wanted := []byte{
+		0x15, 0x06, // field 1, i32 3
+		0x38, 0x02, 'a', 'b', // field 4, binary
+		0x0c, 0x28, // field 20, struct, in long form
+		0x11, 0x00, // field 1, true; stop
+		0x16, 0x01, // field 21, i64 -1
+		0x00,
+	}
+	if got := w.buf.Bytes(); !bytes.Equal(wanted, got) {
+		t.Errorf("Wanted %#v, got %#v", wanted, got)
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	table := Build(testCommits())
+	var buf bytes.Buffer
+	err := WriteParquet(&buf, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, columns := readParquet(buf.Bytes())
+
+	if meta[1] != int64(1) || meta[3] != int64(table.Rows) || string(meta[6].([]byte)) != parquetCreatedBy {
+		t.Errorf("Unexpected version, row count or creator in %#v", meta)
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != len(table.Columns)+1 || schema[0].(map[int16]interface{})[5] != int64(len(table.Columns)) {
+		t.Fatalf("Wanted a root and %d columns in the schema, got %#v", len(table.Columns), schema)
+	}
+	chunks := meta[4].([]interface{})[0].(map[int16]interface{})[1].([]interface{})
+	for idx, c := range table.Columns {
+		element := schema[idx+1].(map[int16]interface{})
+		if string(element[4].([]byte)) != c.Name {
+			t.Errorf("Wanted schema element %s, got %s", c.Name, element[4])
+		}
+		if element[1] != int64(parquetPhysicalType(c.Type)) || element[3] != int64(parquetOptional) {
+			t.Errorf("Column %s: unexpected type or repetition in %#v", c.Name, element)
+		}
+		if c.Type == Type_String && element[6] != int64(parquetConvertedUTF8) {
+			t.Errorf("Column %s: wanted UTF8, got %#v", c.Name, element)
+		}
+
+		cm := chunks[idx].(map[int16]interface{})[3].(map[int16]interface{})
+		path := cm[3].([]interface{})
+		if len(path) != 1 || string(path[0].([]byte)) != c.Name || cm[5] != int64(table.Rows) {
+			t.Errorf("Column %s: unexpected chunk metadata %#v", c.Name, cm)
+		}
+
+		if !reflect.DeepEqual(plainValues(c), columns[idx]) {
+			t.Errorf("Column %s: wanted %#v, got %#v", c.Name, plainValues(c), columns[idx])
+		}
+	}
+}
+
+func TestWriteArrow(t *testing.T) {
+	table := Build(testCommits())
+	var buf bytes.Buffer
+	err := WriteArrow(&buf, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	eos := []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+	footer := int(binary.LittleEndian.Uint32(b[len(b)-10:]))
+	if start := len(b) - 10 - footer; start%8 != 0 || !bytes.Equal(b[start-8:start], eos) {
+		t.Errorf("Wanted an aligned footer after the end of stream marker")
+	}
+
+	fields, columns := readArrow(b)
+	if len(fields) != len(table.Columns) {
+		t.Fatalf("Wanted %d fields, got %#v", len(table.Columns), fields)
+	}
+	types := map[Type]int8{
+		Type_String:    arrowTypeUtf8,
+		Type_Int64:     arrowTypeInt,
+		Type_Float64:   arrowTypeFloatingPoint,
+		Type_Bool:      arrowTypeBool,
+		Type_Timestamp: arrowTypeTimestamp,
+	}
+	for idx, c := range table.Columns {
+		wanted := arrowField{Name: c.Name, Nullable: true, TypeType: types[c.Type]}
+		if fields[idx] != wanted {
+			t.Errorf("Wanted %#v, got %#v", wanted, fields[idx])
+		}
+		if !reflect.DeepEqual(plainValues(c), columns[idx]) {
+			t.Errorf("Column %s: wanted %#v, got %#v", c.Name, plainValues(c), columns[idx])
+		}
+	}
+}
+
+func TestBuildStringValues(t *testing.T) {
+	table := BuildWithOptions(testCommits(), BuildOptions{StringValues: true})
+	testColumn(t, table, "param_smoothing", Type_String, []interface{}{"1", "2", "3.5"})
+	testColumn(t, table, "metric_converged", Type_String, []interface{}{nil, "false", "true"})
+	testColumn(t, table, "duration_seconds", Type_Float64, []interface{}{2.5, nil, nil})
+}
+
+func TestFlatbufferTable(t *testing.T) {
+	b := fbFinish(&fbTable{fields: []interface{}{fbInt16(4), nil, fbString("hi")}})
+	if len(b)%8 != 0 {
+		t.Errorf("Wanted a multiple of 8 bytes, got %d", len(b))
+	}
+
+	table := int(binary.LittleEndian.Uint32(b))
+	vtable := table - int(int32(binary.LittleEndian.Uint32(b[table:])))
+	field := func(id int) int {
+		return int(binary.LittleEndian.Uint16(b[vtable+4+2*id:]))
+	}
+	if field(1) != 0 {
+		t.Errorf("Wanted field 1 absent, got offset %d", field(1))
+	}
+	if v := binary.LittleEndian.Uint16(b[table+field(0):]); v != 4 {
+		t.Errorf("Wanted 4, got %d", v)
+	}
+	pos := table + field(2)
+	str := pos + int(binary.LittleEndian.Uint32(b[pos:]))
+	n := int(binary.LittleEndian.Uint32(b[str:]))
+	if got := string(b[str+4 : str+4+n]); got != "hi" {
+		t.Errorf("Wanted %#v, got %#v", "hi", got)
+	}
+}
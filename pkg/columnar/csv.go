@@ -0,0 +1,116 @@
+package columnar
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// DefaultCSVColumns are the columns WriteCSV writes if none are selected.
+var DefaultCSVColumns = []string{
+	"commit_id",
+	"run_id",
+	"authority",
+	"success",
+	"duration_seconds",
+	"commit_submitter_id",
+	"commit_workload_image",
+	"param_*",
+	"metric_*",
+}
+
+// type CSVOptions configures WriteCSV.
+type CSVOptions struct {
+	// Comma separates fields; zero means ',', and '\t' writes TSV.
+	Comma rune
+	// Columns selects columns by name, in order. A name ending in "*"
+	// selects every column with that prefix, in table order. A param_ or
+	// metric_ column that no run has is written empty. Defaults to
+	// DefaultCSVColumns.
+	Columns []string
+	// Filter selects the runs to write; nil writes every run.
+	Filter RunFilter
+}
+
+// WriteCSV writes the runs of a sequence of workspace commits as CSV, with
+// a header row of column names. Parameter and summary values are written
+// exactly as they were recorded. Null values are empty, timestamps are
+// RFC 3339 in UTC, and lists and maps are JSON as described in the package
+// documentation.
+func WriteCSV(w io.Writer, commits []metadata.WorkspaceCommit, opts CSVOptions) error {
+	t := BuildWithOptions(commits, BuildOptions{Filter: opts.Filter, StringValues: true})
+
+	names := opts.Columns
+	if len(names) == 0 {
+		names = DefaultCSVColumns
+	}
+	columns := []*Column{}
+	header := []string{}
+	for _, name := range names {
+		if strings.HasSuffix(name, "*") {
+			prefix := strings.TrimSuffix(name, "*")
+			for idx := range t.Columns {
+				if strings.HasPrefix(t.Columns[idx].Name, prefix) {
+					columns = append(columns, &t.Columns[idx])
+					header = append(header, t.Columns[idx].Name)
+				}
+			}
+			continue
+		}
+		c := t.Column(name)
+		if c == nil && !strings.HasPrefix(name, "param_") && !strings.HasPrefix(name, "metric_") {
+			return fmt.Errorf("unknown column %s", name)
+		}
+		columns = append(columns, c)
+		header = append(header, name)
+	}
+
+	cw := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for row := 0; row < t.Rows; row++ {
+		for idx, c := range columns {
+			if c == nil {
+				record[idx] = ""
+			} else {
+				record[idx] = formatValue(c.Values[row])
+			}
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
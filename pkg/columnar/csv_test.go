@@ -0,0 +1,82 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, testCommits(), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := "commit_id,run_id,authority,success,duration_seconds,commit_submitter_id,commit_workload_image,param_mode,param_smoothing,metric_converged,metric_rms_error\n" +
+		"c1,r1,workload,true,2.5,452342,busybox,fast,1,,0.057\n" +
+		"c1,r2,correction,false,,452342,busybox,,2,false,1\n" +
+		"c2,r3,workload,true,,452342,,,3.5,true,\n"
+	if got := buf.String(); got != wanted {
+		t.Errorf("Wanted %#v, got %#v", wanted, got)
+	}
+}
+
+func TestWriteCSVOptions(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, testCommits(), CSVOptions{
+		Comma:   '\t',
+		Columns: []string{"run_id", "exec_start", "param_*", "metric_converged"},
+		Filter: func(c metadata.WorkspaceCommit, run metadata.RunMetadata) bool {
+			return run.Success
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := "run_id\texec_start\tparam_mode\tparam_smoothing\tmetric_converged\n" +
+		"r1\t2018-10-04T13:06:07Z\tfast\t1\t\n" +
+		"r3\t\t\t3.5\ttrue\n"
+	if got := buf.String(); got != wanted {
+		t.Errorf("Wanted %#v, got %#v", wanted, got)
+	}
+
+	err = WriteCSV(&buf, testCommits(), CSVOptions{Columns: []string{"run_idd"}})
+	if err == nil {
+		t.Errorf("Wanted an error for an unknown column")
+	}
+}
+
+func TestWriteCSVValues(t *testing.T) {
+	values := map[string]string{"a": "1.0", "b": "007", "c": "1e-3", "d": "NaN", "e": " 2 "}
+	commits := []metadata.WorkspaceCommit{
+		metadata.WorkspaceCommit{
+			CommitID: "c1",
+			Metadata: metadata.CommitMetadata{
+				Runs: []metadata.RunMetadata{
+					metadata.RunMetadata{RunID: "r1", Parameters: values, Summary: values},
+				},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, commits, CSVOptions{Columns: []string{"param_*", "metric_*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Wanted a header and one row, got %#v", records)
+	}
+	for idx, name := range records[0] {
+		key := name[strings.Index(name, "_")+1:]
+		if got := records[1][idx]; got != values[key] {
+			t.Errorf("Column %s: wanted %#v, got %#v", name, values[key], got)
+		}
+	}
+}
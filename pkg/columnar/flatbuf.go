@@ -0,0 +1,198 @@
+package columnar
+
+import (
+	"encoding/binary"
+)
+
+// A minimal FlatBuffers serialiser, enough for Arrow IPC metadata. Objects
+// are laid out front to back: each table's vtable, then the table, then
+// the objects it refers to, so every offset points forwards.
+
+// type fbTable is a table; fields are indexed by field ID, and nil fields
+// are absent.
+type fbTable struct {
+	fields []interface{}
+}
+
+// type fbScalar is an inline scalar field; its alignment is its size.
+type fbScalar []byte
+
+// type fbStruct is an inline struct, with its alignment.
+type fbStruct struct {
+	data  []byte
+	align int
+}
+
+// type fbString is a string referred to by offset.
+type fbString string
+
+// type fbTables is a vector of tables.
+type fbTables []*fbTable
+
+// type fbStructs is a vector of structs, each of the same size.
+type fbStructs struct {
+	data  []byte
+	n     int
+	align int
+}
+
+func fbInt8(v int8) fbScalar { return fbScalar{byte(v)} }
+
+func fbBool(v bool) fbScalar {
+	if v {
+		return fbScalar{1}
+	}
+	return fbScalar{0}
+}
+
+func fbInt16(v int16) fbScalar {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(v))
+	return b
+}
+
+func fbInt32(v int32) fbScalar {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+func fbInt64(v int64) fbScalar {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) putUint32(pos int, v uint32) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], v)
+}
+
+// fbFinish serialises a root table, padded to a multiple of 8 bytes. The
+// buffer must be placed at an 8-byte aligned position.
+func fbFinish(root *fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	b.putUint32(0, uint32(b.place(root)))
+	b.pad(8)
+	return b.buf
+}
+
+// place writes an object and everything it refers to, returning the
+// object's position.
+func (b *fbBuilder) place(obj interface{}) int {
+	switch o := obj.(type) {
+	case *fbTable:
+		return b.placeTable(o)
+	case fbString:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = append(b.buf, 0, 0, 0, 0)
+		b.putUint32(pos, uint32(len(o)))
+		b.buf = append(b.buf, o...)
+		b.buf = append(b.buf, 0)
+		return pos
+	case fbTables:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = append(b.buf, make([]byte, 4+4*len(o))...)
+		b.putUint32(pos, uint32(len(o)))
+		for idx, t := range o {
+			slot := pos + 4 + 4*idx
+			b.putUint32(slot, uint32(b.place(t)-slot))
+		}
+		return pos
+	case fbStructs:
+		align := o.align
+		if align < 4 {
+			align = 4
+		}
+		for (len(b.buf)+4)%align != 0 {
+			b.buf = append(b.buf, 0)
+		}
+		pos := len(b.buf)
+		b.buf = append(b.buf, 0, 0, 0, 0)
+		b.putUint32(pos, uint32(o.n))
+		b.buf = append(b.buf, o.data...)
+		return pos
+	}
+	panic("unknown flatbuffer object")
+}
+
+func (b *fbBuilder) placeTable(t *fbTable) int {
+	// Lay out the inline fields, largest first, after the vtable offset.
+	// The table starts 8-byte aligned, so offsets within it are aligned.
+	type slot struct {
+		field int
+		size  int
+		align int
+		data  []byte
+		child interface{}
+	}
+	slots := []slot{}
+	for id, f := range t.fields {
+		switch v := f.(type) {
+		case nil:
+		case fbScalar:
+			slots = append(slots, slot{field: id, size: len(v), align: len(v), data: v})
+		case fbStruct:
+			slots = append(slots, slot{field: id, size: len(v.data), align: v.align, data: v.data})
+		default:
+			slots = append(slots, slot{field: id, size: 4, align: 4, child: v})
+		}
+	}
+	for i := 1; i < len(slots); i++ {
+		for j := i; j > 0 && slots[j].align > slots[j-1].align; j-- {
+			slots[j], slots[j-1] = slots[j-1], slots[j]
+		}
+	}
+
+	offsets := make([]int, len(t.fields))
+	size := 4
+	for idx := range slots {
+		for size%slots[idx].align != 0 {
+			size++
+		}
+		offsets[slots[idx].field] = size
+		size += slots[idx].size
+	}
+
+	// The vtable
+	b.pad(2)
+	vtable := len(b.buf)
+	vt := make([]byte, 4+2*len(t.fields))
+	binary.LittleEndian.PutUint16(vt[0:], uint16(len(vt)))
+	binary.LittleEndian.PutUint16(vt[2:], uint16(size))
+	for id, off := range offsets {
+		binary.LittleEndian.PutUint16(vt[4+2*id:], uint16(off))
+	}
+	b.buf = append(b.buf, vt...)
+
+	// The table
+	b.pad(8)
+	table := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	b.putUint32(table, uint32(int32(table-vtable)))
+	for _, s := range slots {
+		if s.child == nil {
+			copy(b.buf[table+offsets[s.field]:], s.data)
+		}
+	}
+
+	// What it refers to
+	for _, s := range slots {
+		if s.child != nil {
+			pos := table + offsets[s.field]
+			b.putUint32(pos, uint32(b.place(s.child)-pos))
+		}
+	}
+	return table
+}
@@ -0,0 +1,312 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Parquet format constants, from parquet.thrift
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMicros = 10
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetPageData          = 0
+)
+
+const parquetCreatedBy = "dotscience-metadata"
+
+// WriteParquet writes a table as a Parquet file, in a single row group
+// with one uncompressed, plain-encoded page per column.
+func WriteParquet(w io.Writer, t *Table) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunk, len(t.Columns))
+	for idx, c := range t.Columns {
+		page := parquetPage(c)
+
+		header := &thriftWriter{}
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.beginStruct(5)
+		header.i32(1, int32(len(c.Values)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunks[idx] = chunk{offset: int64(file.Len()), size: int64(header.buf.Len() + len(page))}
+		file.Write(header.buf.Bytes())
+		file.Write(page)
+	}
+
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+
+	meta.beginList(2, thriftStruct, len(t.Columns)+1)
+	meta.beginElement()
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(t.Columns)))
+	meta.endStruct()
+	for _, c := range t.Columns {
+		meta.beginElement()
+		meta.i32(1, parquetPhysicalType(c.Type))
+		meta.i32(3, parquetOptional)
+		meta.binary(4, []byte(c.Name))
+		switch c.Type {
+		case Type_String:
+			meta.i32(6, parquetConvertedUTF8)
+			meta.beginStruct(10)
+			meta.beginStruct(1) // STRING
+			meta.endStruct()
+			meta.endStruct()
+		case Type_Timestamp:
+			meta.i32(6, parquetConvertedTimestampMicros)
+			meta.beginStruct(10)
+			meta.beginStruct(8) // TIMESTAMP
+			meta.boolean(1, true)
+			meta.beginStruct(2)
+			meta.beginStruct(2) // MICROS
+			meta.endStruct()
+			meta.endStruct()
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.endStruct()
+	}
+
+	meta.i64(3, int64(t.Rows))
+
+	var total int64
+	for _, ch := range chunks {
+		total += ch.size
+	}
+	meta.beginList(4, thriftStruct, 1)
+	meta.beginElement()
+	meta.beginList(1, thriftStruct, len(t.Columns))
+	for idx, c := range t.Columns {
+		meta.beginElement()
+		meta.i64(2, chunks[idx].offset)
+		meta.beginStruct(3)
+		meta.i32(1, parquetPhysicalType(c.Type))
+		meta.beginList(2, thriftI32, 2)
+		meta.listI32(parquetEncodingPlain)
+		meta.listI32(parquetEncodingRLE)
+		meta.beginList(3, thriftBinary, 1)
+		meta.listBinary([]byte(c.Name))
+		meta.i32(4, parquetCodecUncompressed)
+		meta.i64(5, int64(len(c.Values)))
+		meta.i64(6, chunks[idx].size)
+		meta.i64(7, chunks[idx].size)
+		meta.i64(9, chunks[idx].offset)
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(t.Rows))
+	meta.endStruct()
+
+	meta.binary(6, []byte(parquetCreatedBy))
+	meta.stop()
+
+	file.Write(meta.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.buf.Len()))
+	file.WriteString("PAR1")
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+func parquetPhysicalType(typ Type) int32 {
+	switch typ {
+	case Type_Int64, Type_Timestamp:
+		return parquetInt64
+	case Type_Float64:
+		return parquetDouble
+	case Type_Bool:
+		return parquetBoolean
+	default:
+		return parquetByteArray
+	}
+}
+
+// parquetPage encodes a column's definition levels and non-null values.
+func parquetPage(c Column) []byte {
+	var page bytes.Buffer
+
+	// Definition levels, as runs in the RLE/bit-packing hybrid encoding
+	// with a bit width of 1, prefixed by their length
+	var levels bytes.Buffer
+	for start := 0; start < len(c.Values); {
+		present := c.Values[start] != nil
+		end := start
+		for end < len(c.Values) && (c.Values[end] != nil) == present {
+			end++
+		}
+		writeUvarint(&levels, uint64(end-start)<<1)
+		if present {
+			levels.WriteByte(1)
+		} else {
+			levels.WriteByte(0)
+		}
+		start = end
+	}
+	binary.Write(&page, binary.LittleEndian, uint32(levels.Len()))
+	page.Write(levels.Bytes())
+
+	var bits byte
+	var nbits uint
+	for _, v := range c.Values {
+		switch value := v.(type) {
+		case nil:
+			continue
+		case string:
+			binary.Write(&page, binary.LittleEndian, uint32(len(value)))
+			page.WriteString(value)
+		case int64:
+			binary.Write(&page, binary.LittleEndian, value)
+		case float64:
+			binary.Write(&page, binary.LittleEndian, math.Float64bits(value))
+		case time.Time:
+			binary.Write(&page, binary.LittleEndian, micros(value))
+		case bool:
+			if value {
+				bits |= 1 << nbits
+			}
+			nbits++
+			if nbits == 8 {
+				page.WriteByte(bits)
+				bits, nbits = 0, 0
+			}
+		}
+	}
+	if nbits > 0 {
+		page.WriteByte(bits)
+	}
+	return page.Bytes()
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, v)
+	buf.Write(b[:n])
+}
+
+// Thrift compact protocol type codes
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// type thriftWriter encodes structs in the Thrift compact protocol, as
+// used by Parquet metadata. Fields must be written in increasing order of
+// ID within each struct.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	last   int16
+	parent []int16
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	delta := id - w.last
+	if delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		writeUvarint(&w.buf, uint64((uint16(id)<<1)^uint16(id>>15)))
+	}
+	w.last = id
+}
+
+func (w *thriftWriter) varint(v int64) {
+	writeUvarint(&w.buf, uint64((v<<1)^(v>>63)))
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) binary(id int16, b []byte) {
+	w.field(id, thriftBinary)
+	writeUvarint(&w.buf, uint64(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		w.field(id, thriftTrue)
+	} else {
+		w.field(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftStruct)
+	w.beginElement()
+}
+
+// beginElement starts a struct that is an element of a list.
+func (w *thriftWriter) beginElement() {
+	w.parent = append(w.parent, w.last)
+	w.last = 0
+}
+
+func (w *thriftWriter) endStruct() {
+	w.stop()
+	w.last = w.parent[len(w.parent)-1]
+	w.parent = w.parent[:len(w.parent)-1]
+}
+
+func (w *thriftWriter) stop() {
+	w.buf.WriteByte(0)
+}
+
+func (w *thriftWriter) beginList(id int16, elemType byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		writeUvarint(&w.buf, uint64(n))
+	}
+}
+
+func (w *thriftWriter) listI32(v int32) {
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) listBinary(b []byte) {
+	writeUvarint(&w.buf, uint64(len(b)))
+	w.buf.Write(b)
+}
@@ -0,0 +1,347 @@
+package columnar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Readers for the formats the writers produce, written from the Thrift,
+// Parquet, FlatBuffers and Arrow specifications, so that tests can check
+// files by decoding them.
+
+// type thriftReader decodes the Thrift compact protocol. Structs decode to
+// maps from field ID to value; integers of every size decode to int64,
+// binary to []byte, and lists to []interface{}.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.b) {
+		panic("thrift: unexpected end of data")
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		panic("thrift: bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos-8:]))
+	case 8:
+		n := int(r.uvarint())
+		r.pos += n
+		return r.b[r.pos-n : r.pos]
+	case 9:
+		header := r.byte()
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		elemType := header & 0x0f
+		list := make([]interface{}, n)
+		for idx := range list {
+			if elemType == 1 || elemType == 2 {
+				// Booleans in lists are a byte each
+				list[idx] = r.byte() == 1
+			} else {
+				list[idx] = r.value(elemType)
+			}
+		}
+		return list
+	case 12:
+		return r.structure()
+	default:
+		panic(fmt.Sprintf("thrift: unsupported type %d", typ))
+	}
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	result := map[int16]interface{}{}
+	var id int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return result
+		}
+		if delta := header >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			id = int16(r.zigzag())
+		}
+		result[id] = r.value(header & 0x0f)
+	}
+}
+
+// readParquet decodes a Parquet file written by WriteParquet: its
+// FileMetaData, and the values of each column, which are read from the
+// page headers and pages the metadata points to.
+func readParquet(b []byte) (map[int16]interface{}, [][]interface{}) {
+	if string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		panic("parquet: missing magic numbers")
+	}
+	footer := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta := (&thriftReader{b: b[len(b)-8-footer : len(b)-8]}).structure()
+
+	columns := [][]interface{}{}
+	rowGroup := meta[4].([]interface{})[0].(map[int16]interface{})
+	for _, cc := range rowGroup[1].([]interface{}) {
+		cm := cc.(map[int16]interface{})[3].(map[int16]interface{})
+		r := &thriftReader{b: b, pos: int(cm[9].(int64))}
+		header := r.structure()
+		if header[1].(int64) != parquetPageData {
+			panic("parquet: not a data page")
+		}
+		page := b[r.pos : r.pos+int(header[3].(int64))]
+		n := int(header[5].(map[int16]interface{})[1].(int64))
+		columns = append(columns, readParquetPage(page, n, cm[1].(int64)))
+	}
+	return meta, columns
+}
+
+// readParquetPage decodes n values of a physical type from a page with
+// RLE definition levels and plain values.
+func readParquetPage(page []byte, n int, physicalType int64) []interface{} {
+	levelsLen := int(binary.LittleEndian.Uint32(page))
+	r := &thriftReader{b: page[4 : 4+levelsLen]}
+	defined := []bool{}
+	for len(defined) < n {
+		header := r.uvarint()
+		count := int(header >> 1)
+		if header&1 == 0 {
+			// A run of one level
+			level := r.byte() == 1
+			for i := 0; i < count; i++ {
+				defined = append(defined, level)
+			}
+		} else {
+			// Groups of eight bit-packed levels
+			for i := 0; i < count; i++ {
+				bits := r.byte()
+				for j := uint(0); j < 8; j++ {
+					defined = append(defined, bits&(1<<j) != 0)
+				}
+			}
+		}
+	}
+
+	data := page[4+levelsLen:]
+	pos, bit := 0, uint(0)
+	values := make([]interface{}, n)
+	for idx := range values {
+		if !defined[idx] {
+			continue
+		}
+		switch physicalType {
+		case parquetByteArray:
+			l := int(binary.LittleEndian.Uint32(data[pos:]))
+			values[idx] = string(data[pos+4 : pos+4+l])
+			pos += 4 + l
+		case parquetInt64:
+			values[idx] = int64(binary.LittleEndian.Uint64(data[pos:]))
+			pos += 8
+		case parquetDouble:
+			values[idx] = math.Float64frombits(binary.LittleEndian.Uint64(data[pos:]))
+			pos += 8
+		case parquetBoolean:
+			values[idx] = data[pos]&(1<<bit) != 0
+			bit++
+			if bit == 8 {
+				pos, bit = pos+1, 0
+			}
+		}
+	}
+	return values
+}
+
+// type fbReader reads FlatBuffers tables.
+type fbReader struct {
+	b []byte
+}
+
+// root returns the position of the root table.
+func (r fbReader) root() int {
+	return int(binary.LittleEndian.Uint32(r.b))
+}
+
+// field returns the position of a table's field, or 0 if it is absent.
+func (r fbReader) field(table, id int) int {
+	vtable := table - int(int32(binary.LittleEndian.Uint32(r.b[table:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(r.b[vtable:])) {
+		return 0
+	}
+	offset := int(binary.LittleEndian.Uint16(r.b[vtable+4+2*id:]))
+	if offset == 0 {
+		return 0
+	}
+	return table + offset
+}
+
+func (r fbReader) int8(table, id int) int8 {
+	if pos := r.field(table, id); pos != 0 {
+		return int8(r.b[pos])
+	}
+	return 0
+}
+
+func (r fbReader) int16(table, id int) int16 {
+	if pos := r.field(table, id); pos != 0 {
+		return int16(binary.LittleEndian.Uint16(r.b[pos:]))
+	}
+	return 0
+}
+
+func (r fbReader) int64(table, id int) int64 {
+	if pos := r.field(table, id); pos != 0 {
+		return int64(binary.LittleEndian.Uint64(r.b[pos:]))
+	}
+	return 0
+}
+
+// ref follows the offset in a field to a table, string or vector.
+func (r fbReader) ref(table, id int) int {
+	pos := r.field(table, id)
+	return pos + int(binary.LittleEndian.Uint32(r.b[pos:]))
+}
+
+func (r fbReader) string(table, id int) string {
+	pos := r.ref(table, id)
+	n := int(binary.LittleEndian.Uint32(r.b[pos:]))
+	return string(r.b[pos+4 : pos+4+n])
+}
+
+// vector returns the length of a vector and the position of its first
+// element.
+func (r fbReader) vector(table, id int) (int, int) {
+	pos := r.ref(table, id)
+	return int(binary.LittleEndian.Uint32(r.b[pos:])), pos + 4
+}
+
+// tables returns the positions of the tables in a vector of tables.
+func (r fbReader) tables(table, id int) []int {
+	n, pos := r.vector(table, id)
+	result := make([]int, n)
+	for idx := range result {
+		elem := pos + 4*idx
+		result[idx] = elem + int(binary.LittleEndian.Uint32(r.b[elem:]))
+	}
+	return result
+}
+
+// type arrowField is a field of an Arrow schema.
+type arrowField struct {
+	Name     string
+	Nullable bool
+	TypeType int8
+}
+
+// readArrow decodes an Arrow IPC file written by WriteArrow: the fields
+// of the schema in its footer, and the values of each column, read from
+// the record batch the footer points to. Timestamps decode to int64.
+func readArrow(b []byte) ([]arrowField, [][]interface{}) {
+	if string(b[:8]) != arrowMagic+"\x00\x00" || string(b[len(b)-6:]) != arrowMagic {
+		panic("arrow: missing magic numbers")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(b[len(b)-10:]))
+	footer := fbReader{b: b[len(b)-10-footerLen : len(b)-10]}
+	schema := footer.ref(footer.root(), 1)
+	fields := []arrowField{}
+	for _, f := range footer.tables(schema, 1) {
+		fields = append(fields, arrowField{
+			Name:     footer.string(f, 0),
+			Nullable: footer.int8(f, 1) == 1,
+			TypeType: footer.int8(f, 2),
+		})
+	}
+
+	_, blocks := footer.vector(footer.root(), 3)
+	offset := int(binary.LittleEndian.Uint64(footer.b[blocks:]))
+	metaLen := int(binary.LittleEndian.Uint32(footer.b[blocks+8:]))
+	if binary.LittleEndian.Uint32(b[offset:]) != 0xffffffff {
+		panic("arrow: no continuation marker")
+	}
+	message := fbReader{b: b[offset+8 : offset+metaLen]}
+	if message.int8(message.root(), 1) != arrowHeaderRecordBatch {
+		panic("arrow: not a record batch")
+	}
+	batch := message.ref(message.root(), 2)
+	body := b[offset+metaLen:]
+	rows := int(message.int64(batch, 0))
+	_, buffers := message.vector(batch, 2)
+	buffer := func() []byte {
+		start := int(binary.LittleEndian.Uint64(message.b[buffers:]))
+		length := int(binary.LittleEndian.Uint64(message.b[buffers+8:]))
+		buffers += 16
+		return body[start : start+length]
+	}
+
+	columns := [][]interface{}{}
+	for _, f := range fields {
+		validity := buffer()
+		values := make([]interface{}, rows)
+		var offsets, data []byte
+		if f.TypeType == arrowTypeUtf8 {
+			offsets = buffer()
+		}
+		data = buffer()
+		for idx := range values {
+			if validity[idx/8]&(1<<uint(idx%8)) == 0 {
+				continue
+			}
+			switch f.TypeType {
+			case arrowTypeUtf8:
+				start := binary.LittleEndian.Uint32(offsets[4*idx:])
+				end := binary.LittleEndian.Uint32(offsets[4*idx+4:])
+				values[idx] = string(data[start:end])
+			case arrowTypeBool:
+				values[idx] = data[idx/8]&(1<<uint(idx%8)) != 0
+			case arrowTypeFloatingPoint:
+				values[idx] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*idx:]))
+			default:
+				values[idx] = int64(binary.LittleEndian.Uint64(data[8*idx:]))
+			}
+		}
+		columns = append(columns, values)
+	}
+	return fields, columns
+}
+
+// plainValues returns a column's values with timestamps as microseconds
+// since the epoch, as they are stored.
+func plainValues(c Column) []interface{} {
+	result := make([]interface{}, len(c.Values))
+	for idx, v := range c.Values {
+		if t, ok := v.(time.Time); ok {
+			result[idx] = micros(t)
+		} else {
+			result[idx] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,314 @@
+// Package ds lets Go workloads declare Dotscience runs. It mirrors the
+// Python library: call Start, record inputs, outputs, parameters and
+// results as the workload goes, then Publish. Publish prints an annotation
+// block to stdout, which the agent reads back with
+// metadata.NewAnnotationScanner.
+//
+//	ds.Start()
+//	f, err := os.Open(ds.Input("data.csv"))
+//	...
+//	ds.Parameter("smoothing", 0.5)
+//	ds.Summary("rms_error", rms)
+//	ds.Publish("Trained the model")
+package ds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// type Run collects the metadata for one run, and writes its annotation
+// block when published. After Publish, the Run starts afresh, so one Run
+// can be used for a series of runs. A Run may be used from several
+// goroutines at once.
+type Run struct {
+	mu sync.Mutex
+
+	w   io.Writer
+	now func() time.Time
+
+	started    bool
+	id         string
+	annotation metadata.RunAnnotation
+}
+
+// NewRun returns a Run that publishes to w.
+func NewRun(w io.Writer) *Run {
+	return &Run{w: w, now: time.Now}
+}
+
+// Start begins a new run, discarding anything recorded since the last
+// Publish, and stamps its start time. Calling Start is optional; recording
+// anything starts the run if needed.
+func (r *Run) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start()
+}
+
+func (r *Run) start() {
+	r.started = true
+	r.id = metadata.NewRunID()
+	r.annotation = metadata.RunAnnotation{
+		Version: metadata.AnnotationVersion,
+		Start:   r.now().UTC().Format(metadata.TimeFormat),
+	}
+}
+
+// edit starts the run if need be, and applies f to its annotation.
+func (r *Run) edit(f func(a *metadata.RunAnnotation)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		r.start()
+	}
+	f(&r.annotation)
+}
+
+// ID returns the ID of the current run, starting it if need be.
+func (r *Run) ID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		r.start()
+	}
+	return r.id
+}
+
+// Input records that the run read a file in the workspace, and returns the
+// filename.
+func (r *Run) Input(filename string) string {
+	r.edit(func(a *metadata.RunAnnotation) {
+		a.Input = addFile(a.Input, filename)
+	})
+	return filename
+}
+
+// Output records that the run wrote a file in the workspace, and returns
+// the filename.
+func (r *Run) Output(filename string) string {
+	r.edit(func(a *metadata.RunAnnotation) {
+		a.Output = addFile(a.Output, filename)
+	})
+	return filename
+}
+
+// DatasetInput records that the run read a file in the named dataset, and
+// returns the filename.
+func (r *Run) DatasetInput(dataset, filename string) string {
+	r.edit(func(a *metadata.RunAnnotation) {
+		if a.DatasetInput == nil {
+			a.DatasetInput = map[string][]string{}
+		}
+		a.DatasetInput[dataset] = addFile(a.DatasetInput[dataset], filename)
+	})
+	return filename
+}
+
+// DatasetOutput records that the run wrote a file in the named dataset,
+// and returns the filename.
+func (r *Run) DatasetOutput(dataset, filename string) string {
+	r.edit(func(a *metadata.RunAnnotation) {
+		if a.DatasetOutput == nil {
+			a.DatasetOutput = map[string][]string{}
+		}
+		a.DatasetOutput[dataset] = addFile(a.DatasetOutput[dataset], filename)
+	})
+	return filename
+}
+
+// Parameter records a parameter of the run. The value is recorded as
+// formatted by fmt.Sprint.
+func (r *Run) Parameter(name string, value interface{}) {
+	r.edit(func(a *metadata.RunAnnotation) {
+		a.Parameters = setValue(a.Parameters, name, value)
+	})
+}
+
+// Summary records a result of the run, such as a metric. The value is
+// recorded as formatted by fmt.Sprint.
+func (r *Run) Summary(name string, value interface{}) {
+	r.edit(func(a *metadata.RunAnnotation) {
+		a.Summary = setValue(a.Summary, name, value)
+	})
+}
+
+// Label attaches a label to the run.
+func (r *Run) Label(name, value string) {
+	r.edit(func(a *metadata.RunAnnotation) {
+		a.Labels = setValue(a.Labels, name, value)
+	})
+}
+
+// Description sets the description of the run; Publish overrides it if
+// given one.
+func (r *Run) Description(description string) {
+	r.edit(func(a *metadata.RunAnnotation) {
+		a.Description = description
+	})
+}
+
+// WorkloadFile records the source file of the run, such as a script or
+// notebook.
+func (r *Run) WorkloadFile(filename string) {
+	r.edit(func(a *metadata.RunAnnotation) {
+		a.WorkloadFile = filename
+	})
+}
+
+// Error marks the run as failed with err, or clears the failure if err is
+// nil.
+func (r *Run) Error(err error) {
+	r.edit(func(a *metadata.RunAnnotation) {
+		if err == nil {
+			a.Error = nil
+		} else {
+			message := err.Error()
+			a.Error = &message
+		}
+	})
+}
+
+// Publish stamps the run's end time and writes its annotation block. If
+// description is not empty, it becomes the run's description. The Run is
+// then ready to record the next run.
+func (r *Run) Publish(description string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		r.start()
+	}
+	if description != "" {
+		r.annotation.Description = description
+	}
+	r.annotation.End = r.now().UTC().Format(metadata.TimeFormat)
+
+	body, err := json.MarshalIndent(r.annotation, "", "  ")
+	if err != nil {
+		return err
+	}
+	body = escapeBrackets(body)
+	_, err = fmt.Fprintf(r.w, "\n%s%s]]%s%s%s]]\n", metadata.AnnotationStart, r.id, body, metadata.AnnotationEnd, r.id)
+	if err != nil {
+		return err
+	}
+	r.started = false
+	return nil
+}
+
+// escapeBrackets escapes "[" inside the strings of a JSON document as
+// \u005b, so that no value can contain the marker that ends an annotation
+// block. The brackets of JSON lists are left alone.
+func escapeBrackets(body []byte) []byte {
+	result := make([]byte, 0, len(body))
+	inString, escaped := false, false
+	for _, c := range body {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString && c == '[':
+			result = append(result, `\u005b`...)
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func addFile(files []string, filename string) []string {
+	for _, f := range files {
+		if f == filename {
+			return files
+		}
+	}
+	return append(files, filename)
+}
+
+func setValue(values map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	values[name] = fmt.Sprint(value)
+	return values
+}
+
+var defaultRun = NewRun(os.Stdout)
+
+// Start begins a new run; see Run.Start.
+func Start() {
+	defaultRun.Start()
+}
+
+// ID returns the ID of the current run.
+func ID() string {
+	return defaultRun.ID()
+}
+
+// Input records a workspace input file of the current run, and returns the
+// filename.
+func Input(filename string) string {
+	return defaultRun.Input(filename)
+}
+
+// Output records a workspace output file of the current run, and returns
+// the filename.
+func Output(filename string) string {
+	return defaultRun.Output(filename)
+}
+
+// DatasetInput records a dataset input file of the current run, and
+// returns the filename.
+func DatasetInput(dataset, filename string) string {
+	return defaultRun.DatasetInput(dataset, filename)
+}
+
+// DatasetOutput records a dataset output file of the current run, and
+// returns the filename.
+func DatasetOutput(dataset, filename string) string {
+	return defaultRun.DatasetOutput(dataset, filename)
+}
+
+// Parameter records a parameter of the current run.
+func Parameter(name string, value interface{}) {
+	defaultRun.Parameter(name, value)
+}
+
+// Summary records a result of the current run.
+func Summary(name string, value interface{}) {
+	defaultRun.Summary(name, value)
+}
+
+// Label attaches a label to the current run.
+func Label(name, value string) {
+	defaultRun.Label(name, value)
+}
+
+// Description sets the description of the current run.
+func Description(description string) {
+	defaultRun.Description(description)
+}
+
+// WorkloadFile records the source file of the current run.
+func WorkloadFile(filename string) {
+	defaultRun.WorkloadFile(filename)
+}
+
+// Error marks the current run as failed with err.
+func Error(err error) {
+	defaultRun.Error(err)
+}
+
+// Publish writes the current run's annotation block to stdout.
+func Publish(description string) error {
+	return defaultRun.Publish(description)
+}
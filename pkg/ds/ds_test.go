@@ -0,0 +1,117 @@
+package ds
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testClock() func() time.Time {
+	t := time.Date(2018, 10, 4, 13, 6, 0, 500000000, time.UTC)
+	return func() time.Time {
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func TestPublishRoundTrip(t *testing.T) {
+	var out bytes.Buffer
+	r := NewRun(&out)
+	r.now = testClock()
+
+	out.WriteString("some workload output")
+	r.Start()
+	id := r.ID()
+	if got := r.Input("foo.csv"); got != "foo.csv" {
+		t.Errorf("Wanted foo.csv, got %s", got)
+	}
+	r.Input("foo.csv")
+	r.Output("model.pkl")
+	r.DatasetInput("b", "input.csv")
+	r.DatasetOutput("d", "output.csv")
+	r.Parameter("smoothing", 1.5)
+	r.Parameter("epochs", 10)
+	r.Summary("rms_error", 0.057)
+	r.Label("team", "ml")
+	r.WorkloadFile("train.go")
+	if err := r.Publish("Trained"); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Error(errors.New("out of memory"))
+	if err := r.Publish(""); err != nil {
+		t.Fatal(err)
+	}
+	out.WriteString("trailing output")
+
+	runs, problems, err := metadata.ParseAnnotations(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || len(problems) != 0 {
+		t.Fatalf("Expected 2 runs and no problems, got %v and %v", runs, problems)
+	}
+
+	expected := metadata.RunMetadata{
+		RunID:                id,
+		Authority:            metadata.RunAuthority_Workload,
+		Description:          "Trained",
+		WorkloadFile:         "train.go",
+		Success:              true,
+		WorkspaceInputFiles:  []metadata.InputFile{metadata.InputFile{Filename: "foo.csv"}},
+		WorkspaceOutputFiles: []string{"model.pkl"},
+		DatasetInputFiles:    map[string][]metadata.InputFile{"b": []metadata.InputFile{metadata.InputFile{Filename: "input.csv"}}},
+		DatasetOutputFiles:   map[string][]string{"d": []string{"output.csv"}},
+		Labels:               map[string]string{"team": "ml"},
+		Summary:              map[string]string{"rms_error": "0.057"},
+		Parameters:           map[string]string{"smoothing": "1.5", "epochs": "10"},
+		ExecStart:            time.Date(2018, 10, 4, 13, 6, 1, 500000000, time.UTC),
+		ExecEnd:              time.Date(2018, 10, 4, 13, 6, 2, 500000000, time.UTC),
+	}
+	if !reflect.DeepEqual(runs[0], expected) {
+		t.Errorf("Wanted %#v, got %#v", expected, runs[0])
+	}
+
+	failed := runs[1]
+	if failed.RunID == id {
+		t.Errorf("Expected a new run ID after publishing")
+	}
+	if failed.Success || failed.ErrorMessage == nil || *failed.ErrorMessage != "out of memory" {
+		t.Errorf("Expected the second run to fail, got %#v", failed)
+	}
+	if len(failed.WorkspaceInputFiles) != 0 || len(failed.Parameters) != 0 {
+		t.Errorf("Expected the second run to start afresh, got %#v", failed)
+	}
+}
+
+func TestPublishEndMarker(t *testing.T) {
+	var out bytes.Buffer
+	r := NewRun(&out)
+	r.now = testClock()
+
+	r.Start()
+	marker := metadata.AnnotationEnd + r.ID() + "]]"
+	r.Label("note", marker)
+	r.Output("[x].csv")
+	if err := r.Publish(""); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, problems, err := metadata.ParseAnnotations(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || len(problems) != 0 {
+		t.Fatalf("Expected 1 run and no problems, got %v and %v", runs, problems)
+	}
+	if got := runs[0].Labels["note"]; got != marker {
+		t.Errorf("Wanted %#v, got %#v", marker, got)
+	}
+	if got := runs[0].WorkspaceOutputFiles; !reflect.DeepEqual(got, []string{"[x].csv"}) {
+		t.Errorf("Wanted %#v, got %#v", []string{"[x].csv"}, got)
+	}
+}
@@ -0,0 +1,339 @@
+package dvc
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+const (
+	// WorkloadType is recorded as the workload type of imported stages.
+	WorkloadType = "dvc"
+
+	// LabelStage is the run label holding the name of the DVC stage.
+	LabelStage = "dvc.stage"
+
+	// DefaultParamsFile is the params file that DVC reads when a stage does
+	// not name one. Parameters from it are recorded under their bare names;
+	// those from other files as FILE:NAME, as DVC itself writes them.
+	DefaultParamsFile = "params.yaml"
+)
+
+// ImportDir reads dvc.yaml and dvc.lock from a DVC project directory and
+// converts each stage into a commit holding a single run. Either file may be
+// missing, but not both.
+func ImportDir(dir string) ([]metadata.CommitMetadata, error) {
+	var pipeline, lock io.Reader
+
+	f, err := os.Open(filepath.Join(dir, "dvc.yaml"))
+	if err == nil {
+		defer f.Close()
+		pipeline = f
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l, err := os.Open(filepath.Join(dir, "dvc.lock"))
+	if err == nil {
+		defer l.Close()
+		lock = l
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if pipeline == nil && lock == nil {
+		return nil, fmt.Errorf("no dvc.yaml or dvc.lock in %s", dir)
+	}
+	return Import(pipeline, lock)
+}
+
+// Import converts the stages of a DVC pipeline into commits, each holding a
+// single run. Either reader may be nil. When a lock file is given, only the
+// stages it records are imported, since the others have never been run;
+// the pipeline file supplies stage order and descriptions.
+//
+// Each run takes its WorkspaceInputFiles from the stage's deps, with the
+// recorded md5 as version, its WorkspaceOutputFiles from the outs, and its
+// Parameters from the params. The stage's cmd becomes the commit's
+// WorkloadCommand. Run IDs are derived from the stage's name and content
+// hashes, so importing the same lock file twice gives the same IDs.
+func Import(pipeline, lock io.Reader) ([]metadata.CommitMetadata, error) {
+	var pipelineStages, lockStages *yamlMap
+
+	if pipeline != nil {
+		doc, err := parseYAML(pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("reading dvc.yaml: %s", err)
+		}
+		pipelineStages = stagesOf(doc)
+	}
+	if lock != nil {
+		doc, err := parseYAML(lock)
+		if err != nil {
+			return nil, fmt.Errorf("reading dvc.lock: %s", err)
+		}
+		lockStages = stagesOf(doc)
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+	if pipelineStages != nil {
+		for _, name := range pipelineStages.keys {
+			if lockStages == nil || lockStages.get(name) != nil {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+	if lockStages != nil {
+		for _, name := range lockStages.keys {
+			if !seen[name] {
+				names = append(names, name)
+			}
+		}
+	}
+
+	result := []metadata.CommitMetadata{}
+	for _, name := range names {
+		var declared, locked *yamlMap
+		if pipelineStages != nil {
+			declared, _ = pipelineStages.get(name).(*yamlMap)
+		}
+		if lockStages != nil {
+			locked, _ = lockStages.get(name).(*yamlMap)
+		}
+		result = append(result, convertStage(name, declared, locked))
+	}
+	return result, nil
+}
+
+// stagesOf returns the stages of a dvc.yaml or dvc.lock document. Lock files
+// written before DVC 2.0 have the stages at the top level.
+func stagesOf(doc interface{}) *yamlMap {
+	m, ok := doc.(*yamlMap)
+	if !ok {
+		return newYamlMap()
+	}
+	if stages, ok := m.get("stages").(*yamlMap); ok {
+		return stages
+	}
+	if _, ok := m.get("schema").(string); ok {
+		return newYamlMap()
+	}
+	return m
+}
+
+func convertStage(name string, declared, locked *yamlMap) metadata.CommitMetadata {
+	// The lock file records exactly what was run, so prefer it.
+	source := locked
+	if source == nil {
+		source = declared
+	}
+
+	cmds := stringList(source.get("cmd"))
+	if len(cmds) == 0 && declared != nil {
+		cmds = stringList(declared.get("cmd"))
+	}
+
+	run := metadata.RunMetadata{
+		Authority:            metadata.RunAuthority_Workload,
+		Success:              true,
+		WorkspaceInputFiles:  pathEntries(source.get("deps")),
+		WorkspaceOutputFiles: []string{},
+		Labels:               map[string]string{LabelStage: name},
+		Parameters:           map[string]string{},
+	}
+	if declared != nil {
+		if desc, ok := declared.get("desc").(string); ok {
+			run.Description = desc
+		}
+	}
+	if run.Description == "" {
+		run.Description = "DVC stage " + name
+	}
+
+	outs := []metadata.InputFile{}
+	for _, key := range []string{"outs", "metrics", "plots"} {
+		outs = append(outs, pathEntries(source.get(key))...)
+	}
+	for _, out := range outs {
+		run.WorkspaceOutputFiles = append(run.WorkspaceOutputFiles, out.Filename)
+	}
+
+	addParams(run.Parameters, source.get("params"))
+
+	run.RunID = stageRunID(name, cmds, run.WorkspaceInputFiles, outs)
+
+	cm := metadata.CommitMetadata{
+		Success:      true,
+		WorkloadType: WorkloadType,
+		Runs:         []metadata.RunMetadata{run},
+	}
+	if len(cmds) > 0 {
+		// DVC runs each command through the shell, stopping at the first
+		// failure.
+		cm.WorkloadCommand = []string{"sh", "-c", strings.Join(cmds, " && ")}
+	}
+	return cm
+}
+
+// pathEntries reads a list of deps or outs. In dvc.yaml each entry is a
+// path, or a mapping from path to options; in dvc.lock each entry is a
+// mapping with a "path" key and hash keys.
+func pathEntries(v interface{}) []metadata.InputFile {
+	list, _ := v.([]interface{})
+	result := []metadata.InputFile{}
+	for _, item := range list {
+		switch entry := item.(type) {
+		case string:
+			result = append(result, metadata.InputFile{Filename: entry})
+		case *yamlMap:
+			if path, ok := entry.get("path").(string); ok {
+				result = append(result, metadata.InputFile{Filename: path, Version: hashOf(entry)})
+			} else if len(entry.keys) == 1 {
+				result = append(result, metadata.InputFile{Filename: entry.keys[0]})
+			}
+		}
+	}
+	return result
+}
+
+func hashOf(entry *yamlMap) string {
+	for _, key := range []string{"md5", "etag", "checksum"} {
+		if v, ok := entry.get(key).(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// addParams flattens stage params into result. dvc.lock records them as a
+// mapping from params file to values; dvc.yaml lists their names, either
+// bare or grouped under a params file, with no values.
+func addParams(result map[string]string, v interface{}) {
+	paramName := func(file, key string) string {
+		if file == DefaultParamsFile {
+			return key
+		}
+		return file + ":" + key
+	}
+
+	switch params := v.(type) {
+	case *yamlMap:
+		for _, file := range params.keys {
+			values, ok := params.get(file).(*yamlMap)
+			if !ok {
+				continue
+			}
+			for _, key := range values.keys {
+				flattenParam(result, paramName(file, key), values.get(key))
+			}
+		}
+	case []interface{}:
+		for _, item := range params {
+			switch entry := item.(type) {
+			case string:
+				if _, ok := result[entry]; !ok {
+					result[entry] = ""
+				}
+			case *yamlMap:
+				for _, file := range entry.keys {
+					for _, key := range stringList(entry.get(file)) {
+						name := paramName(file, key)
+						if _, ok := result[name]; !ok {
+							result[name] = ""
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// flattenParam records a parameter, turning nested mappings into dotted
+// names and sequences into JSON lists.
+func flattenParam(result map[string]string, name string, v interface{}) {
+	switch value := v.(type) {
+	case *yamlMap:
+		for _, key := range value.keys {
+			flattenParam(result, name+"."+key, value.get(key))
+		}
+	case []interface{}:
+		b, _ := json.Marshal(plain(value))
+		result[name] = string(b)
+	case string:
+		result[name] = value
+	default:
+		result[name] = ""
+	}
+}
+
+// plain converts parsed YAML into values encoding/json understands.
+func plain(v interface{}) interface{} {
+	switch value := v.(type) {
+	case *yamlMap:
+		m := map[string]interface{}{}
+		for _, key := range value.keys {
+			m[key] = plain(value.get(key))
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(value))
+		for idx, item := range value {
+			l[idx] = plain(item)
+		}
+		return l
+	default:
+		return value
+	}
+}
+
+func stringList(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := []string{}
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return []string{}
+	}
+}
+
+// stageRunID derives a name-based (version 5 style) UUID from everything
+// that identifies one execution of a stage.
+func stageRunID(name string, cmds []string, deps, outs []metadata.InputFile) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "dvc-stage\x00%s\x00", name)
+	for _, cmd := range cmds {
+		fmt.Fprintf(h, "cmd\x00%s\x00", cmd)
+	}
+	files := []string{}
+	for _, dep := range deps {
+		files = append(files, "dep\x00"+dep.Filename+"\x00"+dep.Version)
+	}
+	for _, out := range outs {
+		files = append(files, "out\x00"+out.Filename+"\x00"+out.Version)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00", f)
+	}
+
+	sum := h.Sum(nil)
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
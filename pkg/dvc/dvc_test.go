@@ -0,0 +1,197 @@
+package dvc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+const testPipeline = `stages:
+  prepare:
+    desc: Split the raw data
+    cmd: python src/prepare.py data/data.xml
+    deps:
+    - data/data.xml
+    - src/prepare.py
+    params:
+    - prepare.seed
+    - prepare.split
+    outs:
+    - data/prepared
+  featurize:
+    cmd:
+    - python src/featurization.py data/prepared data/features
+    - touch data/features/.done
+    deps:
+    - data/prepared
+    params:
+    - featurize.max_features
+    outs:
+    - data/features
+  evaluate:
+    cmd: python src/evaluate.py
+    metrics:
+    - eval/metrics.json:
+        cache: false
+`
+
+const testLock = `schema: '2.0'
+stages:
+  featurize:
+    cmd:
+    - python src/featurization.py data/prepared data/features
+    - touch data/features/.done
+    deps:
+    - path: data/prepared
+      md5: 153aad06d376b6595932470e459ef42a.dir
+      size: 8437363
+      nfiles: 2
+    params:
+      params.yaml:
+        featurize.max_features: 200
+    outs:
+    - path: data/features
+      md5: f35d4cc2c552ac959ae602162b8543f3.dir
+      size: 2232588
+      nfiles: 2
+  prepare:
+    cmd: python src/prepare.py data/data.xml
+    deps:
+    - path: data/data.xml
+      md5: a304afb96060aad90176268345e10355
+      size: 37891850
+    - path: src/prepare.py
+      md5: f09ea0c15980b43010257ccb9f0055e2
+      size: 1576
+    params:
+      params.yaml:
+        prepare.seed: 20170428
+        prepare.split: 0.2
+      extra.yaml:
+        tags: [a, b]
+        nested:
+          depth: 3  # comment
+    outs:
+    - path: data/prepared
+      md5: 153aad06d376b6595932470e459ef42a.dir
+      size: 8437363
+      nfiles: 2
+`
+
+func testEqStr(t *testing.T, got, expected string) {
+	t.Helper()
+	if got != expected {
+		t.Errorf("Wanted %s, got %s", expected, got)
+	}
+}
+
+func testEqStrs(t *testing.T, got, expected []string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("Wanted %#v, got %#v", expected, got)
+		return
+	}
+	for idx, v := range expected {
+		if got[idx] != v {
+			t.Errorf("Wanted %#v, got %#v", expected, got)
+			return
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	commits, err := Import(strings.NewReader(testPipeline), strings.NewReader(testLock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// evaluate has never been run, so is not in the lock file
+	if len(commits) != 2 {
+		t.Fatalf("Expected 2 stages, got %d", len(commits))
+	}
+
+	prepare := commits[0]
+	testEqStr(t, prepare.WorkloadType, WorkloadType)
+	testEqStrs(t, prepare.WorkloadCommand, []string{"sh", "-c", "python src/prepare.py data/data.xml"})
+	run := prepare.Runs[0]
+	testEqStr(t, run.Labels[LabelStage], "prepare")
+	testEqStr(t, run.Description, "Split the raw data")
+	if len(run.WorkspaceInputFiles) != 2 || run.WorkspaceInputFiles[0] != (metadata.InputFile{Filename: "data/data.xml", Version: "a304afb96060aad90176268345e10355"}) {
+		t.Errorf("Unexpected inputs %#v", run.WorkspaceInputFiles)
+	}
+	testEqStrs(t, run.WorkspaceOutputFiles, []string{"data/prepared"})
+	testEqStr(t, run.Parameters["prepare.seed"], "20170428")
+	testEqStr(t, run.Parameters["prepare.split"], "0.2")
+	testEqStr(t, run.Parameters["extra.yaml:tags"], "[\"a\",\"b\"]")
+	testEqStr(t, run.Parameters["extra.yaml:nested.depth"], "3")
+
+	featurize := commits[1]
+	testEqStrs(t, featurize.WorkloadCommand, []string{"sh", "-c", "python src/featurization.py data/prepared data/features && touch data/features/.done"})
+	testEqStr(t, featurize.Runs[0].Description, "DVC stage featurize")
+	testEqStr(t, featurize.Runs[0].WorkspaceInputFiles[0].Version, "153aad06d376b6595932470e459ef42a.dir")
+
+	again, err := Import(strings.NewReader(testPipeline), strings.NewReader(testLock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEqStr(t, again[0].Runs[0].RunID, run.RunID)
+	if run.RunID == featurize.Runs[0].RunID {
+		t.Errorf("Expected distinct run IDs for distinct stages")
+	}
+}
+
+func TestImportPipelineOnly(t *testing.T) {
+	commits, err := Import(strings.NewReader(testPipeline), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 3 {
+		t.Fatalf("Expected 3 stages, got %d", len(commits))
+	}
+	testEqStrs(t, commits[2].Runs[0].WorkspaceOutputFiles, []string{"eval/metrics.json"})
+	if _, ok := commits[0].Runs[0].Parameters["prepare.seed"]; !ok {
+		t.Errorf("Expected declared parameter, got %#v", commits[0].Runs[0].Parameters)
+	}
+	if commits[0].Runs[0].WorkspaceInputFiles[0].Version != "" {
+		t.Errorf("Expected no version without a lock file")
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	doc, err := parseYAML(strings.NewReader(`# leading comment
+a: 'it''s'
+b: "tab\there"
+c: |
+  line one
+  line two
+d:
+  - x
+  -
+    y: 1
+  - {k: v, l: [1, 2]}
+e: ~
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := doc.(*yamlMap)
+	testEqStrs(t, m.keys, []string{"a", "b", "c", "d", "e"})
+	testEqStr(t, m.get("a").(string), "it's")
+	testEqStr(t, m.get("b").(string), "tab\there")
+	testEqStr(t, m.get("c").(string), "line one\nline two\n")
+	d := m.get("d").([]interface{})
+	if len(d) != 3 {
+		t.Fatalf("Expected 3 items, got %#v", d)
+	}
+	testEqStr(t, d[1].(*yamlMap).get("y").(string), "1")
+	testEqStrs(t, stringList(d[2].(*yamlMap).get("l")), []string{"1", "2"})
+	if m.get("e") != nil {
+		t.Errorf("Expected null, got %#v", m.get("e"))
+	}
+
+	_, err = parseYAML(strings.NewReader("a: 1\n    b: 2\n"))
+	if err == nil {
+		t.Errorf("Expected an error for bad indentation")
+	}
+}
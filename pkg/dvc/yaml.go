@@ -0,0 +1,400 @@
+package dvc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This is a reader for the subset of YAML that DVC writes: block mappings
+// and sequences, plain and quoted scalars, literal and folded block
+// scalars, and simple flow collections. Scalars are returned as strings;
+// mappings as *yamlMap, which remembers key order; sequences as
+// []interface{}; and null values as nil.
+
+// type yamlMap is a YAML mapping that preserves the order of its keys.
+type yamlMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newYamlMap() *yamlMap {
+	return &yamlMap{values: map[string]interface{}{}}
+}
+
+func (m *yamlMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// get returns the value for key, or nil if m is nil or key is missing.
+func (m *yamlMap) get(key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.values[key]
+}
+
+type yamlLine struct {
+	number  int
+	indent  int
+	content string // with comments and trailing space removed
+	raw     string // the whole line, for block scalars
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses a single YAML document.
+func parseYAML(r io.Reader) (interface{}, error) {
+	p := &yamlParser{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	number := 0
+	for scanner.Scan() {
+		number++
+		raw := strings.TrimRight(scanner.Text(), "\r")
+		content := strings.TrimRight(stripComment(raw), " \t")
+		trimmed := strings.TrimLeft(content, " ")
+		if trimmed == "---" && len(p.lines) == 0 {
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{
+			number:  number,
+			indent:  len(content) - len(trimmed),
+			content: trimmed,
+			raw:     raw,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	v, err := p.parseNode(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected content %q", p.lines[p.pos].content)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if p.pos < len(p.lines) {
+		line = p.lines[p.pos].number
+	}
+	return fmt.Errorf("yaml: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && p.lines[p.pos].content == "" {
+		p.pos++
+	}
+}
+
+// peek returns the next non-blank line, if any.
+func (p *yamlParser) peek() (yamlLine, bool) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return yamlLine{}, false
+	}
+	return p.lines[p.pos], true
+}
+
+func isSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	line, ok := p.peek()
+	if !ok {
+		return nil, nil
+	}
+	if isSequenceItem(line.content) {
+		return p.parseSequence(line.indent)
+	}
+	if _, _, isPair := splitPair(line.content); isPair {
+		return p.parseMapping(line.indent)
+	}
+	p.pos++
+	return parseFlow(line.content)
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	result := []interface{}{}
+	for {
+		line, ok := p.peek()
+		if !ok || line.indent != indent || !isSequenceItem(line.content) {
+			return result, nil
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.content, "-"), " ")
+		if rest == "" {
+			p.pos++
+			next, ok := p.peek()
+			if ok && next.indent > indent {
+				v, err := p.parseNode(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, v)
+			} else {
+				result = append(result, nil)
+			}
+			continue
+		}
+
+		// "- key: value" starts a mapping that continues on the following
+		// lines at the indentation of "key"; rewrite the line so that the
+		// mapping can be parsed normally.
+		itemIndent := indent + len(line.content) - len(rest)
+		p.lines[p.pos].indent = itemIndent
+		p.lines[p.pos].content = rest
+		v, err := p.parseNode(itemIndent)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	result := newYamlMap()
+	for {
+		line, ok := p.peek()
+		if !ok || line.indent < indent {
+			return result, nil
+		}
+		if line.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if isSequenceItem(line.content) {
+			return result, nil
+		}
+		key, value, isPair := splitPair(line.content)
+		if !isPair {
+			return nil, p.errorf("expected a key, got %q", line.content)
+		}
+		key, err := parseScalar(key)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		p.pos++
+
+		switch {
+		case value == "":
+			next, ok := p.peek()
+			if ok && (next.indent > indent || (next.indent == indent && isSequenceItem(next.content))) {
+				v, err := p.parseNode(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				result.set(key, v)
+			} else {
+				result.set(key, nil)
+			}
+		case value[0] == '|' || value[0] == '>':
+			result.set(key, p.parseBlockScalar(indent, value))
+		default:
+			v, err := parseFlow(value)
+			if err != nil {
+				return nil, p.errorf("%s", err)
+			}
+			result.set(key, v)
+		}
+	}
+}
+
+// parseBlockScalar reads the lines of a literal (|) or folded (>) scalar
+// that are indented further than its key.
+func (p *yamlParser) parseBlockScalar(indent int, header string) string {
+	lines := []string{}
+	blockIndent := -1
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		trimmed := strings.TrimLeft(line.raw, " ")
+		if trimmed != "" {
+			lineIndent := len(line.raw) - len(trimmed)
+			if lineIndent <= indent {
+				break
+			}
+			if blockIndent < 0 {
+				blockIndent = lineIndent
+			}
+		}
+		if len(line.raw) >= blockIndent && blockIndent >= 0 {
+			lines = append(lines, line.raw[blockIndent:])
+		} else {
+			lines = append(lines, "")
+		}
+		p.pos++
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var text string
+	if header[0] == '>' {
+		text = strings.Join(lines, " ")
+	} else {
+		text = strings.Join(lines, "\n")
+	}
+	if strings.Contains(header, "-") {
+		return text
+	}
+	return text + "\n"
+}
+
+// splitPair splits "key: value" or "key:" into its parts.
+func splitPair(content string) (string, string, bool) {
+	quote := byte(0)
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == '[' || c == '{':
+			if i == 0 {
+				return "", "", false
+			}
+		case c == ':':
+			if i+1 == len(content) {
+				return content[:i], "", true
+			}
+			if content[i+1] == ' ' {
+				return content[:i], strings.TrimSpace(content[i+1:]), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// stripComment removes a trailing "# comment" that is outside quotes.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '-' || line[i-1] == '[' || line[i-1] == ',' {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// parseFlow parses a scalar or a single-line flow collection.
+func parseFlow(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence %q", s)
+		}
+		result := []interface{}{}
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			v, err := parseFlow(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+		}
+		return result, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("unterminated flow mapping %q", s)
+		}
+		result := newYamlMap()
+		for _, item := range splitFlow(s[1 : len(s)-1]) {
+			k, v, ok := splitPair(item)
+			if !ok {
+				return nil, fmt.Errorf("bad flow mapping entry %q", item)
+			}
+			key, err := parseScalar(k)
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseFlow(v)
+			if err != nil {
+				return nil, err
+			}
+			result.set(key, value)
+		}
+		return result, nil
+	case s == "" || s == "~" || s == "null" || s == "Null" || s == "NULL":
+		return nil, nil
+	default:
+		return parseScalar(s)
+	}
+}
+
+// splitFlow splits the inside of a flow collection on top-level commas.
+func splitFlow(s string) []string {
+	result := []string{}
+	depth := 0
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			result = append(result, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		result = append(result, last)
+	}
+	return result
+}
+
+func parseScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("bad quoted string %s", s)
+		}
+		return v, nil
+	default:
+		return s, nil
+	}
+}
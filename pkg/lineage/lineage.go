@@ -0,0 +1,200 @@
+// Package lineage draws the lineage of a commit's runs, from the datasets
+// and files they read to those they wrote, as Graphviz DOT or Mermaid
+// flowcharts.
+package lineage
+
+import (
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// type NodeKind is the kind of thing a node stands for.
+type NodeKind int
+
+const (
+	NodeKind_Run NodeKind = iota
+	NodeKind_File
+	NodeKind_DatasetVersion
+)
+
+// type Node is a run, a file at a version, or a dataset at a version. Its
+// label is drawn one line per element.
+type Node struct {
+	Kind  NodeKind
+	Label []string
+
+	// Failed and Correction are set on runs when highlighted.
+	Failed     bool
+	Correction bool
+}
+
+// type Edge connects two nodes by index, in the direction data flows.
+// Contains edges join a dataset version and a file in it; other edges
+// show data flowing into or out of a run.
+type Edge struct {
+	From, To int
+	Contains bool
+}
+
+// type Graph is a lineage graph. Nodes and edges are in a deterministic
+// order.
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+
+	keys  map[string]int
+	edges map[Edge]bool
+}
+
+// type Options controls what a lineage graph shows.
+type Options struct {
+	// CommitID is the version of the workspace written by the commit.
+	CommitID string
+
+	// RunIDs restricts the graph to some runs; nil includes every run.
+	RunIDs []string
+
+	// CollapseFiles draws datasets and the workspace at each version,
+	// rather than the individual files read and written.
+	CollapseFiles bool
+
+	// HighlightFailed and HighlightCorrections mark failed runs and
+	// correction runs.
+	HighlightFailed      bool
+	HighlightCorrections bool
+}
+
+// WorkspaceName labels the workspace when files are collapsed.
+const WorkspaceName = "workspace"
+
+func (g *Graph) node(key string, n Node) int {
+	if idx, ok := g.keys[key]; ok {
+		return idx
+	}
+	g.keys[key] = len(g.Nodes)
+	g.Nodes = append(g.Nodes, n)
+	return len(g.Nodes) - 1
+}
+
+func (g *Graph) edge(e Edge) {
+	if !g.edges[e] {
+		g.edges[e] = true
+		g.Edges = append(g.Edges, e)
+	}
+}
+
+func (g *Graph) datasetVersion(name string, dsv metadata.DatasetVersion) int {
+	version := string(dsv.ID)
+	if dsv.Version != "" {
+		version += "@" + dsv.Version
+	}
+	return g.node("dataset\x00"+name+"\x00"+string(dsv.ID)+"\x00"+dsv.Version, Node{
+		Kind:  NodeKind_DatasetVersion,
+		Label: []string{name, version},
+	})
+}
+
+func (g *Graph) workspaceVersion(version string) int {
+	label := []string{WorkspaceName}
+	if version != "" {
+		label = append(label, version)
+	}
+	return g.node("workspace\x00"+version, Node{Kind: NodeKind_DatasetVersion, Label: label})
+}
+
+func (g *Graph) file(dataset, filename, version string) int {
+	name := filename
+	if dataset != "" {
+		name = dataset + "/" + filename
+	}
+	label := []string{name}
+	if version != "" {
+		label = append(label, version)
+	}
+	return g.node("file\x00"+dataset+"\x00"+filename+"\x00"+version, Node{Kind: NodeKind_File, Label: label})
+}
+
+// Build returns the lineage graph of a commit's runs. Dataset versions are
+// looked up in the commit's Inputs and Outputs; a dataset a run uses that
+// is missing from them is drawn at the versions recorded for its files.
+func Build(cm metadata.CommitMetadata, opts Options) *Graph {
+	g := &Graph{keys: map[string]int{}, edges: map[Edge]bool{}}
+
+	wanted := map[string]bool{}
+	for _, id := range opts.RunIDs {
+		wanted[id] = true
+	}
+
+	// Dataset versions come first, so that they are drawn in a stable
+	// order whatever the runs touch.
+	for _, name := range metadata.SortedDatasetNames(cm.Inputs) {
+		g.datasetVersion(name, cm.Inputs[name])
+	}
+
+	for _, run := range cm.Runs {
+		if opts.RunIDs != nil && !wanted[run.RunID] {
+			continue
+		}
+		label := []string{run.RunID}
+		if run.Description != "" {
+			label = append(label, run.Description)
+		} else if run.WorkloadFile != "" {
+			label = append(label, run.WorkloadFile)
+		}
+		r := g.node("run\x00"+run.RunID, Node{
+			Kind:       NodeKind_Run,
+			Label:      label,
+			Failed:     opts.HighlightFailed && !run.Success,
+			Correction: opts.HighlightCorrections && run.Authority == metadata.RunAuthority_Correction,
+		})
+
+		for _, inf := range run.WorkspaceInputFiles {
+			if opts.CollapseFiles {
+				g.edge(Edge{From: g.workspaceVersion(inf.Version), To: r})
+			} else {
+				g.edge(Edge{From: g.file("", inf.Filename, inf.Version), To: r})
+			}
+		}
+		for _, name := range metadata.SortedInputDatasets(run.DatasetInputFiles) {
+			for _, inf := range run.DatasetInputFiles[name] {
+				dsv, ok := cm.Inputs[name]
+				if !ok {
+					dsv = metadata.DatasetVersion{ID: metadata.DotID(name), Version: inf.Version}
+				}
+				d := g.datasetVersion(name, dsv)
+				if opts.CollapseFiles {
+					g.edge(Edge{From: d, To: r})
+				} else {
+					f := g.file(name, inf.Filename, inf.Version)
+					g.edge(Edge{From: d, To: f, Contains: true})
+					g.edge(Edge{From: f, To: r})
+				}
+			}
+		}
+
+		for _, filename := range run.WorkspaceOutputFiles {
+			if opts.CollapseFiles {
+				g.edge(Edge{From: r, To: g.workspaceVersion(opts.CommitID)})
+			} else {
+				g.edge(Edge{From: r, To: g.file("", filename, opts.CommitID)})
+			}
+		}
+		for _, name := range metadata.SortedOutputDatasets(run.DatasetOutputFiles) {
+			dsv := metadata.ResolveDataset(cm.Outputs, name)
+			d := g.datasetVersion(name, dsv)
+			for _, filename := range run.DatasetOutputFiles[name] {
+				if opts.CollapseFiles {
+					g.edge(Edge{From: r, To: d})
+				} else {
+					f := g.file(name, filename, dsv.Version)
+					g.edge(Edge{From: r, To: f})
+					g.edge(Edge{From: f, To: d, Contains: true})
+				}
+			}
+		}
+	}
+
+	for _, name := range metadata.SortedDatasetNames(cm.Outputs) {
+		g.datasetVersion(name, cm.Outputs[name])
+	}
+	return g
+}
@@ -0,0 +1,155 @@
+package lineage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testCommit() metadata.CommitMetadata {
+	oops := "failed"
+	return metadata.CommitMetadata{
+		Inputs:  map[string]metadata.DatasetVersion{"b": {ID: "dot-b", Version: "b1"}},
+		Outputs: map[string]metadata.DatasetVersion{"d": {ID: "dot-d", Version: "d2"}},
+		Runs: []metadata.RunMetadata{
+			{
+				RunID:                "r1",
+				Success:              true,
+				Description:          `the "best" model`,
+				WorkspaceInputFiles:  []metadata.InputFile{{Filename: "foo.csv", Version: "w0"}},
+				DatasetInputFiles:    map[string][]metadata.InputFile{"b": {{Filename: "input.csv", Version: "b0"}}},
+				WorkspaceOutputFiles: []string{"model.pkl"},
+				DatasetOutputFiles:   map[string][]string{"d": {"output.csv"}},
+			},
+			{RunID: "r2", ErrorMessage: &oops, WorkspaceOutputFiles: []string{"x"}},
+			{RunID: "r3", Success: true, Authority: metadata.RunAuthority_Correction, WorkspaceOutputFiles: []string{"y"}},
+		},
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	g := Build(testCommit(), Options{CommitID: "c1", HighlightFailed: true, HighlightCorrections: true})
+	var buf bytes.Buffer
+	err := WriteMermaid(&buf, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := `flowchart LR
+    n0[("b<br/>dot-b@b1")]
+    n1["r1<br/>the #quot;best#quot; model"]
+    n2>"foo.csv<br/>w0"]
+    n3>"b/input.csv<br/>b0"]
+    n4>"model.pkl<br/>c1"]
+    n5[("d<br/>dot-d@d2")]
+    n6>"d/output.csv<br/>d2"]
+    n7["r2"]
+    n8>"x<br/>c1"]
+    n9["r3"]
+    n10>"y<br/>c1"]
+    n2 --> n1
+    n0 -.- n3
+    n3 --> n1
+    n1 --> n4
+    n1 --> n6
+    n6 -.- n5
+    n7 --> n8
+    n9 --> n10
+    classDef failed fill:#f8d7da,stroke:#cc0000
+    class n7 failed
+    classDef correction fill:#fff3cd,stroke:#cc8800,stroke-dasharray:5 5
+    class n9 correction
+`
+	if got := buf.String(); got != wanted {
+		t.Errorf("Wanted %s, got %s", wanted, got)
+	}
+}
+
+func TestDOTCollapsed(t *testing.T) {
+	g := Build(testCommit(), Options{CommitID: "c1", CollapseFiles: true, HighlightFailed: true})
+	var buf bytes.Buffer
+	err := WriteDOT(&buf, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := `digraph lineage {
+	rankdir=LR;
+	n0 [shape=cylinder, label="b\ndot-b@b1"];
+	n1 [shape=box, label="r1\nthe \"best\" model"];
+	n2 [shape=cylinder, label="workspace\nw0"];
+	n3 [shape=cylinder, label="workspace\nc1"];
+	n4 [shape=cylinder, label="d\ndot-d@d2"];
+	n5 [shape=box, style=filled, fillcolor="#f8d7da", color="#cc0000", label="r2"];
+	n6 [shape=box, label="r3"];
+	n2 -> n1;
+	n0 -> n1;
+	n1 -> n3;
+	n1 -> n4;
+	n5 -> n3;
+	n6 -> n3;
+}
+`
+	if got := buf.String(); got != wanted {
+		t.Errorf("Wanted %s, got %s", wanted, got)
+	}
+}
+
+func TestBuildRunIDs(t *testing.T) {
+	g := Build(testCommit(), Options{RunIDs: []string{"r2"}})
+	if len(g.Nodes) != 4 || len(g.Edges) != 1 {
+		t.Errorf("Wanted 4 nodes and 1 edge, got %#v and %#v", g.Nodes, g.Edges)
+	}
+	if g.Nodes[1].Failed {
+		t.Errorf("Wanted r2 not highlighted")
+	}
+}
+
+func TestFailedCorrection(t *testing.T) {
+	oops := "failed"
+	cm := metadata.CommitMetadata{
+		Runs: []metadata.RunMetadata{
+			{RunID: "r1", ErrorMessage: &oops, Authority: metadata.RunAuthority_Correction},
+		},
+	}
+	g := Build(cm, Options{HighlightFailed: true, HighlightCorrections: true})
+
+	var buf bytes.Buffer
+	err := WriteMermaid(&buf, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := `flowchart LR
+    n0["r1"]
+    classDef failedCorrection fill:#f8d7da,stroke:#cc0000,stroke-dasharray:5 5
+    class n0 failedCorrection
+`
+	if got := buf.String(); got != wanted {
+		t.Errorf("Wanted %s, got %s", wanted, got)
+	}
+
+	buf.Reset()
+	err = WriteDOT(&buf, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted = `digraph lineage {
+	rankdir=LR;
+	n0 [shape=box, style="filled,dashed", fillcolor="#f8d7da", color="#cc0000", label="r1"];
+}
+`
+	if got := buf.String(); got != wanted {
+		t.Errorf("Wanted %s, got %s", wanted, got)
+	}
+}
+
+func TestBuildDatasetIDs(t *testing.T) {
+	// The same name and version of different dots are different nodes
+	cm := metadata.CommitMetadata{
+		Inputs:  map[string]metadata.DatasetVersion{"d": {ID: "dot-x", Version: "v1"}},
+		Outputs: map[string]metadata.DatasetVersion{"d": {ID: "dot-y", Version: "v1"}},
+	}
+	g := Build(cm, Options{})
+	if len(g.Nodes) != 2 {
+		t.Errorf("Wanted 2 nodes, got %#v", g.Nodes)
+	}
+}
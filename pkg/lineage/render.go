@@ -0,0 +1,129 @@
+package lineage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Colours used to highlight runs
+const (
+	failedFill       = "#f8d7da"
+	failedStroke     = "#cc0000"
+	correctionFill   = "#fff3cd"
+	correctionStroke = "#cc8800"
+)
+
+// WriteDOT writes a lineage graph in the Graphviz DOT language. Runs are
+// boxes, files are notes and dataset versions are cylinders. Failed runs
+// are filled red, and correction runs are filled amber with a dashed
+// outline.
+func WriteDOT(w io.Writer, g *Graph) error {
+	var b strings.Builder
+	b.WriteString("digraph lineage {\n\trankdir=LR;\n")
+	for idx, n := range g.Nodes {
+		attrs := []string{}
+		switch n.Kind {
+		case NodeKind_Run:
+			attrs = append(attrs, "shape=box")
+		case NodeKind_File:
+			attrs = append(attrs, "shape=note")
+		default:
+			attrs = append(attrs, "shape=cylinder")
+		}
+		switch {
+		case n.Failed && n.Correction:
+			attrs = append(attrs, `style="filled,dashed"`, dotQuote("fillcolor", failedFill), dotQuote("color", failedStroke))
+		case n.Failed:
+			attrs = append(attrs, "style=filled", dotQuote("fillcolor", failedFill), dotQuote("color", failedStroke))
+		case n.Correction:
+			attrs = append(attrs, `style="filled,dashed"`, dotQuote("fillcolor", correctionFill), dotQuote("color", correctionStroke))
+		}
+		attrs = append(attrs, dotQuote("label", strings.Join(n.Label, "\n")))
+		fmt.Fprintf(&b, "\tn%d [%s];\n", idx, strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges {
+		if e.Contains {
+			fmt.Fprintf(&b, "\tn%d -> n%d [style=dashed, arrowhead=none];\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(&b, "\tn%d -> n%d;\n", e.From, e.To)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotQuote(name, value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return name + `="` + value + `"`
+}
+
+// WriteMermaid writes a lineage graph as a Mermaid flowchart, for embedding
+// in Markdown. Runs are rectangles, files are flags and dataset versions
+// are cylinders. Failed and correction runs are given the classes "failed"
+// and "correction", and failed correction runs the class
+// "failedCorrection", styled as WriteDOT draws them.
+func WriteMermaid(w io.Writer, g *Graph) error {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	failed := []string{}
+	corrections := []string{}
+	failedCorrections := []string{}
+	for idx, n := range g.Nodes {
+		label := mermaidLabel(n.Label)
+		switch n.Kind {
+		case NodeKind_Run:
+			fmt.Fprintf(&b, "    n%d[%s]\n", idx, label)
+		case NodeKind_File:
+			fmt.Fprintf(&b, "    n%d>%s]\n", idx, label)
+		default:
+			fmt.Fprintf(&b, "    n%d[(%s)]\n", idx, label)
+		}
+		switch {
+		case n.Failed && n.Correction:
+			failedCorrections = append(failedCorrections, fmt.Sprintf("n%d", idx))
+		case n.Failed:
+			failed = append(failed, fmt.Sprintf("n%d", idx))
+		case n.Correction:
+			corrections = append(corrections, fmt.Sprintf("n%d", idx))
+		}
+	}
+	for _, e := range g.Edges {
+		if e.Contains {
+			fmt.Fprintf(&b, "    n%d -.- n%d\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(&b, "    n%d --> n%d\n", e.From, e.To)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "    classDef failed fill:%s,stroke:%s\n", failedFill, failedStroke)
+		fmt.Fprintf(&b, "    class %s failed\n", strings.Join(failed, ","))
+	}
+	if len(corrections) > 0 {
+		fmt.Fprintf(&b, "    classDef correction fill:%s,stroke:%s,stroke-dasharray:5 5\n", correctionFill, correctionStroke)
+		fmt.Fprintf(&b, "    class %s correction\n", strings.Join(corrections, ","))
+	}
+	if len(failedCorrections) > 0 {
+		fmt.Fprintf(&b, "    classDef failedCorrection fill:%s,stroke:%s,stroke-dasharray:5 5\n", failedFill, failedStroke)
+		fmt.Fprintf(&b, "    class %s failedCorrection\n", strings.Join(failedCorrections, ","))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidLabel quotes a label, escaping the characters Mermaid would
+// otherwise interpret.
+func mermaidLabel(lines []string) string {
+	escaped := make([]string, len(lines))
+	for idx, line := range lines {
+		line = strings.Replace(line, "#", "#35;", -1)
+		line = strings.Replace(line, `"`, "#quot;", -1)
+		line = strings.Replace(line, "<", "#lt;", -1)
+		line = strings.Replace(line, ">", "#gt;", -1)
+		escaped[idx] = line
+	}
+	return `"` + strings.Join(escaped, "<br/>") + `"`
+}
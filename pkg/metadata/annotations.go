@@ -0,0 +1,341 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Workloads declare runs by printing annotation blocks to their output:
+//
+//	[[DOTSCIENCE-RUN:<run id>]]{...json...}[[/DOTSCIENCE-RUN:<run id>]]
+//
+// Run IDs may not contain whitespace, square brackets or dots.
+//
+// The JSON object has these fields, all optional:
+//
+//	"version":        "1"
+//	"description":    string
+//	"workload-file":  string
+//	"input":          ["FILE" or "FILE@VERSION", ...]
+//	"output":         ["FILE", ...]
+//	"dataset-input":  {"DATASET": ["FILE" or "FILE@VERSION", ...], ...}
+//	"dataset-output": {"DATASET": ["FILE", ...], ...}
+//	"labels", "parameters", "summary": {"KEY": value, ...}
+//	"start", "end":   timestamps in TimeFormat
+//	"error":          string, present only if the run failed
+//
+// Label, parameter and summary values may be JSON strings, numbers or
+// booleans.
+const (
+	AnnotationStart = "[[DOTSCIENCE-RUN:"
+	AnnotationEnd   = "[[/DOTSCIENCE-RUN:"
+	annotationClose = "]]"
+
+	// AnnotationVersion is the version of the annotation format written
+	// and understood by this package.
+	AnnotationVersion = "1"
+
+	maxAnnotationIDLength = 256
+	maxAnnotationSize     = 16 * 1024 * 1024
+	annotationReadSize    = 64 * 1024
+)
+
+// type RunAnnotation is the JSON body of a run annotation block.
+type RunAnnotation struct {
+	Version       string                 `json:"version,omitempty"`
+	Description   string                 `json:"description,omitempty"`
+	WorkloadFile  string                 `json:"workload-file,omitempty"`
+	Input         []string               `json:"input,omitempty"`
+	Output        []string               `json:"output,omitempty"`
+	DatasetInput  map[string][]string    `json:"dataset-input,omitempty"`
+	DatasetOutput map[string][]string    `json:"dataset-output,omitempty"`
+	Labels        map[string]interface{} `json:"labels,omitempty"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	Summary       map[string]interface{} `json:"summary,omitempty"`
+	Start         string                 `json:"start,omitempty"`
+	End           string                 `json:"end,omitempty"`
+	Error         *string                `json:"error,omitempty"`
+}
+
+// type AnnotationError describes an annotation block that was skipped.
+type AnnotationError struct {
+	RunID  string
+	Reason string
+}
+
+func (e AnnotationError) Error() string {
+	return fmt.Sprintf("run annotation %s: %s", e.RunID, e.Reason)
+}
+
+// type AnnotationScanner reads run annotations from a workload's output.
+// Text outside annotation blocks is ignored. Blocks that are malformed, cut
+// short by the end of the output, or interrupted by the start of another
+// block are skipped, and reported by Problems.
+type AnnotationScanner struct {
+	r        io.Reader
+	buf      []byte
+	scanned  int
+	eof      bool
+	err      error
+	run      RunMetadata
+	problems []AnnotationError
+}
+
+// NewAnnotationScanner returns a scanner reading from r.
+func NewAnnotationScanner(r io.Reader) *AnnotationScanner {
+	return &AnnotationScanner{r: r}
+}
+
+// Run returns the run read by the most recent successful call to Scan.
+func (s *AnnotationScanner) Run() RunMetadata {
+	return s.run
+}
+
+// Err returns the first error reading the output, other than io.EOF.
+func (s *AnnotationScanner) Err() error {
+	return s.err
+}
+
+// Problems returns the annotation blocks skipped so far.
+func (s *AnnotationScanner) Problems() []AnnotationError {
+	return s.problems
+}
+
+func (s *AnnotationScanner) problem(runID, reason string) {
+	s.problems = append(s.problems, AnnotationError{RunID: runID, Reason: reason})
+}
+
+// fill reads more output into the buffer, returning false at the end of
+// the output.
+func (s *AnnotationScanner) fill() bool {
+	if s.eof {
+		return false
+	}
+	chunk := make([]byte, annotationReadSize)
+	n, err := s.r.Read(chunk)
+	s.buf = append(s.buf, chunk[:n]...)
+	if err == io.EOF {
+		s.eof = true
+	} else if err != nil {
+		s.err = err
+		s.eof = true
+	}
+	return n > 0 || !s.eof
+}
+
+// skip drops the first n bytes of the buffer. Anything already searched
+// belonged to the block being dropped, so the next search starts afresh.
+func (s *AnnotationScanner) skip(n int) {
+	s.buf = s.buf[n:]
+	s.scanned = 0
+}
+
+// Scan advances to the next well-formed annotation block, returning false
+// at the end of the output.
+func (s *AnnotationScanner) Scan() bool {
+	start := []byte(AnnotationStart)
+	for {
+		idx := bytes.Index(s.buf, start)
+		if idx < 0 {
+			// Keep enough to complete a start marker split across reads
+			if keep := len(start) - 1; len(s.buf) > keep {
+				s.skip(len(s.buf) - keep)
+			}
+			if !s.fill() {
+				return false
+			}
+			continue
+		}
+		if idx > 0 {
+			s.skip(idx)
+		}
+
+		idLimit := len(s.buf)
+		if max := len(start) + maxAnnotationIDLength + len(annotationClose); idLimit > max {
+			idLimit = max
+		}
+		idEnd := bytes.Index(s.buf[len(start):idLimit], []byte(annotationClose))
+		if idEnd < 0 {
+			if idLimit < len(s.buf) {
+				// Not a real marker
+				s.skip(1)
+				continue
+			}
+			if !s.fill() {
+				s.problem("", "output ended inside an annotation marker")
+				s.skip(len(s.buf))
+				return false
+			}
+			continue
+		}
+		runID := string(s.buf[len(start) : len(start)+idEnd])
+		if runID == "" || strings.ContainsAny(runID, " \t\r\n[]") {
+			s.skip(1)
+			continue
+		}
+		if strings.Contains(runID, ".") {
+			// Run IDs are separated from key names by dots in the flat
+			// metadata format
+			s.problem(runID, "run ID contains \".\"")
+			s.skip(1)
+			continue
+		}
+
+		bodyStart := len(start) + idEnd + len(annotationClose)
+		body := s.buf[bodyStart:]
+		end := []byte(AnnotationEnd + runID + annotationClose)
+
+		// Resume searching where the last search of this block stopped,
+		// less enough to find a marker split across reads
+		from := s.scanned - bodyStart - len(end)
+		if from < 0 {
+			from = 0
+		}
+		endIdx := indexFrom(body, end, from)
+		nextIdx := indexFrom(body, start, from)
+
+		if nextIdx >= 0 && (endIdx < 0 || nextIdx < endIdx) {
+			s.problem(runID, "interrupted by another annotation")
+			s.skip(bodyStart + nextIdx)
+			continue
+		}
+		if endIdx < 0 {
+			if len(body) > maxAnnotationSize {
+				s.problem(runID, "annotation is too large")
+				s.skip(bodyStart)
+				continue
+			}
+			s.scanned = len(s.buf)
+			if !s.fill() {
+				s.problem(runID, "output ended inside the annotation")
+				s.skip(len(s.buf))
+				return false
+			}
+			continue
+		}
+
+		content := body[:endIdx]
+		s.skip(bodyStart + endIdx + len(end))
+
+		run, err := ParseRunAnnotation(runID, content)
+		if err != nil {
+			s.problem(runID, err.Error())
+			continue
+		}
+		s.run = run
+		return true
+	}
+}
+
+// indexFrom is bytes.Index, starting the search at offset from.
+func indexFrom(b, sep []byte, from int) int {
+	idx := bytes.Index(b[from:], sep)
+	if idx < 0 {
+		return -1
+	}
+	return from + idx
+}
+
+// ParseAnnotations reads every run annotation in a workload's output,
+// returning the runs and any blocks that had to be skipped.
+func ParseAnnotations(r io.Reader) ([]RunMetadata, []AnnotationError, error) {
+	s := NewAnnotationScanner(r)
+	runs := []RunMetadata{}
+	for s.Scan() {
+		runs = append(runs, s.Run())
+	}
+	return runs, s.Problems(), s.Err()
+}
+
+// ParseRunAnnotation converts the JSON body of an annotation block into a
+// RunMetadata.
+func ParseRunAnnotation(runID string, body []byte) (RunMetadata, error) {
+	var a RunAnnotation
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err := dec.Decode(&a)
+	if err != nil {
+		return RunMetadata{}, fmt.Errorf("bad JSON: %s", err)
+	}
+	if a.Version != "" && a.Version != AnnotationVersion {
+		return RunMetadata{}, fmt.Errorf("unsupported version %q", a.Version)
+	}
+
+	run := RunMetadata{
+		RunID:                runID,
+		Authority:            RunAuthority_Workload,
+		Description:          a.Description,
+		WorkloadFile:         a.WorkloadFile,
+		Success:              a.Error == nil,
+		ErrorMessage:         a.Error,
+		WorkspaceInputFiles:  annotationInputFiles(a.Input),
+		WorkspaceOutputFiles: append([]string{}, a.Output...),
+		DatasetInputFiles:    map[string][]InputFile{},
+		DatasetOutputFiles:   map[string][]string{},
+		Labels:               annotationValues(a.Labels),
+		Summary:              annotationValues(a.Summary),
+		Parameters:           annotationValues(a.Parameters),
+	}
+	for name, files := range a.DatasetInput {
+		run.DatasetInputFiles[name] = annotationInputFiles(files)
+	}
+	for name, files := range a.DatasetOutput {
+		run.DatasetOutputFiles[name] = append([]string{}, files...)
+	}
+
+	run.ExecStart, err = annotationTime(a.Start)
+	if err != nil {
+		return RunMetadata{}, fmt.Errorf("bad start time: %s", err)
+	}
+	run.ExecEnd, err = annotationTime(a.End)
+	if err != nil {
+		return RunMetadata{}, fmt.Errorf("bad end time: %s", err)
+	}
+	return run, nil
+}
+
+func annotationTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(TimeFormat, v)
+}
+
+// annotationInputFiles splits FILE@VERSION strings; the version is empty
+// when the workload doesn't know it, and the agent fills it in.
+func annotationInputFiles(files []string) []InputFile {
+	result := make([]InputFile, len(files))
+	for idx, f := range files {
+		at := strings.LastIndex(f, "@")
+		if at < 0 {
+			result[idx] = InputFile{Filename: f}
+		} else {
+			result[idx] = InputFile{Filename: f[:at], Version: f[at+1:]}
+		}
+	}
+	return result
+}
+
+func annotationValues(values map[string]interface{}) map[string]string {
+	result := map[string]string{}
+	for k, v := range values {
+		switch value := v.(type) {
+		case string:
+			result[k] = value
+		case json.Number:
+			result[k] = value.String()
+		case bool:
+			result[k] = fmt.Sprintf("%t", value)
+		case nil:
+			result[k] = ""
+		default:
+			b, _ := json.Marshal(value)
+			result[k] = string(b)
+		}
+	}
+	return result
+}
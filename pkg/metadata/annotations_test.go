@@ -0,0 +1,120 @@
+package metadata
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	output := `Loading data...
+epoch 1 loss=0.3
+[[DOTSCIENCE-RUN:r1]]{
+  "version": "1",
+  "description": "Training",
+  "workload-file": "train.py",
+  "input": ["foo.csv@w0", "bar.csv"],
+  "output": ["model.pkl"],
+  "dataset-input": {"b": ["input.csv@b0"]},
+  "dataset-output": {"d": ["output.csv"]},
+  "labels": {"team": "ml"},
+  "parameters": {"smoothing": 1.0, "normalise": true},
+  "summary": {"rms_error": 0.057, "best": "yes"},
+  "start": "20181004T130607.225",
+  "end": "20181004T130608.225"
+}[[/DOTSCIENCE-RUN:r1]]
+done
+2018-10-04 13:06:09 [[DOTSCIENCE-RUN:r2]]{"error": "out of memory"}[[/DOTSCIENCE-RUN:r2]] exiting
+`
+	runs, problems, err := ParseAnnotations(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Unexpected problems %v", problems)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(runs))
+	}
+
+	r := runs[0]
+	testEqStr(t, r.RunID, "r1")
+	testEqStr(t, r.Description, "Training")
+	testEqStr(t, r.WorkloadFile, "train.py")
+	if !r.Success || r.ErrorMessage != nil {
+		t.Errorf("Expected run r1 to succeed")
+	}
+	testEqIFs(t, r.WorkspaceInputFiles, []InputFile{
+		InputFile{Filename: "foo.csv", Version: "w0"},
+		InputFile{Filename: "bar.csv"},
+	})
+	testEqStrs(t, r.WorkspaceOutputFiles, []string{"model.pkl"})
+	testEqIFs(t, r.DatasetInputFiles["b"], []InputFile{InputFile{Filename: "input.csv", Version: "b0"}})
+	testEqStrs(t, r.DatasetOutputFiles["d"], []string{"output.csv"})
+	testEqMap(t, r.Labels, map[string]string{"team": "ml"})
+	testEqMap(t, r.Parameters, map[string]string{"smoothing": "1.0", "normalise": "true"})
+	testEqMap(t, r.Summary, map[string]string{"rms_error": "0.057", "best": "yes"})
+	testEqTime(t, r.ExecStart, time.Date(2018, 10, 4, 13, 6, 7, 225000000, time.UTC))
+	testEqTime(t, r.ExecEnd, time.Date(2018, 10, 4, 13, 6, 8, 225000000, time.UTC))
+
+	r = runs[1]
+	testEqStr(t, r.RunID, "r2")
+	if r.Success || r.ErrorMessage == nil {
+		t.Fatalf("Expected run r2 to fail")
+	}
+	testEqStr(t, *r.ErrorMessage, "out of memory")
+}
+
+func TestParseAnnotationsDamaged(t *testing.T) {
+	output := `[[DOTSCIENCE-RUN:r1]]{"description": "interrupted"
+[[DOTSCIENCE-RUN:r2]]{"description": "ok"}[[/DOTSCIENCE-RUN:r2]]
+[[DOTSCIENCE-RUN:r3]]{not json}[[/DOTSCIENCE-RUN:r3]]
+[[DOTSCIENCE-RUN:not an id]] [[DOTSCIENCE-RUN:r4]]{"description": "ok"}[[/DOTSCIENCE-RUN:r4]]
+[[DOTSCIENCE-RUN:r4.5]]{"description": "dotted"}[[/DOTSCIENCE-RUN:r4.5]]
+[[DOTSCIENCE-RUN:r5]]{"description": "trunc`
+	for _, r := range []io.Reader{strings.NewReader(output), &oneByteReader{output}} {
+		runs, problems, err := ParseAnnotations(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 2 {
+			t.Fatalf("Expected 2 runs, got %d", len(runs))
+		}
+		testEqStr(t, runs[0].RunID, "r2")
+		testEqStr(t, runs[1].RunID, "r4")
+
+		var ids []string
+		for _, p := range problems {
+			ids = append(ids, p.RunID)
+		}
+		testEqStrs(t, ids, []string{"r1", "r3", "r4.5", "r5"})
+	}
+}
+
+// oneByteReader returns its input a byte at a time, so markers are split
+// across reads.
+type oneByteReader struct {
+	s string
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if r.s == "" {
+		return 0, io.EOF
+	}
+	p[0] = r.s[0]
+	r.s = r.s[1:]
+	return 1, nil
+}
+
+func TestParseAnnotationsSplitReads(t *testing.T) {
+	output := "noise [[DOTSCIENCE-RUN:r1]]{\"output\": [\"a\"]}[[/DOTSCIENCE-RUN:r1]] more noise"
+	runs, problems, err := ParseAnnotations(&oneByteReader{output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || len(problems) != 0 {
+		t.Fatalf("Expected 1 run and no problems, got %v and %v", runs, problems)
+	}
+	testEqStrs(t, runs[0].WorkspaceOutputFiles, []string{"a"})
+}
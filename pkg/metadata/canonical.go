@@ -0,0 +1,310 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CanonicalMetadata returns a normalised copy of a string->string map in the
+// Dotscience Run Commit Metadata or Run Dataset Commit Metadata format, so
+// that the same logical metadata always has the same representation:
+//
+// JSON list and map values of known keys are re-encoded compactly; file
+// lists, whose order carries no meaning, are sorted; timestamps are written
+// in their shortest UTC form; and the "runs" list is ordered by run start
+// time, then run ID. In run commit metadata, keys left at their defaults,
+// such as "success", are written out the way EncodeCommitMetadata writes
+// them, and empty values it would leave out are dropped. Values that can't
+// be parsed are kept verbatim, so that metadata which would parse the same
+// way despite being malformed still hashes differently.
+func CanonicalMetadata(input map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range input {
+		result[k] = v
+	}
+
+	runIds := []string{}
+	validRuns := false
+	if v, ok := input["runs"]; ok {
+		err := json.Unmarshal([]byte(v), &runIds)
+		if err != nil {
+			runIds = []string{}
+		} else {
+			validRuns = true
+		}
+	}
+
+	// Lists whose order matters
+	for _, k := range []string{"workload.command", "exec.logs", "runner.cpu", "runner.gpu"} {
+		canonicalList(result, k, false)
+	}
+	if v, ok := result["workload.environment"]; ok {
+		env := map[string]string{}
+		if json.Unmarshal([]byte(v), &env) == nil {
+			b, _ := json.Marshal(env)
+			result["workload.environment"] = string(b)
+		}
+	}
+	canonicalTime(result, "exec.start")
+	canonicalTime(result, "exec.end")
+
+	for _, runId := range runIds {
+		prefix := "run." + runId + "."
+		canonicalTime(result, prefix+"start")
+		canonicalTime(result, prefix+"end")
+		canonicalList(result, prefix+"input-files", true)
+		canonicalList(result, prefix+"output-files", true)
+	}
+	if _, ok := input["runs"]; ok && isCommitMetadata(input) {
+		canonicalDefaults(result, runIds)
+	}
+
+	// Dataset file lists, in both workspace and dataset commits, and file
+	// hash maps
+	for k := range result {
+		if !strings.HasPrefix(k, "run.") {
+			continue
+		}
+		if strings.Contains(k, ".dataset-input-files") || strings.Contains(k, ".dataset-output-files") {
+			canonicalList(result, k, true)
+		} else if strings.Contains(k, "-file-hashes") {
+			canonicalMap(result, k)
+		}
+	}
+
+	if validRuns {
+		starts := map[string]time.Time{}
+		for _, runId := range runIds {
+			t, err := time.Parse(TimeFormat, result["run."+runId+".start"])
+			if err == nil {
+				starts[runId] = t
+			}
+		}
+		sorted := append([]string{}, runIds...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			ti, tj := starts[sorted[i]], starts[sorted[j]]
+			if !ti.Equal(tj) {
+				return ti.Before(tj)
+			}
+			return sorted[i] < sorted[j]
+		})
+		result["runs"] = encodeStringSlice(sorted)
+	}
+
+	return result
+}
+
+// isCommitMetadata reports whether a map is run commit metadata, rather
+// than dataset commit metadata or something else.
+func isCommitMetadata(m map[string]string) bool {
+	commitType, ok := m["type"]
+	if !ok {
+		_, ok = m["runs"]
+		return ok
+	}
+	return commitType == "dotscience.run.v1"
+}
+
+// canonicalDefaults writes out the keys of run commit metadata that
+// ParseCommitMetadata defaults when they are missing or empty, and drops the
+// empty values that EncodeCommitMetadata leaves out.
+func canonicalDefaults(m map[string]string, runIds []string) {
+	if _, ok := m["type"]; !ok {
+		m["type"] = "dotscience.run.v1"
+	}
+	if m["success"] == "" {
+		m["success"] = "true"
+	}
+
+	dropEmpty := func(key string, empty string) {
+		if v, ok := m[key]; ok && (v == "" || v == empty) {
+			delete(m, key)
+		}
+	}
+	for _, k := range []string{"author", "message", "workload.type", "workload.image", "workload.image.hash",
+		"exec.start", "exec.end", "runner.name", "runner.version", "runner.platform", "runner.platform_version"} {
+		dropEmpty(k, "")
+	}
+	for _, k := range []string{"workload.command", "exec.logs", "runner.cpu", "runner.gpu"} {
+		dropEmpty(k, "[]")
+	}
+	dropEmpty("workload.environment", "{}")
+
+	for _, runId := range runIds {
+		prefix := "run." + runId + "."
+		if m[prefix+"authority"] == "" {
+			m[prefix+"authority"] = RunAuthority_Correction.String()
+		}
+		for _, k := range []string{"description", "workload-file", "start", "end"} {
+			dropEmpty(prefix+k, "")
+		}
+		dropEmpty(prefix+"input-files", "[]")
+		dropEmpty(prefix+"output-files", "[]")
+	}
+}
+
+// canonicalList re-encodes a JSON list of strings compactly, optionally
+// sorting it. Values that are not JSON lists are left alone.
+func canonicalList(m map[string]string, key string, sorted bool) {
+	v, ok := m[key]
+	if !ok {
+		return
+	}
+	list := []string{}
+	if json.Unmarshal([]byte(v), &list) != nil {
+		return
+	}
+	if sorted {
+		sort.Strings(list)
+	}
+	m[key] = encodeStringSlice(list)
+}
+
+// canonicalMap re-encodes a JSON map of strings compactly, with sorted keys.
+// Values that are not JSON maps are left alone.
+func canonicalMap(m map[string]string, key string) {
+	v, ok := m[key]
+	if !ok {
+		return
+	}
+	values := map[string]string{}
+	if json.Unmarshal([]byte(v), &values) != nil {
+		return
+	}
+	b, _ := json.Marshal(values)
+	m[key] = string(b)
+}
+
+// canonicalTime rewrites a timestamp in its shortest UTC form. Values that
+// are not valid timestamps are left alone.
+func canonicalTime(m map[string]string, key string) {
+	v, ok := m[key]
+	if !ok {
+		return
+	}
+	t, err := time.Parse(TimeFormat, v)
+	if err != nil {
+		return
+	}
+	m[key] = encodeTime(t)
+}
+
+// CanonicalBytes returns the canonical serialisation of a metadata map: its
+// canonical form, as a JSON object with keys in sorted order.
+func CanonicalBytes(input map[string]string) []byte {
+	// encoding/json writes map keys in sorted order
+	b, _ := json.Marshal(CanonicalMetadata(input))
+	return b
+}
+
+// ContentHash returns the hex-encoded SHA-256 of the canonical serialisation
+// of a metadata map.
+func ContentHash(input map[string]string) string {
+	sum := sha256.Sum256(CanonicalBytes(input))
+	return hex.EncodeToString(sum[:])
+}
+
+// CommitContentHash returns the content hash of a CommitMetadata, which is
+// the content hash of its flat encoding. It matches the ContentHash of the
+// metadata map it was parsed from, provided that map is well formed and
+// holds no keys that ParseCommitMetadata ignores.
+func CommitContentHash(cm CommitMetadata) string {
+	return ContentHash(EncodeCommitMetadata(cm))
+}
+
+// CanonicalCommitMetadata returns a normalised copy of a CommitMetadata:
+// times are in UTC, runs are ordered by start time then run ID, and each
+// run's file lists are sorted.
+func CanonicalCommitMetadata(cm CommitMetadata) CommitMetadata {
+	result := cm
+	result.ExecStart = canonicalTimeValue(cm.ExecStart)
+	result.ExecEnd = canonicalTimeValue(cm.ExecEnd)
+
+	result.Runs = make([]RunMetadata, len(cm.Runs))
+	for idx, run := range cm.Runs {
+		result.Runs[idx] = canonicalRun(run)
+	}
+	sort.SliceStable(result.Runs, func(i, j int) bool {
+		ri, rj := result.Runs[i], result.Runs[j]
+		if !ri.ExecStart.Equal(rj.ExecStart) {
+			return ri.ExecStart.Before(rj.ExecStart)
+		}
+		return ri.RunID < rj.RunID
+	})
+	return result
+}
+
+func canonicalRun(run RunMetadata) RunMetadata {
+	result := run
+	result.ExecStart = canonicalTimeValue(run.ExecStart)
+	result.ExecEnd = canonicalTimeValue(run.ExecEnd)
+
+	result.WorkspaceInputFiles = sortedInputFiles(run.WorkspaceInputFiles)
+	result.WorkspaceOutputFiles = sortedStrings(run.WorkspaceOutputFiles)
+
+	if run.DatasetInputFiles != nil {
+		result.DatasetInputFiles = map[string][]InputFile{}
+		for name, ifs := range run.DatasetInputFiles {
+			result.DatasetInputFiles[name] = sortedInputFiles(ifs)
+		}
+	}
+	if run.DatasetOutputFiles != nil {
+		result.DatasetOutputFiles = map[string][]string{}
+		for name, filenames := range run.DatasetOutputFiles {
+			result.DatasetOutputFiles[name] = sortedStrings(filenames)
+		}
+	}
+
+	result.WorkspaceOutputHashes = sortedOutputFiles(run.WorkspaceOutputHashes)
+	if run.DatasetOutputHashes != nil {
+		result.DatasetOutputHashes = map[string][]OutputFile{}
+		for name, ofs := range run.DatasetOutputHashes {
+			result.DatasetOutputHashes[name] = sortedOutputFiles(ofs)
+		}
+	}
+	return result
+}
+
+func canonicalTimeValue(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+func sortedStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	result := append([]string{}, s...)
+	sort.Strings(result)
+	return result
+}
+
+// sortedInputFiles sorts input files the same way CanonicalMetadata sorts
+// their FILE@VERSION encoding.
+func sortedInputFiles(ifs []InputFile) []InputFile {
+	if ifs == nil {
+		return nil
+	}
+	result := append([]InputFile{}, ifs...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Filename+"@"+result[i].Version < result[j].Filename+"@"+result[j].Version
+	})
+	return result
+}
+
+func sortedOutputFiles(ofs []OutputFile) []OutputFile {
+	if ofs == nil {
+		return nil
+	}
+	result := append([]OutputFile{}, ofs...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Filename < result[j].Filename
+	})
+	return result
+}
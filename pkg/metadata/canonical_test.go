@@ -0,0 +1,184 @@
+package metadata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanonicalMetadata(t *testing.T) {
+	a := map[string]string{
+		"type":                          "dotscience.run.v1",
+		"success":                       "true",
+		"workload.command":              "[\"sh\", \"-c\", \"true\"]",
+		"workload.environment":          "{\"B\": \"2\", \"A\": \"1\"}",
+		"exec.start":                    "20181004T130607.100",
+		"runs":                          "[\"r2\", \"r1\"]",
+		"run.r1.start":                  "20181004T130607.225000",
+		"run.r2.start":                  "20181004T130608.5",
+		"run.r1.output-files":           "[\"b.txt\", \"a.txt\"]",
+		"run.r1.input-files":            "[\"z.csv@v1\", \"y.csv@v2\"]",
+		"run.r1.parameters.raw":         "[1, 2]",
+		"run.r2.dataset-output-files.d": "[\"out2.csv\", \"out1.csv\"]",
+	}
+	c := CanonicalMetadata(a)
+
+	testEqStr(t, c["workload.command"], "[\"sh\",\"-c\",\"true\"]")
+	testEqStr(t, c["workload.environment"], "{\"A\":\"1\",\"B\":\"2\"}")
+	testEqStr(t, c["exec.start"], "20181004T130607.1")
+	testEqStr(t, c["runs"], "[\"r1\",\"r2\"]")
+	testEqStr(t, c["run.r1.start"], "20181004T130607.225")
+	testEqStr(t, c["run.r1.output-files"], "[\"a.txt\",\"b.txt\"]")
+	testEqStr(t, c["run.r1.input-files"], "[\"y.csv@v2\",\"z.csv@v1\"]")
+	testEqStr(t, c["run.r2.dataset-output-files.d"], "[\"out1.csv\",\"out2.csv\"]")
+	// Parameter values are user data, and not touched
+	testEqStr(t, c["run.r1.parameters.raw"], "[1, 2]")
+
+	// The input is not modified
+	testEqStr(t, a["runs"], "[\"r2\", \"r1\"]")
+}
+
+func TestContentHash(t *testing.T) {
+	a := map[string]string{
+		"runs":                "[\"r1\", \"r2\"]",
+		"run.r1.start":        "20181004T130607.225",
+		"run.r2.start":        "20181004T130608.225",
+		"run.r1.output-files": "[\"b.txt\",\"a.txt\"]",
+	}
+	b := map[string]string{
+		"runs":                "[\"r2\",\"r1\"]",
+		"run.r1.start":        "20181004T130607.225000000",
+		"run.r2.start":        "20181004T130608.225",
+		"run.r1.output-files": "[\"a.txt\", \"b.txt\"]",
+	}
+	if ContentHash(a) != ContentHash(b) {
+		t.Errorf("Expected equivalent metadata to hash identically:\n%s\n%s", CanonicalBytes(a), CanonicalBytes(b))
+	}
+	if len(ContentHash(a)) != 64 {
+		t.Errorf("Expected a hex SHA-256, got %s", ContentHash(a))
+	}
+
+	b["run.r2.start"] = "20181004T130608.226"
+	if ContentHash(a) == ContentHash(b) {
+		t.Errorf("Expected different metadata to hash differently")
+	}
+}
+
+func TestCommitContentHash(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	cm := CommitMetadata{
+		Success:   true,
+		ExecStart: time.Date(2018, 10, 4, 8, 6, 7, 0, est),
+		Runs: []RunMetadata{
+			{RunID: "r2", Success: true, ExecStart: time.Date(2018, 10, 4, 13, 6, 9, 0, time.UTC)},
+			{RunID: "r1", Success: true, ExecStart: time.Date(2018, 10, 4, 13, 6, 8, 0, time.UTC), WorkspaceOutputFiles: []string{"b", "a"}},
+		},
+	}
+	canonical := CanonicalCommitMetadata(cm)
+	testEqStr(t, canonical.Runs[0].RunID, "r1")
+	testEqStrs(t, canonical.Runs[0].WorkspaceOutputFiles, []string{"a", "b"})
+	if canonical.ExecStart.Location() != time.UTC {
+		t.Errorf("Expected UTC, got %v", canonical.ExecStart)
+	}
+	testEqStrs(t, cm.Runs[1].WorkspaceOutputFiles, []string{"b", "a"})
+
+	if CommitContentHash(cm) != CommitContentHash(canonical) {
+		t.Errorf("Expected canonicalisation not to change the hash")
+	}
+	if CommitContentHash(cm) != ContentHash(EncodeCommitMetadata(canonical)) {
+		t.Errorf("Expected struct and map hashes to agree")
+	}
+	if CommitContentHash(ParseCommitMetadata(EncodeCommitMetadata(cm))) != CommitContentHash(cm) {
+		t.Errorf("Expected the hash to survive a round trip through the flat format")
+	}
+}
+
+func TestContentHashParsed(t *testing.T) {
+	fixtures := []map[string]string{
+		{
+			"type":                          "dotscience.run.v1",
+			"author":                        "452342",
+			"workload.image":                "busybox",
+			"workload.command":              "[\"sh\", \"-c\", \"true\"]",
+			"workload.environment":          "{\"DEBUG_MODE\": \"YES\"}",
+			"runner.cpu":                    "[\"Intel(R) Core(TM) i7-7500U CPU @ 2.70GHz\"]",
+			"runner.ram":                    "16579702784",
+			"exec.start":                    "20181004T130607.101",
+			"exec.end":                      "20181004T130610.223",
+			"input-dataset.b":               "<ID of dot B>@<commit ID of dot B before the run>",
+			"output-dataset.d":              "<ID of dot D>@<commit ID of dot D created by this run>",
+			"runs":                          "[\"r1\", \"r2\"]",
+			"run.r1.authority":              "workload",
+			"run.r1.input-files":            "[\"foo.csv@v1\"]",
+			"run.r1.dataset-input-files.b":  "[\"input.csv@b1\"]",
+			"run.r1.output-files":           "[\"log.txt\"]",
+			"run.r1.dataset-output-files.d": "[\"output.csv\"]",
+			"run.r1.summary.rms_error":      "0.057",
+			"run.r1.parameters.smoothing":   "1.0",
+			"run.r1.start":                  "20181004T130607.225",
+			"run.r1.end":                    "20181004T130608.225",
+			"run.r2.authority":              "correction",
+			"run.r2.description":            "File changes were detected that the run metadata did not explain",
+			"run.r2.output-files":           "[\"mylibrary.pyc\"]",
+		},
+		{
+			"type":             "dotscience.run.v1",
+			"author":           "1",
+			"runs":             "[\"r1\"]",
+			"run.r1.authority": "workload",
+			"run.r1.start":     "20181004T130607.225",
+		},
+		{
+			"runs":                  "[\"r2\", \"r1\"]",
+			"success":               "",
+			"message":               "",
+			"exec.start":            "20181004T130607.100",
+			"run.r1.start":          "20181004T130607.225000",
+			"run.r2.start":          "20181004T130608.5",
+			"run.r1.input-files":    "[\"z.csv@v1\", \"y.csv@v2\"]",
+			"run.r1.output-files":   "[]",
+			"run.r1.parameters.raw": "[1, 2]",
+			"run.r2.error":          "",
+		},
+	}
+	for idx, m := range fixtures {
+		if ContentHash(m) != CommitContentHash(ParseCommitMetadata(m)) {
+			t.Errorf("Expected fixture %d to hash the same parsed:\n%s\n%s", idx,
+				CanonicalBytes(m), CanonicalBytes(EncodeCommitMetadata(ParseCommitMetadata(m))))
+		}
+	}
+}
+
+func TestContentHashMalformed(t *testing.T) {
+	base := map[string]string{
+		"type":               "dotscience.run.v1",
+		"success":            "false",
+		"runs":               "[\"r1\"]",
+		"run.r1.authority":   "correction",
+		"run.r1.input-files": "[\"@\"]",
+	}
+	// Each value parses the same as the one in base, but isn't the same
+	// metadata, so must not hash the same
+	for key, malformed := range map[string]string{
+		"success":            "yes",
+		"run.r1.authority":   "bogus",
+		"run.r1.input-files": "[\"a@b@c\"]",
+	} {
+		m := map[string]string{}
+		for k, v := range base {
+			m[k] = v
+		}
+		m[key] = malformed
+		if CommitContentHash(ParseCommitMetadata(m)) != ContentHash(base) {
+			t.Errorf("Expected %s=%s to parse the same as base", key, malformed)
+		}
+		if ContentHash(m) == ContentHash(base) {
+			t.Errorf("Expected %s=%s to hash differently from base", key, malformed)
+		}
+	}
+
+	empty := map[string]string{"type": "dotscience.run.v1", "success": "false", "runs": "[]"}
+	unparseable := map[string]string{"type": "dotscience.run.v1", "success": "false", "runs": "[\"r1\""}
+	if ContentHash(empty) == ContentHash(unparseable) {
+		t.Errorf("Expected unparseable runs to hash differently from no runs")
+	}
+}
@@ -0,0 +1,110 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// PrevMetadataHashKey is the metadata key holding the ContentHash of the
+// parent commit's metadata, chaining each commit to its history.
+const PrevMetadataHashKey = "prev-metadata-hash"
+
+// type ChainedCommit is a commit's ID and its metadata map, as found in a
+// dot's history.
+type ChainedCommit struct {
+	CommitID string            `json:"id"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// ChainMetadata returns a copy of input with PrevMetadataHashKey set to the
+// content hash of the parent commit's metadata. A nil parent means the
+// commit has no parent, and the key is removed.
+func ChainMetadata(input map[string]string, parent map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range input {
+		result[k] = v
+	}
+	if parent == nil {
+		delete(result, PrevMetadataHashKey)
+	} else {
+		result[PrevMetadataHashKey] = ContentHash(parent)
+	}
+	return result
+}
+
+// type ChainBreak describes the first commit whose recorded parent hash does
+// not match its parent's metadata.
+type ChainBreak struct {
+	// Index is the position of the commit in the sequence that was checked.
+	Index    int
+	CommitID string
+
+	// Expected is the content hash of the parent's metadata, and Actual is
+	// the hash recorded in the commit; empty if it has none. Expected is
+	// empty if the commit records a parent hash but the sequence holds no
+	// parent to check it against.
+	Expected string
+	Actual   string
+}
+
+func (b *ChainBreak) Error() string {
+	if b.Expected == "" {
+		return fmt.Sprintf("commit %s has %s %s, but its parent is missing", b.CommitID, PrevMetadataHashKey, b.Actual)
+	}
+	if b.Actual == "" {
+		return fmt.Sprintf("commit %s has no %s, expected %s", b.CommitID, PrevMetadataHashKey, b.Expected)
+	}
+	return fmt.Sprintf("commit %s has %s %s, expected %s", b.CommitID, PrevMetadataHashKey, b.Actual, b.Expected)
+}
+
+// VerifyChain walks a sequence of commits, oldest first, and returns the
+// first break in the hash chain, or nil if there is none.
+//
+// The chain starts at the first commit that has a PrevMetadataHashKey;
+// earlier commits are assumed to predate chaining. From the start of the
+// chain onwards, every commit must record the hash of the one before it.
+// The sequence must start at the root of the history: if its first commit
+// records a parent hash, the parent can't be checked, so that is reported as
+// a break rather than taken on trust.
+func VerifyChain(commits []ChainedCommit) *ChainBreak {
+	chained := false
+	for idx, c := range commits {
+		actual, ok := c.Metadata[PrevMetadataHashKey]
+		if !chained {
+			if !ok {
+				continue
+			}
+			chained = true
+			if idx == 0 {
+				return &ChainBreak{
+					Index:    idx,
+					CommitID: c.CommitID,
+					Actual:   actual,
+				}
+			}
+		}
+
+		expected := ContentHash(commits[idx-1].Metadata)
+		if actual != expected {
+			return &ChainBreak{
+				Index:    idx,
+				CommitID: c.CommitID,
+				Expected: expected,
+				Actual:   actual,
+			}
+		}
+	}
+	return nil
+}
+
+// ReadChainedCommits reads a JSON list of commits, oldest first, each an
+// object with "id" and "metadata" fields.
+func ReadChainedCommits(r io.Reader) ([]ChainedCommit, error) {
+	commits := []ChainedCommit{}
+	err := json.NewDecoder(r).Decode(&commits)
+	if err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+)
+
+func testChain() []ChainedCommit {
+	var commits []ChainedCommit
+	var parent map[string]string
+	for _, id := range []string{"c1", "c2", "c3", "c4"} {
+		m := ChainMetadata(map[string]string{
+			"type":    "dotscience.run.v1",
+			"message": "commit " + id,
+			"runs":    "[]",
+		}, parent)
+		commits = append(commits, ChainedCommit{CommitID: id, Metadata: m})
+		parent = m
+	}
+	return commits
+}
+
+func TestVerifyChain(t *testing.T) {
+	commits := testChain()
+	if _, ok := commits[0].Metadata[PrevMetadataHashKey]; ok {
+		t.Errorf("Did not expect the root commit to have a parent hash")
+	}
+	if b := VerifyChain(commits); b != nil {
+		t.Errorf("Expected an intact chain, got %s", b)
+	}
+
+	// Rewriting history breaks the link to the next commit
+	commits[1].Metadata["message"] = "rewritten"
+	b := VerifyChain(commits)
+	if b == nil {
+		t.Fatalf("Expected a break")
+	}
+	testEqStr(t, b.CommitID, "c3")
+	if b.Index != 2 || b.Actual != commits[2].Metadata[PrevMetadataHashKey] || b.Expected != ContentHash(commits[1].Metadata) {
+		t.Errorf("Unexpected break %#v", b)
+	}
+}
+
+func TestVerifyChainLegacyPrefix(t *testing.T) {
+	legacy := ChainedCommit{CommitID: "c0", Metadata: map[string]string{"runs": "[]"}}
+	commits := append([]ChainedCommit{legacy}, testChain()...)
+	if b := VerifyChain(commits); b != nil {
+		t.Errorf("Expected commits before the chain starts to be accepted, got %s", b)
+	}
+
+	delete(commits[3].Metadata, PrevMetadataHashKey)
+	b := VerifyChain(commits)
+	if b == nil || b.CommitID != "c3" || b.Actual != "" {
+		t.Errorf("Expected a missing hash to break the chain, got %#v", b)
+	}
+}
+
+func TestVerifyChainMalformedValue(t *testing.T) {
+	commits := testChain()
+	commits[1].Metadata["success"] = "yes"
+	commits[2].Metadata = ChainMetadata(commits[2].Metadata, commits[1].Metadata)
+	commits[3].Metadata = ChainMetadata(commits[3].Metadata, commits[2].Metadata)
+	if b := VerifyChain(commits); b != nil {
+		t.Fatalf("Expected an intact chain, got %s", b)
+	}
+
+	// "maybe" parses the same as "yes", but is still a change
+	commits[1].Metadata["success"] = "maybe"
+	b := VerifyChain(commits)
+	if b == nil || b.CommitID != "c3" {
+		t.Errorf("Expected changing a malformed value to break the chain, got %#v", b)
+	}
+}
+
+func TestVerifyChainPartial(t *testing.T) {
+	commits := testChain()[1:]
+	b := VerifyChain(commits)
+	if b == nil || b.Index != 0 || b.CommitID != "c2" || b.Expected != "" {
+		t.Fatalf("Expected a chain without its root to be rejected, got %#v", b)
+	}
+	if !strings.Contains(b.Error(), "parent is missing") {
+		t.Errorf("Unexpected error %s", b)
+	}
+}
+
+func TestReadChainedCommits(t *testing.T) {
+	commits, err := ReadChainedCommits(strings.NewReader(`[
+		{"id": "c1", "metadata": {"runs": "[]"}},
+		{"id": "c2", "metadata": {"runs": "[]", "prev-metadata-hash": "0000"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := VerifyChain(commits)
+	if b == nil || b.CommitID != "c2" || b.Actual != "0000" {
+		t.Errorf("Unexpected result %#v", b)
+	}
+	if !strings.Contains(b.Error(), "c2") {
+		t.Errorf("Expected the error to name the commit, got %s", b)
+	}
+}
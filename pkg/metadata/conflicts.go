@@ -0,0 +1,222 @@
+package metadata
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// type FileRef names a file in the workspace, or in a dataset.
+type FileRef struct {
+	// Dataset is the name of the dataset holding the file, or empty for
+	// the workspace.
+	Dataset  string
+	Filename string
+}
+
+func (f FileRef) String() string {
+	if f.Dataset == "" {
+		return f.Filename
+	}
+	return f.Dataset + ":" + f.Filename
+}
+
+// type ConflictKind classifies two runs in a commit writing the same file.
+type ConflictKind int
+
+const (
+	// The second run started after the first finished, so its output
+	// replaced the first's
+	Conflict_SequentialOverwrite ConflictKind = iota
+	// The runs overlapped in time, so which output survived is down to
+	// chance. Runs with unknown times are treated as overlapping.
+	Conflict_ConcurrentWrite
+)
+
+func (k ConflictKind) String() string {
+	switch k {
+	case Conflict_SequentialOverwrite:
+		return "sequential overwrite"
+	case Conflict_ConcurrentWrite:
+		return "concurrent write"
+	default:
+		return fmt.Sprintf("conflict %d", int(k))
+	}
+}
+
+// type WriteConflict records two runs in a commit writing the same file.
+// FirstRunID is the run that started first, or comes first in the commit's
+// runs if that is unknown.
+type WriteConflict struct {
+	File        FileRef
+	Kind        ConflictKind
+	FirstRunID  string
+	SecondRunID string
+}
+
+// type Dependency records a run reading a file that another run in the
+// same commit wrote. Ordered is true if the write finished before the read
+// started, or, when either run's times are unknown, if the writer comes
+// first in the commit's runs; otherwise the reader may have seen the file
+// before, during or after the write.
+type Dependency struct {
+	File        FileRef
+	WriterRunID string
+	ReaderRunID string
+	Ordered     bool
+}
+
+// type ConflictReport is the result of FindConflicts.
+type ConflictReport struct {
+	Conflicts    []WriteConflict
+	Dependencies []Dependency
+}
+
+// runFiles returns the files a run wrote or read, normalised.
+func runFiles(run RunMetadata) (written []FileRef, read []FileRef) {
+	seen := map[FileRef]bool{}
+	add := func(list []FileRef, f FileRef) []FileRef {
+		if seen[f] {
+			return list
+		}
+		seen[f] = true
+		return append(list, f)
+	}
+
+	for _, f := range run.WorkspaceOutputFiles {
+		written = add(written, FileRef{Filename: cleanFilename(f)})
+	}
+	for name, files := range run.DatasetOutputFiles {
+		for _, f := range files {
+			written = add(written, FileRef{Dataset: name, Filename: cleanFilename(f)})
+		}
+	}
+
+	seen = map[FileRef]bool{}
+	for _, inf := range run.WorkspaceInputFiles {
+		read = add(read, FileRef{Filename: cleanFilename(inf.Filename)})
+	}
+	for name, ifs := range run.DatasetInputFiles {
+		for _, inf := range ifs {
+			read = add(read, FileRef{Dataset: name, Filename: cleanFilename(inf.Filename)})
+		}
+	}
+	return written, read
+}
+
+func hasTimes(run RunMetadata) bool {
+	return !run.ExecStart.IsZero() && !run.ExecEnd.IsZero()
+}
+
+// finishedBefore reports whether run a is known to have finished before run
+// b started.
+func finishedBefore(a, b RunMetadata) bool {
+	return hasTimes(a) && hasTimes(b) && !a.ExecEnd.After(b.ExecStart)
+}
+
+// runsBefore reports whether run a, at index i in the commit's runs,
+// started before run b, at index j.
+func runsBefore(a RunMetadata, i int, b RunMetadata, j int) bool {
+	if hasTimes(a) && hasTimes(b) && !a.ExecStart.Equal(b.ExecStart) {
+		return a.ExecStart.Before(b.ExecStart)
+	}
+	return i < j
+}
+
+// FindConflicts finds the files in a commit written by more than one run,
+// and the files read by one run that another run wrote. Results are sorted
+// by file, then run.
+func FindConflicts(cm CommitMetadata) ConflictReport {
+	writers := map[FileRef][]int{}
+	readers := map[FileRef][]int{}
+	for idx, run := range cm.Runs {
+		written, read := runFiles(run)
+		for _, f := range written {
+			writers[f] = append(writers[f], idx)
+		}
+		for _, f := range read {
+			readers[f] = append(readers[f], idx)
+		}
+	}
+
+	report := ConflictReport{
+		Conflicts:    []WriteConflict{},
+		Dependencies: []Dependency{},
+	}
+
+	for f, idxs := range writers {
+		for x := 0; x < len(idxs); x++ {
+			for y := x + 1; y < len(idxs); y++ {
+				i, j := idxs[x], idxs[y]
+				a, b := cm.Runs[i], cm.Runs[j]
+				if !runsBefore(a, i, b, j) {
+					a, b = b, a
+				}
+				kind := Conflict_ConcurrentWrite
+				if finishedBefore(a, b) {
+					kind = Conflict_SequentialOverwrite
+				}
+				report.Conflicts = append(report.Conflicts, WriteConflict{
+					File:        f,
+					Kind:        kind,
+					FirstRunID:  a.RunID,
+					SecondRunID: b.RunID,
+				})
+			}
+		}
+
+		for _, i := range idxs {
+			for _, j := range readers[f] {
+				if i == j {
+					continue
+				}
+				writer, reader := cm.Runs[i], cm.Runs[j]
+				ordered := finishedBefore(writer, reader)
+				if !hasTimes(writer) || !hasTimes(reader) {
+					ordered = i < j
+				}
+				report.Dependencies = append(report.Dependencies, Dependency{
+					File:        f,
+					WriterRunID: writer.RunID,
+					ReaderRunID: reader.RunID,
+					Ordered:     ordered,
+				})
+			}
+		}
+	}
+
+	fileLess := func(a, b FileRef) bool {
+		if a.Dataset != b.Dataset {
+			return a.Dataset < b.Dataset
+		}
+		return a.Filename < b.Filename
+	}
+	sort.SliceStable(report.Conflicts, func(i, j int) bool {
+		a, b := report.Conflicts[i], report.Conflicts[j]
+		if a.File != b.File {
+			return fileLess(a.File, b.File)
+		}
+		if a.FirstRunID != b.FirstRunID {
+			return a.FirstRunID < b.FirstRunID
+		}
+		return a.SecondRunID < b.SecondRunID
+	})
+	sort.SliceStable(report.Dependencies, func(i, j int) bool {
+		a, b := report.Dependencies[i], report.Dependencies[j]
+		if a.File != b.File {
+			return fileLess(a.File, b.File)
+		}
+		if a.WriterRunID != b.WriterRunID {
+			return a.WriterRunID < b.WriterRunID
+		}
+		return a.ReaderRunID < b.ReaderRunID
+	})
+	return report
+}
+
+// cleanFilename normalises a slash-separated filename relative to the root
+// of a dot, so that it can't refer outside it.
+func cleanFilename(filename string) string {
+	return strings.TrimPrefix(path.Clean("/"+filename), "/")
+}
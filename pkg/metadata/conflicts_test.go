@@ -0,0 +1,62 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testRunAt(id string, start, end int) RunMetadata {
+	base := time.Date(2018, 10, 4, 13, 0, 0, 0, time.UTC)
+	return RunMetadata{
+		RunID:     id,
+		Success:   true,
+		ExecStart: base.Add(time.Duration(start) * time.Minute),
+		ExecEnd:   base.Add(time.Duration(end) * time.Minute),
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	prepare := testRunAt("prepare", 0, 10)
+	prepare.WorkspaceOutputFiles = []string{"clean.csv", "./log.txt"}
+
+	train := testRunAt("train", 10, 20)
+	train.WorkspaceInputFiles = []InputFile{InputFile{Filename: "clean.csv", Version: "w1"}}
+	train.WorkspaceOutputFiles = []string{"log.txt"}
+	train.DatasetOutputFiles = map[string][]string{"d": []string{"model.pkl"}}
+
+	evaluate := testRunAt("evaluate", 15, 25)
+	evaluate.DatasetInputFiles = map[string][]InputFile{"d": []InputFile{InputFile{Filename: "model.pkl", Version: "d0"}}}
+	evaluate.DatasetOutputFiles = map[string][]string{"d": []string{"model.pkl"}}
+
+	report := FindConflicts(CommitMetadata{Runs: []RunMetadata{evaluate, train, prepare}})
+
+	expectedConflicts := []WriteConflict{
+		WriteConflict{File: FileRef{Filename: "log.txt"}, Kind: Conflict_SequentialOverwrite, FirstRunID: "prepare", SecondRunID: "train"},
+		WriteConflict{File: FileRef{Dataset: "d", Filename: "model.pkl"}, Kind: Conflict_ConcurrentWrite, FirstRunID: "train", SecondRunID: "evaluate"},
+	}
+	if !reflect.DeepEqual(report.Conflicts, expectedConflicts) {
+		t.Errorf("Wanted %#v, got %#v", expectedConflicts, report.Conflicts)
+	}
+
+	expectedDependencies := []Dependency{
+		Dependency{File: FileRef{Filename: "clean.csv"}, WriterRunID: "prepare", ReaderRunID: "train", Ordered: true},
+		Dependency{File: FileRef{Dataset: "d", Filename: "model.pkl"}, WriterRunID: "train", ReaderRunID: "evaluate", Ordered: false},
+	}
+	if !reflect.DeepEqual(report.Dependencies, expectedDependencies) {
+		t.Errorf("Wanted %#v, got %#v", expectedDependencies, report.Dependencies)
+	}
+	testEqStr(t, report.Conflicts[1].File.String(), "d:model.pkl")
+}
+
+func TestFindConflictsWithoutTimes(t *testing.T) {
+	a := RunMetadata{RunID: "a", WorkspaceOutputFiles: []string{"x"}}
+	b := RunMetadata{RunID: "b", WorkspaceOutputFiles: []string{"x"}, WorkspaceInputFiles: []InputFile{InputFile{Filename: "x"}}}
+	report := FindConflicts(CommitMetadata{Runs: []RunMetadata{a, b}})
+	if len(report.Conflicts) != 1 || report.Conflicts[0].Kind != Conflict_ConcurrentWrite || report.Conflicts[0].FirstRunID != "a" {
+		t.Errorf("Unexpected conflicts %#v", report.Conflicts)
+	}
+	if len(report.Dependencies) != 1 || !report.Dependencies[0].Ordered {
+		t.Errorf("Unexpected dependencies %#v", report.Dependencies)
+	}
+}
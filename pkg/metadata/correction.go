@@ -0,0 +1,115 @@
+package metadata
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// CorrectionDescription is the description given to correction runs.
+const CorrectionDescription = "File changes were detected that the run metadata did not explain"
+
+// type FileHashes is a view of the contents of a directory: a map from
+// slash-separated paths, relative to the directory, to a hash of each
+// file's contents.
+type FileHashes map[string]string
+
+// type DotSnapshot is a view of the contents of a workspace, and of each
+// of its datasets by name, at a point in time.
+type DotSnapshot struct {
+	Workspace FileHashes
+	Datasets  map[string]FileHashes
+}
+
+// ChangedFiles returns the sorted paths that were added, modified or
+// deleted between before and after.
+func ChangedFiles(before, after FileHashes) []string {
+	changed := []string{}
+	for p, hash := range after {
+		if old, ok := before[p]; !ok || old != hash {
+			changed = append(changed, p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// CorrectionRun compares the snapshots taken before and after a commit's
+// runs, and returns a run with RunAuthority_Correction accounting for
+// every changed file that none of runs declared as an output. A declared
+// output also explains changes to files beneath it, if it names a
+// directory, unless it names the root of the dot. The second result is false if every change was explained.
+func CorrectionRun(before, after DotSnapshot, runs []RunMetadata) (RunMetadata, bool) {
+	var declared []string
+	declaredDatasets := map[string][]string{}
+	for _, r := range runs {
+		declared = append(declared, r.WorkspaceOutputFiles...)
+		for name, files := range r.DatasetOutputFiles {
+			declaredDatasets[name] = append(declaredDatasets[name], files...)
+		}
+	}
+
+	run := RunMetadata{
+		RunID:              NewRunID(),
+		Authority:          RunAuthority_Correction,
+		Description:        CorrectionDescription,
+		Success:            true,
+		DatasetOutputFiles: map[string][]string{},
+	}
+	found := false
+
+	run.WorkspaceOutputFiles = unexplainedFiles(ChangedFiles(before.Workspace, after.Workspace), declared)
+	if len(run.WorkspaceOutputFiles) > 0 {
+		found = true
+	}
+
+	names := map[string]bool{}
+	for name := range before.Datasets {
+		names[name] = true
+	}
+	for name := range after.Datasets {
+		names[name] = true
+	}
+	for name := range names {
+		files := unexplainedFiles(ChangedFiles(before.Datasets[name], after.Datasets[name]), declaredDatasets[name])
+		if len(files) > 0 {
+			run.DatasetOutputFiles[name] = files
+			found = true
+		}
+	}
+
+	return run, found
+}
+
+// unexplainedFiles returns the changed paths not covered by a declared
+// output.
+func unexplainedFiles(changed, declared []string) []string {
+	outputs := []string{}
+	for _, d := range declared {
+		// An output naming the root, such as "." or "/", would explain
+		// every change, so it explains none
+		if o := strings.TrimPrefix(path.Clean("/"+d), "/"); o != "" {
+			outputs = append(outputs, o)
+		}
+	}
+
+	result := []string{}
+	for _, c := range changed {
+		explained := false
+		for _, o := range outputs {
+			if c == o || strings.HasPrefix(c, o+"/") {
+				explained = true
+				break
+			}
+		}
+		if !explained {
+			result = append(result, c)
+		}
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package metadata
+
+import (
+	"testing"
+)
+
+func TestCorrectionRun(t *testing.T) {
+	before := DotSnapshot{
+		Workspace: FileHashes{
+			"train.py":     "h1",
+			"mylibrary.py": "h2",
+			"old.txt":      "h3",
+		},
+		Datasets: map[string]FileHashes{
+			"d": FileHashes{"output.csv": "h4"},
+		},
+	}
+	after := DotSnapshot{
+		Workspace: FileHashes{
+			"train.py":       "h1",
+			"mylibrary.py":   "h2",
+			"mylibrary.pyc":  "h5",
+			"model/weights":  "h6",
+			"model/vocab":    "h7",
+			"results/a.json": "h8",
+		},
+		Datasets: map[string]FileHashes{
+			"d": FileHashes{"output.csv": "h9", "extra.csv": "h10"},
+			"e": FileHashes{"new.csv": "h11"},
+		},
+	}
+	runs := []RunMetadata{
+		RunMetadata{
+			WorkspaceOutputFiles: []string{"model/", "results/a.json"},
+			DatasetOutputFiles:   map[string][]string{"d": []string{"output.csv"}},
+		},
+	}
+
+	run, ok := CorrectionRun(before, after, runs)
+	if !ok {
+		t.Fatalf("Expected a correction run")
+	}
+	if run.Authority != RunAuthority_Correction {
+		t.Errorf("Expected authority %d, got %d", RunAuthority_Correction, run.Authority)
+	}
+	if len(run.RunID) != 36 {
+		t.Errorf("Expected a generated run ID, got %s", run.RunID)
+	}
+	testEqStr(t, run.Description, CorrectionDescription)
+	testEqStrs(t, run.WorkspaceOutputFiles, []string{"mylibrary.pyc", "old.txt"})
+	testEqStrs(t, run.DatasetOutputFiles["d"], []string{"extra.csv"})
+	testEqStrs(t, run.DatasetOutputFiles["e"], []string{"new.csv"})
+
+	runs[0].WorkspaceOutputFiles = append(runs[0].WorkspaceOutputFiles, "mylibrary.pyc", "old.txt")
+	runs = append(runs, RunMetadata{DatasetOutputFiles: map[string][]string{"d": []string{"extra.csv"}, "e": []string{"new.csv"}}})
+	if run, ok := CorrectionRun(before, after, runs); ok {
+		t.Errorf("Expected every change to be explained, got %#v", run)
+	}
+}
+
+func TestCorrectionRunRootOutput(t *testing.T) {
+	before := DotSnapshot{Workspace: FileHashes{"a.txt": "h1"}}
+	after := DotSnapshot{Workspace: FileHashes{"a.txt": "h2", "b/c.txt": "h3"}}
+	for _, root := range []string{".", "/", "./", "b/.."} {
+		runs := []RunMetadata{RunMetadata{WorkspaceOutputFiles: []string{root}}}
+		run, ok := CorrectionRun(before, after, runs)
+		if !ok {
+			t.Fatalf("Expected output %q not to explain every change", root)
+		}
+		testEqStrs(t, run.WorkspaceOutputFiles, []string{"a.txt", "b/c.txt"})
+	}
+}
@@ -0,0 +1,226 @@
+package metadata
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// TimeFormat is the layout of timestamps in the flat metadata format.
+const TimeFormat = "20060102T150405.999999999"
+
+// EncodeDatasetCommitMetadata converts a DatasetCommitMetadata struct into
+// a string->string map in the Dotscience Run Dataset Commit Metadata
+// format. It is the inverse of ParseDatasetCommitMetadata.
+func EncodeDatasetCommitMetadata(dcm DatasetCommitMetadata) map[string]string {
+	result := map[string]string{
+		"type":      "dotscience.run-output.v1",
+		"workspace": dcm.WorkspaceDotID,
+	}
+	for runId, filenames := range dcm.OutputFiles {
+		result["run."+runId+".dataset-output-files"] = encodeStringSlice(filenames)
+	}
+	return result
+}
+
+// EncodeCommitMetadata converts a CommitMetadata struct into a
+// string->string map in the Dotscience Run Commit Metadata format, suitable
+// for attaching to a dotmesh commit. It is the inverse of
+// ParseCommitMetadata: empty fields are left out of the map, as are
+// resource figures that are zero or negative, which ParseCommitMetadata
+// treats as unknown.
+func EncodeCommitMetadata(cm CommitMetadata) map[string]string {
+	result := map[string]string{
+		"type":    "dotscience.run.v1",
+		"success": strconv.FormatBool(cm.Success),
+	}
+
+	set := func(key, value string) {
+		if value != "" {
+			result[key] = value
+		}
+	}
+
+	set("author", cm.SubmitterID)
+	set("message", cm.Message)
+
+	for name, dsv := range cm.Inputs {
+		result["input-dataset."+name] = encodeDatasetVersion(dsv)
+	}
+	for name, dsv := range cm.Outputs {
+		result["output-dataset."+name] = encodeDatasetVersion(dsv)
+	}
+
+	set("workload.type", cm.WorkloadType)
+	set("workload.image", cm.WorkloadImage)
+	set("workload.image.hash", cm.WorkloadImageHash)
+	if len(cm.WorkloadCommand) > 0 {
+		result["workload.command"] = encodeStringSlice(cm.WorkloadCommand)
+	}
+	if len(cm.WorkloadEnvironment) > 0 {
+		b, _ := json.Marshal(cm.WorkloadEnvironment)
+		result["workload.environment"] = string(b)
+	}
+
+	set("exec.start", encodeTime(cm.ExecStart))
+	set("exec.end", encodeTime(cm.ExecEnd))
+	if len(cm.ExecLogs) > 0 {
+		result["exec.logs"] = encodeStringSlice(cm.ExecLogs)
+	}
+	if cm.ExecCPUSecondsUsed > 0 {
+		result["exec.cpu-seconds"] = strconv.FormatFloat(cm.ExecCPUSecondsUsed, 'f', -1, 64)
+	}
+	if cm.ExecPeakRAMBytes > 0 {
+		result["exec.ram"] = strconv.FormatInt(cm.ExecPeakRAMBytes, 10)
+	}
+
+	set("runner.name", cm.RunnerName)
+	set("runner.version", cm.RunnerVersion)
+	set("runner.platform", cm.RunnerPlatform)
+	set("runner.platform_version", cm.RunnerPlatformVersion)
+	if len(cm.RunnerCPUs) > 0 {
+		result["runner.cpu"] = encodeStringSlice(cm.RunnerCPUs)
+	}
+	if len(cm.RunnerGPUs) > 0 {
+		result["runner.gpu"] = encodeStringSlice(cm.RunnerGPUs)
+	}
+	if cm.RunnerRAMBytes > 0 {
+		result["runner.ram"] = strconv.FormatInt(cm.RunnerRAMBytes, 10)
+	}
+	switch cm.RunnerRAMECC {
+	case MaybeTrue:
+		result["runner.ram.ecc"] = "true"
+	case MaybeFalse:
+		result["runner.ram.ecc"] = "false"
+	}
+
+	runIds := make([]string, len(cm.Runs))
+	for idx, run := range cm.Runs {
+		runIds[idx] = run.RunID
+		encodeRun(result, run)
+	}
+	result["runs"] = encodeStringSlice(runIds)
+
+	return result
+}
+
+func encodeRun(result map[string]string, run RunMetadata) {
+	prefix := "run." + run.RunID + "."
+
+	set := func(key, value string) {
+		if value != "" {
+			result[prefix+key] = value
+		}
+	}
+
+	result[prefix+"authority"] = run.Authority.String()
+
+	set("description", run.Description)
+	set("workload-file", run.WorkloadFile)
+
+	// Success is implied by the absence of an error
+	if run.ErrorMessage != nil {
+		result[prefix+"error"] = *run.ErrorMessage
+	} else if !run.Success {
+		result[prefix+"error"] = ""
+	}
+
+	for k, v := range run.Labels {
+		result[prefix+"label."+k] = v
+	}
+	for k, v := range run.Summary {
+		result[prefix+"summary."+k] = v
+	}
+	for k, v := range run.Parameters {
+		result[prefix+"parameters."+k] = v
+	}
+
+	set("start", encodeTime(run.ExecStart))
+	set("end", encodeTime(run.ExecEnd))
+
+	if len(run.WorkspaceInputFiles) > 0 {
+		result[prefix+"input-files"] = encodeInputFiles(run.WorkspaceInputFiles)
+	}
+	if len(run.WorkspaceOutputFiles) > 0 {
+		result[prefix+"output-files"] = encodeStringSlice(run.WorkspaceOutputFiles)
+	}
+	for name, ifs := range run.DatasetInputFiles {
+		result[prefix+"dataset-input-files."+name] = encodeInputFiles(ifs)
+	}
+	for name, filenames := range run.DatasetOutputFiles {
+		result[prefix+"dataset-output-files."+name] = encodeStringSlice(filenames)
+	}
+
+	if hashes := inputHashes(run.WorkspaceInputFiles); hashes != "" {
+		result[prefix+"input-file-hashes"] = hashes
+	}
+	if len(run.WorkspaceOutputHashes) > 0 {
+		result[prefix+"output-file-hashes"] = outputHashes(run.WorkspaceOutputHashes)
+	}
+	for name, ifs := range run.DatasetInputFiles {
+		if hashes := inputHashes(ifs); hashes != "" {
+			result[prefix+"dataset-input-file-hashes."+name] = hashes
+		}
+	}
+	for name, ofs := range run.DatasetOutputHashes {
+		result[prefix+"dataset-output-file-hashes."+name] = outputHashes(ofs)
+	}
+
+	if run.CommentsCount != 0 {
+		result[prefix+"comments-count"] = strconv.FormatInt(run.CommentsCount, 10)
+	}
+}
+
+func encodeStringSlice(s []string) string {
+	if s == nil {
+		s = []string{}
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// encodeInputFiles renders input files as a JSON list of FILE@VERSION strings
+func encodeInputFiles(ifs []InputFile) string {
+	result := make([]string, len(ifs))
+	for idx, inf := range ifs {
+		result[idx] = inf.Filename + "@" + inf.Version
+	}
+	return encodeStringSlice(result)
+}
+
+// inputHashes renders the known hashes of input files as a JSON map from
+// filename to hash, or returns "" if none are known
+func inputHashes(ifs []InputFile) string {
+	hashes := map[string]string{}
+	for _, inf := range ifs {
+		if inf.Hash != "" {
+			hashes[inf.Filename] = inf.Hash
+		}
+	}
+	if len(hashes) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(hashes)
+	return string(b)
+}
+
+// outputHashes renders output file hashes as a JSON map from filename to hash
+func outputHashes(ofs []OutputFile) string {
+	hashes := map[string]string{}
+	for _, of := range ofs {
+		hashes[of.Filename] = of.Hash
+	}
+	b, _ := json.Marshal(hashes)
+	return string(b)
+}
+
+func encodeDatasetVersion(dsv DatasetVersion) string {
+	return string(dsv.ID) + "@" + dsv.Version
+}
+
+func encodeTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(TimeFormat)
+}
@@ -0,0 +1,167 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeCommitMetadataRoundTrip(t *testing.T) {
+	oops := "it broke"
+	cm := CommitMetadata{
+		SubmitterID:         "452342",
+		Success:             true,
+		WorkloadType:        "command",
+		WorkloadImage:       "busybox",
+		WorkloadCommand:     []string{"sh", "-c", "echo hi"},
+		WorkloadEnvironment: map[string]string{"DEBUG_MODE": "YES"},
+		Inputs:              map[string]DatasetVersion{"b": DatasetVersion{ID: "dot-b", Version: "b1"}},
+		Outputs:             map[string]DatasetVersion{"d": DatasetVersion{ID: "dot-d", Version: "d1"}},
+		ExecLogs:            []string{"agent-stdout.log"},
+		ExecStart:           time.Date(2018, 10, 4, 13, 6, 7, 101000000, time.UTC),
+		ExecEnd:             time.Date(2018, 10, 4, 13, 6, 10, 223000000, time.UTC),
+		ExecCPUSecondsUsed:  1.5,
+		RunnerName:          "bob",
+		RunnerCPUs:          []string{"cpu0", "cpu1"},
+		RunnerRAMBytes:      16579702784,
+		RunnerRAMECC:        MaybeFalse,
+		Runs: []RunMetadata{
+			{
+				RunID:                "r1",
+				Authority:            RunAuthority_Workload,
+				Success:              true,
+				WorkspaceInputFiles:  []InputFile{InputFile{Filename: "foo.csv", Version: "w0"}},
+				WorkspaceOutputFiles: []string{"log.txt"},
+				DatasetInputFiles:    map[string][]InputFile{"b": []InputFile{InputFile{Filename: "input.csv", Version: "b0"}}},
+				DatasetOutputFiles:   map[string][]string{"d": []string{"output.csv"}},
+				Summary:              map[string]string{"rms_error": "0.057"},
+				Parameters:           map[string]string{"smoothing": "1.0"},
+				Labels:               map[string]string{"team": "ml"},
+				ExecStart:            time.Date(2018, 10, 4, 13, 6, 7, 225000000, time.UTC),
+				ExecEnd:              time.Date(2018, 10, 4, 13, 6, 8, 225000000, time.UTC),
+				CommentsCount:        3,
+			},
+			{
+				RunID:        "r2",
+				Authority:    RunAuthority_Correction,
+				Description:  "File changes were detected that the run metadata did not explain",
+				ErrorMessage: &oops,
+			},
+		},
+	}
+
+	m := EncodeCommitMetadata(cm)
+	testEqStr(t, m["type"], "dotscience.run.v1")
+	testEqStr(t, m["runs"], "[\"r1\",\"r2\"]")
+	testEqStr(t, m["input-dataset.b"], "dot-b@b1")
+	testEqStr(t, m["run.r1.input-files"], "[\"foo.csv@w0\"]")
+	testEqStr(t, m["run.r1.start"], "20181004T130607.225")
+	testEqStr(t, m["runner.ram.ecc"], "false")
+	if _, ok := m["exec.ram"]; ok {
+		t.Errorf("Did not expect exec.ram for an unknown value")
+	}
+
+	rm := ParseCommitMetadata(m)
+	testEqStr(t, rm.SubmitterID, cm.SubmitterID)
+	testEqStrs(t, rm.WorkloadCommand, cm.WorkloadCommand)
+	testEqMap(t, rm.WorkloadEnvironment, cm.WorkloadEnvironment)
+	testEqDsvs(t, rm.Inputs, cm.Inputs)
+	testEqDsvs(t, rm.Outputs, cm.Outputs)
+	testEqTime(t, rm.ExecStart, cm.ExecStart)
+	testEqTime(t, rm.ExecEnd, cm.ExecEnd)
+	if rm.ExecCPUSecondsUsed != 1.5 || rm.RunnerRAMBytes != 16579702784 || rm.RunnerRAMECC != MaybeFalse {
+		t.Errorf("Runner/exec figures did not round trip: %#v", rm)
+	}
+
+	if len(rm.Runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(rm.Runs))
+	}
+	testEqIFs(t, rm.Runs[0].WorkspaceInputFiles, cm.Runs[0].WorkspaceInputFiles)
+	testEqIFs(t, rm.Runs[0].DatasetInputFiles["b"], cm.Runs[0].DatasetInputFiles["b"])
+	testEqStrs(t, rm.Runs[0].DatasetOutputFiles["d"], cm.Runs[0].DatasetOutputFiles["d"])
+	testEqMap(t, rm.Runs[0].Labels, cm.Runs[0].Labels)
+	testEqTime(t, rm.Runs[0].ExecEnd, cm.Runs[0].ExecEnd)
+	if !rm.Runs[0].Success || rm.Runs[0].CommentsCount != 3 {
+		t.Errorf("Run r1 did not round trip: %#v", rm.Runs[0])
+	}
+	if rm.Runs[1].Success || rm.Runs[1].ErrorMessage == nil || *rm.Runs[1].ErrorMessage != oops {
+		t.Errorf("Run r2 did not round trip: %#v", rm.Runs[1])
+	}
+	testEqStr(t, rm.Runs[1].Description, cm.Runs[1].Description)
+
+	testEqMap(t, EncodeCommitMetadata(rm), m)
+}
+
+func TestEncodeDatasetCommitMetadataRoundTrip(t *testing.T) {
+	dcm := ParseDatasetCommitMetadata(EncodeDatasetCommitMetadata(DatasetCommitMetadata{
+		WorkspaceDotID: "ID-of-dot-A",
+		OutputFiles:    map[string][]string{"r1": []string{"output.csv"}},
+	}))
+	testEqStr(t, dcm.WorkspaceDotID, "ID-of-dot-A")
+	testEqStrs(t, dcm.OutputFiles["r1"], []string{"output.csv"})
+}
+
+func TestEncodeFileHashesRoundTrip(t *testing.T) {
+	cm := CommitMetadata{
+		Success: true,
+		Runs: []RunMetadata{
+			{
+				RunID:   "r1",
+				Success: true,
+				WorkspaceInputFiles: []InputFile{
+					InputFile{Filename: "foo.csv", Version: "w0", Hash: "aaaa"},
+					InputFile{Filename: "bar.csv", Version: "w0"},
+				},
+				WorkspaceOutputFiles:  []string{"model.pkl", "log.txt"},
+				WorkspaceOutputHashes: []OutputFile{OutputFile{Filename: "model.pkl", Hash: "bbbb"}},
+				DatasetInputFiles:     map[string][]InputFile{"b": []InputFile{InputFile{Filename: "input.csv", Version: "b0", Hash: "cccc"}}},
+				DatasetOutputFiles:    map[string][]string{"d": []string{"output.csv"}},
+				DatasetOutputHashes:   map[string][]OutputFile{"d": []OutputFile{OutputFile{Filename: "output.csv", Hash: "dddd"}}},
+			},
+		},
+	}
+
+	m := EncodeCommitMetadata(cm)
+	testEqStr(t, m["run.r1.input-files"], "[\"foo.csv@w0\",\"bar.csv@w0\"]")
+	testEqStr(t, m["run.r1.input-file-hashes"], "{\"foo.csv\":\"aaaa\"}")
+	testEqStr(t, m["run.r1.output-file-hashes"], "{\"model.pkl\":\"bbbb\"}")
+	testEqStr(t, m["run.r1.dataset-input-file-hashes.b"], "{\"input.csv\":\"cccc\"}")
+	testEqStr(t, m["run.r1.dataset-output-file-hashes.d"], "{\"output.csv\":\"dddd\"}")
+
+	rm := ParseCommitMetadata(m)
+	run := rm.Runs[0]
+	testEqIFs(t, run.WorkspaceInputFiles, cm.Runs[0].WorkspaceInputFiles)
+	testEqIFs(t, run.DatasetInputFiles["b"], cm.Runs[0].DatasetInputFiles["b"])
+	testEqStrs(t, run.DatasetOutputFiles["d"], []string{"output.csv"})
+	if !reflect.DeepEqual(run.WorkspaceOutputHashes, cm.Runs[0].WorkspaceOutputHashes) {
+		t.Errorf("Wanted %#v, got %#v", cm.Runs[0].WorkspaceOutputHashes, run.WorkspaceOutputHashes)
+	}
+	if !reflect.DeepEqual(run.DatasetOutputHashes, cm.Runs[0].DatasetOutputHashes) {
+		t.Errorf("Wanted %#v, got %#v", cm.Runs[0].DatasetOutputHashes, run.DatasetOutputHashes)
+	}
+}
+
+func TestRunAuthorityString(t *testing.T) {
+	testEqStr(t, RunAuthority_Workload.String(), "workload")
+	testEqStr(t, RunAuthority_Derived.String(), "derived")
+	testEqStr(t, RunAuthority_Correction.String(), "correction")
+	if ParseRunAuthority("bogus") != RunAuthority_Correction {
+		t.Errorf("Wanted unknown authorities parsed as corrections")
+	}
+	for _, a := range []RunAuthority{RunAuthority_Workload, RunAuthority_Derived, RunAuthority_Correction} {
+		cm := ParseCommitMetadata(map[string]string{"runs": "[\"r1\"]", "run.r1.authority": a.String()})
+		if cm.Runs[0].Authority != a {
+			t.Errorf("Wanted %#v, got %#v", a, cm.Runs[0].Authority)
+		}
+	}
+}
+
+func TestResolveDataset(t *testing.T) {
+	dsvs := map[string]DatasetVersion{"b": DatasetVersion{ID: "dot-b", Version: "b1"}}
+	if dsv := ResolveDataset(dsvs, "b"); dsv != dsvs["b"] {
+		t.Errorf("Wanted %#v, got %#v", dsvs["b"], dsv)
+	}
+	if dsv := ResolveDataset(dsvs, "c"); dsv != (DatasetVersion{ID: "c"}) {
+		t.Errorf("Wanted %#v, got %#v", DatasetVersion{ID: "c"}, dsv)
+	}
+}
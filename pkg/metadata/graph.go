@@ -0,0 +1,217 @@
+package metadata
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrDependencyCycle = errors.New("runs depend on each other in a cycle")
+
+// type RunGraph is the dependency graph of the runs in one commit: run B
+// depends on run A when B read a file that A wrote, as found by
+// FindConflicts. Reads that may have overlapped the write are counted too,
+// as B may have seen A's output.
+type RunGraph struct {
+	runs  []RunMetadata
+	index map[string]int
+	deps  [][]int
+	users [][]int
+	order []int
+}
+
+// NewRunGraph builds the dependency graph of a commit's runs. It fails with
+// ErrDuplicateRun if two runs share a run ID, and with ErrDependencyCycle
+// if the recorded reads and writes are contradictory, such as two
+// concurrent runs each reading a file the other wrote.
+func NewRunGraph(cm CommitMetadata) (*RunGraph, error) {
+	g := &RunGraph{
+		runs:  cm.Runs,
+		index: map[string]int{},
+		deps:  make([][]int, len(cm.Runs)),
+		users: make([][]int, len(cm.Runs)),
+	}
+	for idx, run := range cm.Runs {
+		if _, ok := g.index[run.RunID]; ok {
+			return nil, ErrDuplicateRun
+		}
+		g.index[run.RunID] = idx
+	}
+
+	edges := map[[2]int]bool{}
+	for _, d := range FindConflicts(cm).Dependencies {
+		e := [2]int{g.index[d.WriterRunID], g.index[d.ReaderRunID]}
+		if edges[e] {
+			continue
+		}
+		edges[e] = true
+		g.deps[e[1]] = append(g.deps[e[1]], e[0])
+		g.users[e[0]] = append(g.users[e[0]], e[1])
+	}
+	for idx := range cm.Runs {
+		sort.Ints(g.deps[idx])
+		sort.Ints(g.users[idx])
+	}
+
+	// Kahn's algorithm, taking runs in commit order where there's a choice
+	pending := make([]int, len(cm.Runs))
+	for idx := range cm.Runs {
+		pending[idx] = len(g.deps[idx])
+	}
+	ready := []int{}
+	for idx := range cm.Runs {
+		if pending[idx] == 0 {
+			ready = append(ready, idx)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		next := ready[0]
+		ready = ready[1:]
+		g.order = append(g.order, next)
+		for _, u := range g.users[next] {
+			pending[u]--
+			if pending[u] == 0 {
+				ready = append(ready, u)
+			}
+		}
+	}
+	if len(g.order) != len(cm.Runs) {
+		return nil, ErrDependencyCycle
+	}
+	return g, nil
+}
+
+func (g *RunGraph) ids(idxs []int) []string {
+	result := make([]string, len(idxs))
+	for i, idx := range idxs {
+		result[i] = g.runs[idx].RunID
+	}
+	return result
+}
+
+// Dependencies returns the IDs of the runs that runID directly depends on,
+// in commit order.
+func (g *RunGraph) Dependencies(runID string) []string {
+	idx, ok := g.index[runID]
+	if !ok {
+		return []string{}
+	}
+	return g.ids(g.deps[idx])
+}
+
+// Dependents returns the IDs of the runs that directly depend on runID, in
+// commit order.
+func (g *RunGraph) Dependents(runID string) []string {
+	idx, ok := g.index[runID]
+	if !ok {
+		return []string{}
+	}
+	return g.ids(g.users[idx])
+}
+
+// TopologicalOrder returns the run IDs with every run after the runs it
+// depends on, otherwise keeping the commit's order.
+func (g *RunGraph) TopologicalOrder() []string {
+	return g.ids(g.order)
+}
+
+func runDuration(run RunMetadata) time.Duration {
+	if !hasTimes(run) || run.ExecEnd.Before(run.ExecStart) {
+		return 0
+	}
+	return run.ExecEnd.Sub(run.ExecStart)
+}
+
+// CriticalPath returns the chain of dependent runs with the greatest total
+// duration, and that duration: the least time the commit's runs could have
+// taken with unlimited parallelism. Runs with unknown times count as
+// taking no time.
+func (g *RunGraph) CriticalPath() ([]string, time.Duration) {
+	if len(g.runs) == 0 {
+		return []string{}, 0
+	}
+	finish := make([]time.Duration, len(g.runs))
+	via := make([]int, len(g.runs))
+	best := -1
+	for _, idx := range g.order {
+		via[idx] = -1
+		for _, d := range g.deps[idx] {
+			if via[idx] == -1 || finish[d] > finish[via[idx]] {
+				via[idx] = d
+			}
+		}
+		finish[idx] = runDuration(g.runs[idx])
+		if via[idx] != -1 {
+			finish[idx] += finish[via[idx]]
+		}
+		if best == -1 || finish[idx] > finish[best] {
+			best = idx
+		}
+	}
+
+	path := []int{}
+	for idx := best; idx != -1; idx = via[idx] {
+		path = append([]int{idx}, path...)
+	}
+	return g.ids(path), finish[best]
+}
+
+// Levels groups the runs into stages: each run is in the stage after the
+// latest stage of the runs it depends on. The runs within a stage don't
+// depend on each other, so could have executed in parallel.
+func (g *RunGraph) Levels() [][]string {
+	level := make([]int, len(g.runs))
+	levels := [][]int{}
+	for _, idx := range g.order {
+		for _, d := range g.deps[idx] {
+			if level[d]+1 > level[idx] {
+				level[idx] = level[d] + 1
+			}
+		}
+		for len(levels) <= level[idx] {
+			levels = append(levels, []int{})
+		}
+		levels[level[idx]] = append(levels[level[idx]], idx)
+	}
+
+	result := make([][]string, len(levels))
+	for i, l := range levels {
+		sort.Ints(l)
+		result[i] = g.ids(l)
+	}
+	return result
+}
+
+// Independent reports whether neither of two runs depends, directly or
+// indirectly, on the other, so they could have executed in parallel.
+func (g *RunGraph) Independent(a, b string) bool {
+	i, ok := g.index[a]
+	if !ok {
+		return false
+	}
+	j, ok := g.index[b]
+	if !ok || i == j {
+		return false
+	}
+	return !g.reaches(i, j) && !g.reaches(j, i)
+}
+
+func (g *RunGraph) reaches(from, to int) bool {
+	seen := map[int]bool{from: true}
+	stack := []int{from}
+	for len(stack) > 0 {
+		idx := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, u := range g.users[idx] {
+			if u == to {
+				return true
+			}
+			if !seen[u] {
+				seen[u] = true
+				stack = append(stack, u)
+			}
+		}
+	}
+	return false
+}
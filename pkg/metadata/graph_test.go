@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunGraph(t *testing.T) {
+	// fetch -> clean -> train -> report
+	//       -> stats ----------->
+	fetch := testRunAt("fetch", 0, 5)
+	fetch.WorkspaceOutputFiles = []string{"raw.csv"}
+
+	clean := testRunAt("clean", 5, 10)
+	clean.WorkspaceInputFiles = []InputFile{InputFile{Filename: "raw.csv"}}
+	clean.DatasetOutputFiles = map[string][]string{"d": []string{"clean.csv"}}
+
+	stats := testRunAt("stats", 5, 7)
+	stats.WorkspaceInputFiles = []InputFile{InputFile{Filename: "raw.csv"}}
+	stats.WorkspaceOutputFiles = []string{"stats.json"}
+
+	train := testRunAt("train", 10, 30)
+	train.DatasetInputFiles = map[string][]InputFile{"d": []InputFile{InputFile{Filename: "clean.csv"}}}
+	train.WorkspaceOutputFiles = []string{"model.pkl"}
+
+	report := testRunAt("report", 30, 31)
+	report.WorkspaceInputFiles = []InputFile{InputFile{Filename: "model.pkl"}, InputFile{Filename: "stats.json"}}
+
+	g, err := NewRunGraph(CommitMetadata{Runs: []RunMetadata{report, train, stats, clean, fetch}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEqStrs(t, g.TopologicalOrder(), []string{"fetch", "stats", "clean", "train", "report"})
+	testEqStrs(t, g.Dependencies("report"), []string{"train", "stats"})
+	testEqStrs(t, g.Dependents("fetch"), []string{"stats", "clean"})
+
+	path, length := g.CriticalPath()
+	testEqStrs(t, path, []string{"fetch", "clean", "train", "report"})
+	if length != 31*time.Minute {
+		t.Errorf("Wanted %s, got %s", 31*time.Minute, length)
+	}
+
+	levels := g.Levels()
+	expected := [][]string{{"fetch"}, {"stats", "clean"}, {"train"}, {"report"}}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Errorf("Wanted %#v, got %#v", expected, levels)
+	}
+
+	if !g.Independent("stats", "train") {
+		t.Errorf("Expected stats and train to be independent")
+	}
+	if g.Independent("fetch", "report") {
+		t.Errorf("Did not expect fetch and report to be independent")
+	}
+}
+
+func TestRunGraphCycle(t *testing.T) {
+	a := RunMetadata{RunID: "a", WorkspaceOutputFiles: []string{"x"}, WorkspaceInputFiles: []InputFile{InputFile{Filename: "y"}}}
+	b := RunMetadata{RunID: "b", WorkspaceOutputFiles: []string{"y"}, WorkspaceInputFiles: []InputFile{InputFile{Filename: "x"}}}
+	a.ExecStart, a.ExecEnd = time.Unix(10, 0), time.Unix(10, 0)
+	b.ExecStart, b.ExecEnd = time.Unix(10, 0), time.Unix(10, 0)
+	if _, err := NewRunGraph(CommitMetadata{Runs: []RunMetadata{a, b}}); err != ErrDependencyCycle {
+		t.Errorf("Expected ErrDependencyCycle, got %v", err)
+	}
+}
+
+func TestRunGraphConcurrent(t *testing.T) {
+	// read may have seen write's output, or not
+	write := testRunAt("write", 0, 10)
+	write.WorkspaceOutputFiles = []string{"x"}
+	read := testRunAt("read", 5, 15)
+	read.WorkspaceInputFiles = []InputFile{InputFile{Filename: "x"}}
+	other := testRunAt("other", 5, 15)
+
+	g, err := NewRunGraph(CommitMetadata{Runs: []RunMetadata{read, write, other}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEqStrs(t, g.Dependencies("read"), []string{"write"})
+	testEqStrs(t, g.TopologicalOrder(), []string{"write", "read", "other"})
+	if g.Independent("write", "read") {
+		t.Errorf("Did not expect overlapping runs sharing a file to be independent")
+	}
+	if !g.Independent("read", "other") {
+		t.Errorf("Expected runs sharing no files to be independent")
+	}
+}
+
+func TestRunGraphDuplicateRunID(t *testing.T) {
+	a := testRunAt("a", 0, 5)
+	b := testRunAt("a", 5, 10)
+	if _, err := NewRunGraph(CommitMetadata{Runs: []RunMetadata{a, b}}); err != ErrDuplicateRun {
+		t.Errorf("Expected ErrDuplicateRun, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"sort"
+)
+
+// type WorkspaceCommit is a workspace commit's ID and its parsed metadata.
+type WorkspaceCommit struct {
+	CommitID string
+	Metadata CommitMetadata
+}
+
+// type FileVersion records a run writing a workspace file.
+type FileVersion struct {
+	Filename string
+	CommitID string
+	// Run is the run that wrote the file; its input files are what the
+	// file was made from.
+	Run RunMetadata
+}
+
+// type FileHistory is the history of every workspace file written by the
+// runs in a sequence of commits.
+//
+// Within a commit, runs are taken to have written their files in order of
+// their end times, and runs with unknown end times, such as correction
+// runs, last of all. The metadata doesn't say when a file was deleted, so
+// a file stays in the history from when it was first written.
+type FileHistory struct {
+	commits  []string
+	index    map[string]int
+	versions map[string][]FileVersion
+}
+
+// NewFileHistory replays the runs of a sequence of workspace commits,
+// oldest first.
+func NewFileHistory(commits []WorkspaceCommit) *FileHistory {
+	h := &FileHistory{
+		index:    map[string]int{},
+		versions: map[string][]FileVersion{},
+	}
+	for idx, c := range commits {
+		h.commits = append(h.commits, c.CommitID)
+		h.index[c.CommitID] = idx
+
+		runs := append([]RunMetadata{}, c.Metadata.Runs...)
+		sort.SliceStable(runs, func(i, j int) bool {
+			ti, tj := runs[i].ExecEnd, runs[j].ExecEnd
+			if ti.IsZero() || tj.IsZero() {
+				return !ti.IsZero() && tj.IsZero()
+			}
+			return ti.Before(tj)
+		})
+		for _, run := range runs {
+			if run.CommitID == "" {
+				run.CommitID = c.CommitID
+			}
+			for _, f := range run.WorkspaceOutputFiles {
+				filename := cleanFilename(f)
+				h.versions[filename] = append(h.versions[filename], FileVersion{
+					Filename: filename,
+					CommitID: c.CommitID,
+					Run:      run,
+				})
+			}
+		}
+	}
+	return h
+}
+
+// Versions returns every write of a file, oldest first.
+func (h *FileHistory) Versions(filename string) []FileVersion {
+	return append([]FileVersion{}, h.versions[cleanFilename(filename)]...)
+}
+
+// Blame returns the last write of a file at or before a commit. The second
+// result is false if the commit is unknown or the file had not been
+// written by then.
+func (h *FileHistory) Blame(commitID, filename string) (FileVersion, bool) {
+	idx, ok := h.index[commitID]
+	if !ok {
+		return FileVersion{}, false
+	}
+	versions := h.versions[cleanFilename(filename)]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if h.index[versions[i].CommitID] <= idx {
+			return versions[i], true
+		}
+	}
+	return FileVersion{}, false
+}
+
+// At returns the last write of every file written at or before a commit,
+// keyed by filename.
+func (h *FileHistory) At(commitID string) map[string]FileVersion {
+	result := map[string]FileVersion{}
+	for filename := range h.versions {
+		v, ok := h.Blame(commitID, filename)
+		if ok {
+			result[filename] = v
+		}
+	}
+	return result
+}
+
+// Files returns the sorted names of the files written at or before a
+// commit.
+func (h *FileHistory) Files(commitID string) []string {
+	result := []string{}
+	for filename := range h.At(commitID) {
+		result = append(result, filename)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Sources returns the versions of the workspace files that the run behind
+// v read, as far as the history knows them, in the order the run lists
+// its inputs. Each input is looked up at the commit recorded as its
+// version.
+func (h *FileHistory) Sources(v FileVersion) []FileVersion {
+	result := []FileVersion{}
+	for _, inf := range v.Run.WorkspaceInputFiles {
+		source, ok := h.Blame(inf.Version, inf.Filename)
+		if ok {
+			result = append(result, source)
+		}
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+package metadata
+
+import (
+	"testing"
+)
+
+func TestFileHistory(t *testing.T) {
+	fetch := testRunAt("fetch", 0, 5)
+	fetch.WorkspaceOutputFiles = []string{"raw.csv", "log.txt"}
+
+	train := testRunAt("train", 10, 20)
+	train.WorkspaceInputFiles = []InputFile{InputFile{Filename: "raw.csv", Version: "c1"}}
+	train.WorkspaceOutputFiles = []string{"model.pkl", "log.txt"}
+
+	correction := RunMetadata{RunID: "fix", Authority: RunAuthority_Correction, WorkspaceOutputFiles: []string{"model.pkl"}}
+	tidy := testRunAt("tidy", 20, 21)
+	tidy.WorkspaceOutputFiles = []string{"model.pkl"}
+
+	h := NewFileHistory([]WorkspaceCommit{
+		WorkspaceCommit{CommitID: "c1", Metadata: CommitMetadata{Runs: []RunMetadata{fetch}}},
+		WorkspaceCommit{CommitID: "c2", Metadata: CommitMetadata{Runs: []RunMetadata{correction, tidy, train}}},
+	})
+
+	testEqStrs(t, h.Files("c1"), []string{"log.txt", "raw.csv"})
+	testEqStrs(t, h.Files("c2"), []string{"log.txt", "model.pkl", "raw.csv"})
+
+	v, ok := h.Blame("c1", "log.txt")
+	if !ok || v.Run.RunID != "fetch" {
+		t.Errorf("Expected fetch to have written log.txt at c1, got %#v", v)
+	}
+	v, ok = h.Blame("c2", "./log.txt")
+	if !ok || v.Run.RunID != "train" || v.CommitID != "c2" {
+		t.Errorf("Expected train to have written log.txt at c2, got %#v", v)
+	}
+	if _, ok := h.Blame("c1", "model.pkl"); ok {
+		t.Errorf("Did not expect model.pkl to exist at c1")
+	}
+
+	// Correction runs come after timed runs
+	var writers []string
+	for _, v := range h.Versions("model.pkl") {
+		writers = append(writers, v.Run.RunID)
+	}
+	testEqStrs(t, writers, []string{"train", "tidy", "fix"})
+
+	v, _ = h.Blame("c2", "log.txt")
+	sources := h.Sources(v)
+	if len(sources) != 1 || sources[0].Run.RunID != "fetch" || sources[0].Filename != "raw.csv" {
+		t.Errorf("Unexpected sources %#v", sources)
+	}
+}
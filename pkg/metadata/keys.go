@@ -0,0 +1,49 @@
+package metadata
+
+import (
+	"sort"
+)
+
+// SortedKeys returns the keys of a map of strings, such as run parameters,
+// summary or labels, in order.
+func SortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SortedDatasetNames returns the names of a commit's input or output
+// datasets, in order.
+func SortedDatasetNames(m map[string]DatasetVersion) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SortedInputDatasets returns the names of the datasets a run read files
+// from, in order.
+func SortedInputDatasets(m map[string][]InputFile) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SortedOutputDatasets returns the names of the datasets a run wrote files
+// to, in order.
+func SortedOutputDatasets(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
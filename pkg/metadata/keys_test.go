@@ -0,0 +1,13 @@
+package metadata
+
+import (
+	"testing"
+)
+
+func TestSortedKeys(t *testing.T) {
+	testEqStrs(t, SortedKeys(map[string]string{"c": "1", "a": "2", "b": "3"}), []string{"a", "b", "c"})
+	testEqStrs(t, SortedDatasetNames(map[string]DatasetVersion{"out": {}, "in": {}}), []string{"in", "out"})
+	testEqStrs(t, SortedInputDatasets(map[string][]InputFile{"y": nil, "x": nil}), []string{"x", "y"})
+	testEqStrs(t, SortedOutputDatasets(map[string][]string{"q": nil, "p": nil}), []string{"p", "q"})
+	testEqStrs(t, SortedKeys(nil), []string{})
+}
@@ -0,0 +1,178 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// type RunSpec is what determines the result of a run: the workload and
+// everything it was given. Runs with equal specs are expected to produce
+// the same outputs.
+type RunSpec struct {
+	// WorkloadImageHash identifies the image by content. If it is empty,
+	// WorkloadImage is used instead, which is only as reliable as the
+	// image's tag.
+	WorkloadImageHash   string
+	WorkloadImage       string
+	WorkloadCommand     []string
+	WorkloadEnvironment map[string]string
+	Parameters          map[string]string
+	WorkspaceInputFiles []InputFile
+	// DatasetInputFiles is keyed by the dot ID of each dataset, rather than
+	// the name it is attached under, which means nothing to other
+	// workspaces.
+	DatasetInputFiles map[string][]InputFile
+}
+
+// RunSpecOf returns the spec of a run in a commit.
+func RunSpecOf(cm CommitMetadata, run RunMetadata) RunSpec {
+	var datasets map[string][]InputFile
+	if run.DatasetInputFiles != nil {
+		datasets = map[string][]InputFile{}
+		for name, ifs := range run.DatasetInputFiles {
+			id := string(ResolveDataset(cm.Inputs, name).ID)
+			datasets[id] = append(datasets[id], ifs...)
+		}
+	}
+	return RunSpec{
+		WorkloadImageHash:   cm.WorkloadImageHash,
+		WorkloadImage:       cm.WorkloadImage,
+		WorkloadCommand:     cm.WorkloadCommand,
+		WorkloadEnvironment: cm.WorkloadEnvironment,
+		Parameters:          run.Parameters,
+		WorkspaceInputFiles: run.WorkspaceInputFiles,
+		DatasetInputFiles:   datasets,
+	}
+}
+
+// Memoisable reports whether every input file of a spec has a version. A
+// run that read an unversioned file can't be told apart from one that read
+// different contents, so it is never memoised.
+func (spec RunSpec) Memoisable() bool {
+	for _, inf := range spec.WorkspaceInputFiles {
+		if inf.Version == "" {
+			return false
+		}
+	}
+	for _, ifs := range spec.DatasetInputFiles {
+		for _, inf := range ifs {
+			if inf.Version == "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Fingerprint returns the hex-encoded SHA-256 of a canonical form of a run
+// spec. The order of input files, and content hashes recorded on them, make
+// no difference.
+func Fingerprint(spec RunSpec) string {
+	image := "hash:" + spec.WorkloadImageHash
+	if spec.WorkloadImageHash == "" {
+		image = "image:" + spec.WorkloadImage
+	}
+
+	inputs := func(ifs []InputFile) []string {
+		result := []string{}
+		for _, inf := range ifs {
+			result = append(result, cleanFilename(inf.Filename)+"@"+inf.Version)
+		}
+		sort.Strings(result)
+		return result
+	}
+	datasets := map[string][]string{}
+	for name, ifs := range spec.DatasetInputFiles {
+		if len(ifs) > 0 {
+			datasets[name] = inputs(ifs)
+		}
+	}
+	command := spec.WorkloadCommand
+	if command == nil {
+		command = []string{}
+	}
+	nonNil := func(m map[string]string) map[string]string {
+		if m == nil {
+			return map[string]string{}
+		}
+		return m
+	}
+
+	// encoding/json writes map keys in sorted order
+	b, _ := json.Marshal([]interface{}{
+		"dotscience.run-fingerprint.v1",
+		image,
+		command,
+		nonNil(spec.WorkloadEnvironment),
+		nonNil(spec.Parameters),
+		inputs(spec.WorkspaceInputFiles),
+		datasets,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// RunFingerprint returns the fingerprint of a run in a commit.
+func RunFingerprint(cm CommitMetadata, run RunMetadata) string {
+	return Fingerprint(RunSpecOf(cm, run))
+}
+
+// type MemoEntry is a previous run found by a MemoIndex.
+type MemoEntry struct {
+	CommitID string
+	Run      RunMetadata
+	// Outputs are the versions of the output datasets made by the commit.
+	Outputs map[string]DatasetVersion
+}
+
+// type MemoIndex finds previous runs by fingerprint, so that a run need not
+// be repeated. Only successful runs declared by a workload, or derived from
+// one, whose specs are Memoisable, are indexed.
+type MemoIndex struct {
+	entries map[string][]MemoEntry
+}
+
+// NewMemoIndex returns an index of the runs in commits.
+func NewMemoIndex(commits []WorkspaceCommit) *MemoIndex {
+	idx := &MemoIndex{entries: map[string][]MemoEntry{}}
+	for _, c := range commits {
+		idx.Add(c)
+	}
+	return idx
+}
+
+// Add indexes the runs in a commit.
+func (idx *MemoIndex) Add(c WorkspaceCommit) {
+	for _, run := range c.Metadata.Runs {
+		if !run.Success || run.Authority == RunAuthority_Correction {
+			continue
+		}
+		spec := RunSpecOf(c.Metadata, run)
+		if !spec.Memoisable() {
+			continue
+		}
+		fp := Fingerprint(spec)
+		idx.entries[fp] = append(idx.entries[fp], MemoEntry{
+			CommitID: c.CommitID,
+			Run:      run,
+			Outputs:  c.Metadata.Outputs,
+		})
+	}
+}
+
+// Lookup returns the indexed runs with the same spec, in the order they
+// were added. There are none if the spec isn't Memoisable.
+func (idx *MemoIndex) Lookup(spec RunSpec) []MemoEntry {
+	if !spec.Memoisable() {
+		return []MemoEntry{}
+	}
+	return idx.LookupFingerprint(Fingerprint(spec))
+}
+
+// LookupFingerprint returns the indexed runs with a fingerprint, in the
+// order they were added.
+func (idx *MemoIndex) LookupFingerprint(fingerprint string) []MemoEntry {
+	return append([]MemoEntry{}, idx.entries[fingerprint]...)
+}
@@ -0,0 +1,122 @@
+package metadata
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	spec := RunSpec{
+		WorkloadImageHash:   "busybox@sha256:2a03",
+		WorkloadImage:       "busybox",
+		WorkloadCommand:     []string{"python", "train.py"},
+		WorkloadEnvironment: map[string]string{"DEBUG_MODE": "YES"},
+		Parameters:          map[string]string{"smoothing": "1.0"},
+		WorkspaceInputFiles: []InputFile{
+			InputFile{Filename: "a.csv", Version: "w0"},
+			InputFile{Filename: "b.csv", Version: "w0"},
+		},
+		DatasetInputFiles: map[string][]InputFile{"d": []InputFile{InputFile{Filename: "input.csv", Version: "d0"}}},
+	}
+	fp := Fingerprint(spec)
+	if len(fp) != 64 {
+		t.Errorf("Expected a SHA-256, got %s", fp)
+	}
+
+	same := spec
+	same.WorkloadImage = "busybox:latest"
+	same.WorkspaceInputFiles = []InputFile{
+		InputFile{Filename: "./b.csv", Version: "w0", Hash: "ffff"},
+		InputFile{Filename: "a.csv", Version: "w0"},
+	}
+	testEqStr(t, Fingerprint(same), fp)
+
+	for name, change := range map[string]func(s *RunSpec){
+		"image":       func(s *RunSpec) { s.WorkloadImageHash = "busybox@sha256:ffff" },
+		"command":     func(s *RunSpec) { s.WorkloadCommand = []string{"python", "train.py", "--fast"} },
+		"environment": func(s *RunSpec) { s.WorkloadEnvironment = map[string]string{} },
+		"parameters":  func(s *RunSpec) { s.Parameters = map[string]string{"smoothing": "2.0"} },
+		"inputs":      func(s *RunSpec) { s.WorkspaceInputFiles = []InputFile{InputFile{Filename: "a.csv", Version: "w1"}} },
+		"datasets":    func(s *RunSpec) { s.DatasetInputFiles = nil },
+	} {
+		changed := spec
+		change(&changed)
+		if Fingerprint(changed) == fp {
+			t.Errorf("Expected changing the %s to change the fingerprint", name)
+		}
+	}
+}
+
+func TestMemoIndex(t *testing.T) {
+	failed := "oops"
+	cm := CommitMetadata{
+		WorkloadImageHash: "busybox@sha256:2a03",
+		WorkloadCommand:   []string{"python", "train.py"},
+		Outputs:           map[string]DatasetVersion{"d": DatasetVersion{ID: "dot-d", Version: "d1"}},
+		Runs: []RunMetadata{
+			RunMetadata{
+				RunID:                "r1",
+				Success:              true,
+				Parameters:           map[string]string{"smoothing": "1.0"},
+				WorkspaceInputFiles:  []InputFile{InputFile{Filename: "a.csv", Version: "w0"}},
+				WorkspaceOutputFiles: []string{"model.pkl"},
+			},
+			RunMetadata{
+				RunID:        "r2",
+				ErrorMessage: &failed,
+				Parameters:   map[string]string{"smoothing": "2.0"},
+			},
+		},
+	}
+	idx := NewMemoIndex([]WorkspaceCommit{WorkspaceCommit{CommitID: "c1", Metadata: cm}})
+
+	found := idx.Lookup(RunSpec{
+		WorkloadImageHash:   "busybox@sha256:2a03",
+		WorkloadCommand:     []string{"python", "train.py"},
+		Parameters:          map[string]string{"smoothing": "1.0"},
+		WorkspaceInputFiles: []InputFile{InputFile{Filename: "a.csv", Version: "w0"}},
+	})
+	if len(found) != 1 {
+		t.Fatalf("Expected 1 match, got %#v", found)
+	}
+	testEqStr(t, found[0].CommitID, "c1")
+	testEqStr(t, found[0].Run.RunID, "r1")
+	testEqStrs(t, found[0].Run.WorkspaceOutputFiles, []string{"model.pkl"})
+	testEqDsvs(t, found[0].Outputs, cm.Outputs)
+
+	if found := idx.LookupFingerprint(RunFingerprint(cm, cm.Runs[1])); len(found) != 0 {
+		t.Errorf("Did not expect failed runs to be indexed, got %#v", found)
+	}
+}
+
+func TestMemoIndexUnversioned(t *testing.T) {
+	cm := CommitMetadata{
+		WorkloadImageHash: "busybox@sha256:2a03",
+		Runs: []RunMetadata{
+			RunMetadata{
+				RunID:               "r1",
+				Success:             true,
+				WorkspaceInputFiles: []InputFile{InputFile{Filename: "a.csv"}},
+			},
+		},
+	}
+	idx := NewMemoIndex([]WorkspaceCommit{WorkspaceCommit{CommitID: "c1", Metadata: cm}})
+	if found := idx.LookupFingerprint(RunFingerprint(cm, cm.Runs[0])); len(found) != 0 {
+		t.Errorf("Did not expect a run with unversioned inputs to be indexed, got %#v", found)
+	}
+	if found := idx.Lookup(RunSpecOf(cm, cm.Runs[0])); len(found) != 0 {
+		t.Errorf("Did not expect a spec with unversioned inputs to match, got %#v", found)
+	}
+}
+
+func TestRunSpecOfDatasetIDs(t *testing.T) {
+	run := RunMetadata{DatasetInputFiles: map[string][]InputFile{"train": []InputFile{InputFile{Filename: "input.csv", Version: "d0"}}}}
+	a := CommitMetadata{Inputs: map[string]DatasetVersion{"train": DatasetVersion{ID: "dot-d", Version: "d0"}}}
+	b := CommitMetadata{Inputs: map[string]DatasetVersion{"data": DatasetVersion{ID: "dot-d", Version: "d0"}}}
+	renamed := RunMetadata{DatasetInputFiles: map[string][]InputFile{"data": run.DatasetInputFiles["train"]}}
+	testEqStr(t, RunFingerprint(b, renamed), RunFingerprint(a, run))
+
+	c := CommitMetadata{Inputs: map[string]DatasetVersion{"train": DatasetVersion{ID: "dot-e", Version: "d0"}}}
+	if RunFingerprint(c, run) == RunFingerprint(a, run) {
+		t.Errorf("Expected a different dataset under the same name to change the fingerprint")
+	}
+}
@@ -3,6 +3,7 @@ package metadata
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -88,17 +89,7 @@ func ParseCommitMetadata(input map[string]string) CommitMetadata {
 	}
 
 	getRunAuthority := func(key string) RunAuthority {
-		v := get(key, "")
-		if v == "workload" {
-			return RunAuthority_Workload
-		} else if v == "derived" {
-			return RunAuthority_Derived
-		} else if v == "correction" {
-			return RunAuthority_Correction
-		} else {
-			// Unknown, so call it a correction, it'll attract attention.
-			return RunAuthority_Correction
-		}
+		return ParseRunAuthority(get(key, ""))
 	}
 
 	getInt64 := func(key string, def int64) int64 {
@@ -124,7 +115,7 @@ func ParseCommitMetadata(input map[string]string) CommitMetadata {
 	getTime := func(key string) time.Time {
 		v := get(key, "")
 		if v != "" {
-			t, err := time.Parse("20060102T150405.999999999", v)
+			t, err := time.Parse(TimeFormat, v)
 			if err != nil {
 				return time.Time{}
 			} else {
@@ -245,6 +236,45 @@ func ParseCommitMetadata(input map[string]string) CommitMetadata {
 		return parsedResult
 	}
 
+	// key="{...json map from filename to hash...}"
+	// -> Hash set on the InputFiles with those filenames
+	addInputHashes := func(key string, ifs []InputFile) []InputFile {
+		hashes := getDirectStringMap(key)
+		for idx, inf := range ifs {
+			ifs[idx].Hash = hashes[inf.Filename]
+		}
+		return ifs
+	}
+
+	// key="{...json map from filename to hash...}"
+	// -> []OutputFile{FILE, HASH}, sorted by filename
+	getOFs := func(key string) []OutputFile {
+		hashes := getDirectStringMap(key)
+		filenames := []string{}
+		for filename := range hashes {
+			filenames = append(filenames, filename)
+		}
+		sort.Strings(filenames)
+		result := make([]OutputFile, len(filenames))
+		for idx, filename := range filenames {
+			result[idx] = OutputFile{Filename: filename, Hash: hashes[filename]}
+		}
+		return result
+	}
+
+	// wantedKey<REF>="{...json map from filename to hash...}"
+	// -> map with entry of "REF": []OutputFile{FILE, HASH}
+	getOFMap := func(wantedKey string) map[string][]OutputFile {
+		parsedResult := map[string][]OutputFile{}
+		for key := range input {
+			if strings.HasPrefix(key, wantedKey) {
+				name := key[len(wantedKey):]
+				parsedResult[name] = getOFs(key)
+			}
+		}
+		return parsedResult
+	}
+
 	getRuns := func(runIds []string) []RunMetadata {
 		runs := make([]RunMetadata, len(runIds))
 		for idx, runId := range runIds {
@@ -266,6 +296,13 @@ func ParseCommitMetadata(input map[string]string) CommitMetadata {
 				DatasetInputFiles:    getIFMap(prefix + "dataset-input-files."),
 				DatasetOutputFiles:   getStringSliceMap(prefix + "dataset-output-files."),
 				CommentsCount:        getInt64(prefix+"comments-count", 0),
+
+				WorkspaceOutputHashes: getOFs(prefix + "output-file-hashes"),
+				DatasetOutputHashes:   getOFMap(prefix + "dataset-output-file-hashes."),
+			}
+			addInputHashes(prefix+"input-file-hashes", runs[idx].WorkspaceInputFiles)
+			for name, ifs := range runs[idx].DatasetInputFiles {
+				addInputHashes(prefix+"dataset-input-file-hashes."+name, ifs)
 			}
 		}
 		return runs
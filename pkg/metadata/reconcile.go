@@ -0,0 +1,192 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// type DiscrepancyKind says how a workspace commit and the run-output
+// commit of one of its output datasets disagree.
+type DiscrepancyKind int
+
+const (
+	// The workspace commit has an output dataset with no run-output commit
+	Discrepancy_MissingDatasetCommit DiscrepancyKind = iota
+	// A run-output commit was given for a dataset the workspace commit
+	// doesn't list as an output
+	Discrepancy_UnexpectedDatasetCommit
+	// The run-output commit names a different workspace dot
+	Discrepancy_WrongWorkspace
+	// A run wrote to the dataset according to the workspace commit, but
+	// the run-output commit doesn't mention it
+	Discrepancy_RunMissingFromDataset
+	// The run-output commit lists a run that the workspace commit doesn't
+	// record writing to the dataset
+	Discrepancy_RunMissingFromWorkspace
+	// Both commits list the run, but with different files
+	Discrepancy_FilesDiffer
+)
+
+func (k DiscrepancyKind) String() string {
+	switch k {
+	case Discrepancy_MissingDatasetCommit:
+		return "missing dataset commit"
+	case Discrepancy_UnexpectedDatasetCommit:
+		return "unexpected dataset commit"
+	case Discrepancy_WrongWorkspace:
+		return "wrong workspace"
+	case Discrepancy_RunMissingFromDataset:
+		return "run missing from dataset commit"
+	case Discrepancy_RunMissingFromWorkspace:
+		return "run missing from workspace commit"
+	case Discrepancy_FilesDiffer:
+		return "files differ"
+	default:
+		return fmt.Sprintf("discrepancy %d", int(k))
+	}
+}
+
+// type Discrepancy is a disagreement found by ReconcileDatasetCommits.
+type Discrepancy struct {
+	Kind    DiscrepancyKind
+	Dataset string
+	// RunID is empty for discrepancies about the whole dataset commit.
+	RunID string
+
+	// For Discrepancy_WrongWorkspace, the workspace named by the dataset
+	// commit.
+	WorkspaceDotID string
+
+	// For Discrepancy_FilesDiffer, the files listed only by the workspace
+	// commit, and only by the dataset commit, each sorted.
+	OnlyInWorkspace []string
+	OnlyInDataset   []string
+}
+
+func (d Discrepancy) String() string {
+	s := fmt.Sprintf("dataset %s", d.Dataset)
+	if d.RunID != "" {
+		s += fmt.Sprintf(", run %s", d.RunID)
+	}
+	s += ": " + d.Kind.String()
+	switch d.Kind {
+	case Discrepancy_WrongWorkspace:
+		s += fmt.Sprintf(" %q", d.WorkspaceDotID)
+	case Discrepancy_FilesDiffer:
+		s += fmt.Sprintf(" (only in workspace commit: [%s], only in dataset commit: [%s])",
+			strings.Join(d.OnlyInWorkspace, ", "), strings.Join(d.OnlyInDataset, ", "))
+	}
+	return s
+}
+
+// ReconcileDatasetCommits checks a workspace commit, made in the workspace
+// dot workspaceDotID, against the run-output commits of its output
+// datasets, given by dataset name. It returns every discrepancy found,
+// sorted by dataset then run ID; none means the two sides agree. File
+// lists are compared without regard to order or repetition.
+func ReconcileDatasetCommits(workspaceDotID string, cm CommitMetadata, datasets map[string]DatasetCommitMetadata) []Discrepancy {
+	result := []Discrepancy{}
+
+	// What the workspace commit says each run wrote to each dataset
+	written := map[string]map[string][]string{}
+	for _, run := range cm.Runs {
+		for name, files := range run.DatasetOutputFiles {
+			if len(files) == 0 {
+				continue
+			}
+			if written[name] == nil {
+				written[name] = map[string][]string{}
+			}
+			written[name][run.RunID] = append(written[name][run.RunID], files...)
+		}
+	}
+
+	names := map[string]bool{}
+	for name := range cm.Outputs {
+		names[name] = true
+	}
+	for name := range datasets {
+		names[name] = true
+	}
+	for name := range written {
+		names[name] = true
+	}
+
+	for name := range names {
+		dcm, ok := datasets[name]
+		if !ok {
+			result = append(result, Discrepancy{Kind: Discrepancy_MissingDatasetCommit, Dataset: name})
+			continue
+		}
+		if _, ok := cm.Outputs[name]; !ok {
+			result = append(result, Discrepancy{Kind: Discrepancy_UnexpectedDatasetCommit, Dataset: name})
+		}
+		if dcm.WorkspaceDotID != workspaceDotID {
+			result = append(result, Discrepancy{Kind: Discrepancy_WrongWorkspace, Dataset: name, WorkspaceDotID: dcm.WorkspaceDotID})
+		}
+
+		for runId, files := range written[name] {
+			dsFiles, ok := dcm.OutputFiles[runId]
+			if !ok {
+				result = append(result, Discrepancy{Kind: Discrepancy_RunMissingFromDataset, Dataset: name, RunID: runId})
+				continue
+			}
+			onlyWs, onlyDs := fileSetDifference(files, dsFiles)
+			if len(onlyWs) > 0 || len(onlyDs) > 0 {
+				result = append(result, Discrepancy{
+					Kind:            Discrepancy_FilesDiffer,
+					Dataset:         name,
+					RunID:           runId,
+					OnlyInWorkspace: onlyWs,
+					OnlyInDataset:   onlyDs,
+				})
+			}
+		}
+		for runId := range dcm.OutputFiles {
+			if _, ok := written[name][runId]; !ok {
+				result = append(result, Discrepancy{Kind: Discrepancy_RunMissingFromWorkspace, Dataset: name, RunID: runId})
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Dataset != b.Dataset {
+			return a.Dataset < b.Dataset
+		}
+		if a.RunID != b.RunID {
+			return a.RunID < b.RunID
+		}
+		return a.Kind < b.Kind
+	})
+	return result
+}
+
+// fileSetDifference returns the sorted, distinct files only in a, and only
+// in b.
+func fileSetDifference(a, b []string) ([]string, []string) {
+	inA := map[string]bool{}
+	for _, f := range a {
+		inA[f] = true
+	}
+	inB := map[string]bool{}
+	for _, f := range b {
+		inB[f] = true
+	}
+	onlyA := []string{}
+	for f := range inA {
+		if !inB[f] {
+			onlyA = append(onlyA, f)
+		}
+	}
+	onlyB := []string{}
+	for f := range inB {
+		if !inA[f] {
+			onlyB = append(onlyB, f)
+		}
+	}
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+	return onlyA, onlyB
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"testing"
+)
+
+func TestReconcileDatasetCommits(t *testing.T) {
+	cm := CommitMetadata{
+		Outputs: map[string]DatasetVersion{
+			"d": DatasetVersion{ID: "dot-d", Version: "d1"},
+			"e": DatasetVersion{ID: "dot-e", Version: "e1"},
+			"f": DatasetVersion{ID: "dot-f", Version: "f1"},
+		},
+		Runs: []RunMetadata{
+			RunMetadata{
+				RunID: "r1",
+				DatasetOutputFiles: map[string][]string{
+					"d": []string{"a.csv", "b.csv"},
+					"e": []string{"x.csv"},
+				},
+			},
+			RunMetadata{
+				RunID:              "r2",
+				DatasetOutputFiles: map[string][]string{"d": []string{"c.csv"}},
+			},
+		},
+	}
+
+	// Agreement, regardless of file order
+	ok := map[string]DatasetCommitMetadata{
+		"d": DatasetCommitMetadata{
+			WorkspaceDotID: "ws",
+			OutputFiles:    map[string][]string{"r1": []string{"b.csv", "a.csv"}, "r2": []string{"c.csv"}},
+		},
+		"e": DatasetCommitMetadata{WorkspaceDotID: "ws", OutputFiles: map[string][]string{"r1": []string{"x.csv"}}},
+		"f": DatasetCommitMetadata{WorkspaceDotID: "ws", OutputFiles: map[string][]string{}},
+	}
+	if d := ReconcileDatasetCommits("ws", cm, ok); len(d) != 0 {
+		t.Errorf("Expected no discrepancies, got %v", d)
+	}
+
+	bad := map[string]DatasetCommitMetadata{
+		"d": DatasetCommitMetadata{
+			WorkspaceDotID: "ws",
+			OutputFiles:    map[string][]string{"r1": []string{"a.csv", "z.csv"}, "r3": []string{"c.csv"}},
+		},
+		"e": DatasetCommitMetadata{WorkspaceDotID: "other", OutputFiles: map[string][]string{"r1": []string{"x.csv"}}},
+		"g": DatasetCommitMetadata{WorkspaceDotID: "ws", OutputFiles: map[string][]string{}},
+	}
+	d := ReconcileDatasetCommits("ws", cm, bad)
+	got := []string{}
+	for _, x := range d {
+		got = append(got, x.String())
+	}
+	testEqStrs(t, got, []string{
+		"dataset d, run r1: files differ (only in workspace commit: [b.csv], only in dataset commit: [z.csv])",
+		"dataset d, run r2: run missing from dataset commit",
+		"dataset d, run r3: run missing from workspace commit",
+		"dataset e: wrong workspace \"other\"",
+		"dataset f: missing dataset commit",
+		"dataset g: unexpected dataset commit",
+	})
+}
@@ -0,0 +1,414 @@
+package metadata
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCommitNotStarted = errors.New("commit has not been started")
+	ErrCommitStarted    = errors.New("commit has already been started")
+	ErrCommitted        = errors.New("commit has already been made")
+	ErrUnknownRun       = errors.New("no such run")
+	ErrDuplicateRun     = errors.New("run already exists")
+	ErrRunFinished      = errors.New("run has already finished")
+	ErrUnfinishedRuns   = errors.New("some runs have not finished")
+)
+
+// NewRunID returns a new random (version 4) UUID for use as a run ID.
+func NewRunID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("reading random bytes: %s", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+type recordedRun struct {
+	run      RunMetadata
+	finished bool
+}
+
+// type RunRecorder builds up the CommitMetadata for a commit as an agent
+// executes a workload. The lifecycle is:
+//
+//	StartCommit, then AttachDataset for each dataset
+//	StartRun, then Record*/Set* calls, then FinishRun, for each run
+//	Commit
+//
+// Calls out of order fail, as do calls about a run after it has finished.
+// A RunRecorder may be used from several goroutines at once.
+type RunRecorder struct {
+	mu sync.Mutex
+
+	now func() time.Time
+
+	started   bool
+	committed bool
+	commit    CommitMetadata
+	modes     map[string]DotMode
+	runs      []*recordedRun
+	runsById  map[string]*recordedRun
+}
+
+// NewRunRecorder returns a RunRecorder that reads the time from now, or
+// from the system clock if now is nil.
+func NewRunRecorder(now func() time.Time) *RunRecorder {
+	if now == nil {
+		now = time.Now
+	}
+	return &RunRecorder{
+		now:      now,
+		modes:    map[string]DotMode{},
+		runsById: map[string]*recordedRun{},
+	}
+}
+
+// StartCommit begins the commit, stamping its ExecStart. The workload and
+// runner fields of template are copied into the commit; its runs, datasets
+// and times are ignored.
+func (r *RunRecorder) StartCommit(template CommitMetadata) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.committed {
+		return ErrCommitted
+	}
+	if r.started {
+		return ErrCommitStarted
+	}
+
+	r.commit = template
+	r.commit.Inputs = map[string]DatasetVersion{}
+	r.commit.Outputs = map[string]DatasetVersion{}
+	r.commit.Runs = nil
+	r.commit.ExecStart = r.now()
+	r.commit.ExecEnd = time.Time{}
+	r.started = true
+	return nil
+}
+
+// AttachDataset records that a dot is attached to the workload as a
+// dataset under the given name. For an output dataset, version is the
+// commit that the run produced in it; it can be filled in later with
+// SetOutputVersion.
+func (r *RunRecorder) AttachDataset(name string, mode DotMode, id DotID, version string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+	if mode&DotMode_Input != 0 {
+		r.commit.Inputs[name] = DatasetVersion{ID: id, Version: version}
+	}
+	if mode&DotMode_Output != 0 {
+		r.commit.Outputs[name] = DatasetVersion{ID: id, Version: version}
+	}
+	r.modes[name] |= mode
+	return nil
+}
+
+// SetOutputVersion records the commit created in an output dataset.
+func (r *RunRecorder) SetOutputVersion(name string, version string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+	dsv, ok := r.commit.Outputs[name]
+	if !ok {
+		return fmt.Errorf("dataset %q is not attached for output", name)
+	}
+	dsv.Version = version
+	r.commit.Outputs[name] = dsv
+	return nil
+}
+
+// StartRun begins a workload run, stamping its ExecStart, and returns its
+// run ID. If runID is empty, a new one is generated.
+func (r *RunRecorder) StartRun(runID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return "", err
+	}
+	if runID == "" {
+		runID = NewRunID()
+	}
+	if _, ok := r.runsById[runID]; ok {
+		return "", ErrDuplicateRun
+	}
+
+	rr := &recordedRun{
+		run: RunMetadata{
+			RunID:     runID,
+			Authority: RunAuthority_Workload,
+			Success:   true,
+			ExecStart: r.now(),
+		},
+	}
+	r.runs = append(r.runs, rr)
+	r.runsById[runID] = rr
+	return runID, nil
+}
+
+// AddRun adds a run that has already finished, such as a correction run,
+// exactly as given.
+func (r *RunRecorder) AddRun(run RunMetadata) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+	if run.RunID == "" {
+		run.RunID = NewRunID()
+	}
+	if _, ok := r.runsById[run.RunID]; ok {
+		return ErrDuplicateRun
+	}
+	rr := &recordedRun{run: copyRun(run), finished: true}
+	r.runs = append(r.runs, rr)
+	r.runsById[run.RunID] = rr
+	return nil
+}
+
+// withRun calls fn on an unfinished run, with the lock held.
+func (r *RunRecorder) withRun(runID string, fn func(run *RunMetadata) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+	rr, ok := r.runsById[runID]
+	if !ok {
+		return ErrUnknownRun
+	}
+	if rr.finished {
+		return ErrRunFinished
+	}
+	return fn(&rr.run)
+}
+
+func (r *RunRecorder) checkDataset(name string, mode DotMode) error {
+	if r.modes[name]&mode == 0 {
+		if mode == DotMode_Input {
+			return fmt.Errorf("dataset %q is not attached for input", name)
+		}
+		return fmt.Errorf("dataset %q is not attached for output", name)
+	}
+	return nil
+}
+
+// SetDescription sets a run's description.
+func (r *RunRecorder) SetDescription(runID, description string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		run.Description = description
+		return nil
+	})
+}
+
+// SetWorkloadFile sets the file, such as a script or notebook, that a run
+// executed.
+func (r *RunRecorder) SetWorkloadFile(runID, filename string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		run.WorkloadFile = filename
+		return nil
+	})
+}
+
+// RecordInput records that a run read a workspace file at a given version.
+func (r *RunRecorder) RecordInput(runID, filename, version string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		run.WorkspaceInputFiles = append(run.WorkspaceInputFiles, InputFile{Filename: filename, Version: version})
+		return nil
+	})
+}
+
+// RecordOutput records that a run wrote a workspace file.
+func (r *RunRecorder) RecordOutput(runID, filename string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		run.WorkspaceOutputFiles = append(run.WorkspaceOutputFiles, filename)
+		return nil
+	})
+}
+
+// RecordDatasetInput records that a run read a file, at a given version,
+// from a dataset attached for input.
+func (r *RunRecorder) RecordDatasetInput(runID, dataset, filename, version string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		if err := r.checkDataset(dataset, DotMode_Input); err != nil {
+			return err
+		}
+		if run.DatasetInputFiles == nil {
+			run.DatasetInputFiles = map[string][]InputFile{}
+		}
+		run.DatasetInputFiles[dataset] = append(run.DatasetInputFiles[dataset], InputFile{Filename: filename, Version: version})
+		return nil
+	})
+}
+
+// RecordDatasetOutput records that a run wrote a file to a dataset
+// attached for output.
+func (r *RunRecorder) RecordDatasetOutput(runID, dataset, filename string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		if err := r.checkDataset(dataset, DotMode_Output); err != nil {
+			return err
+		}
+		if run.DatasetOutputFiles == nil {
+			run.DatasetOutputFiles = map[string][]string{}
+		}
+		run.DatasetOutputFiles[dataset] = append(run.DatasetOutputFiles[dataset], filename)
+		return nil
+	})
+}
+
+// SetParameter records a parameter of a run.
+func (r *RunRecorder) SetParameter(runID, key, value string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		if run.Parameters == nil {
+			run.Parameters = map[string]string{}
+		}
+		run.Parameters[key] = value
+		return nil
+	})
+}
+
+// SetSummary records a summary statistic of a run.
+func (r *RunRecorder) SetSummary(runID, key, value string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		if run.Summary == nil {
+			run.Summary = map[string]string{}
+		}
+		run.Summary[key] = value
+		return nil
+	})
+}
+
+// SetLabel records a label of a run.
+func (r *RunRecorder) SetLabel(runID, key, value string) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		if run.Labels == nil {
+			run.Labels = map[string]string{}
+		}
+		run.Labels[key] = value
+		return nil
+	})
+}
+
+// FinishRun ends a run, stamping its ExecEnd. A nil runErr means the run
+// succeeded; otherwise its message is recorded as the run's error.
+func (r *RunRecorder) FinishRun(runID string, runErr error) error {
+	return r.withRun(runID, func(run *RunMetadata) error {
+		run.ExecEnd = r.now()
+		if runErr != nil {
+			message := runErr.Error()
+			run.Success = false
+			run.ErrorMessage = &message
+		}
+		r.runsById[runID].finished = true
+		return nil
+	})
+}
+
+// Commit ends the commit, stamping its ExecEnd, and returns its metadata in
+// both parsed and flat form. The commit succeeds if every run did. It fails
+// if any run is unfinished; once it has succeeded, the recorder accepts no
+// further calls.
+func (r *RunRecorder) Commit() (CommitMetadata, map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkOpen(); err != nil {
+		return CommitMetadata{}, nil, err
+	}
+
+	unfinished := []string{}
+	for _, rr := range r.runs {
+		if !rr.finished {
+			unfinished = append(unfinished, rr.run.RunID)
+		}
+	}
+	if len(unfinished) > 0 {
+		return CommitMetadata{}, nil, fmt.Errorf("%w: %v", ErrUnfinishedRuns, unfinished)
+	}
+
+	r.commit.ExecEnd = r.now()
+	r.commit.Success = true
+	r.commit.Runs = make([]RunMetadata, len(r.runs))
+	for idx, rr := range r.runs {
+		r.commit.Runs[idx] = copyRun(rr.run)
+		if !rr.run.Success {
+			r.commit.Success = false
+		}
+	}
+	r.committed = true
+
+	return r.commit, EncodeCommitMetadata(r.commit), nil
+}
+
+func (r *RunRecorder) checkOpen() error {
+	if r.committed {
+		return ErrCommitted
+	}
+	if !r.started {
+		return ErrCommitNotStarted
+	}
+	return nil
+}
+
+// copyRun copies a run so that later changes to either copy's slices and
+// maps don't affect the other.
+func copyRun(run RunMetadata) RunMetadata {
+	result := run
+	result.WorkspaceInputFiles = append([]InputFile(nil), run.WorkspaceInputFiles...)
+	result.WorkspaceOutputFiles = append([]string(nil), run.WorkspaceOutputFiles...)
+	if run.DatasetInputFiles != nil {
+		result.DatasetInputFiles = map[string][]InputFile{}
+		for k, v := range run.DatasetInputFiles {
+			result.DatasetInputFiles[k] = append([]InputFile(nil), v...)
+		}
+	}
+	if run.DatasetOutputFiles != nil {
+		result.DatasetOutputFiles = map[string][]string{}
+		for k, v := range run.DatasetOutputFiles {
+			result.DatasetOutputFiles[k] = append([]string(nil), v...)
+		}
+	}
+	result.WorkspaceOutputHashes = append([]OutputFile(nil), run.WorkspaceOutputHashes...)
+	if run.DatasetOutputHashes != nil {
+		result.DatasetOutputHashes = map[string][]OutputFile{}
+		for k, v := range run.DatasetOutputHashes {
+			result.DatasetOutputHashes[k] = append([]OutputFile(nil), v...)
+		}
+	}
+	result.Labels = copyStringMap(run.Labels)
+	result.Summary = copyStringMap(run.Summary)
+	result.Parameters = copyStringMap(run.Parameters)
+	if run.ErrorMessage != nil {
+		message := *run.ErrorMessage
+		result.ErrorMessage = &message
+	}
+	return result
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := map[string]string{}
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
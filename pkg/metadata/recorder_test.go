@@ -0,0 +1,147 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock returns a clock that advances by a second on each reading.
+func testClock() func() time.Time {
+	var mu sync.Mutex
+	t := time.Date(2018, 10, 4, 13, 6, 0, 0, time.UTC)
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func TestRunRecorder(t *testing.T) {
+	r := NewRunRecorder(testClock())
+
+	if _, err := r.StartRun(""); err != ErrCommitNotStarted {
+		t.Errorf("Expected ErrCommitNotStarted, got %v", err)
+	}
+
+	err := r.StartCommit(CommitMetadata{SubmitterID: "452342", WorkloadImage: "busybox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AttachDataset("b", DotMode_Input, "dot-b", "b1")
+	r.AttachDataset("d", DotMode_Output, "dot-d", "")
+
+	run1, err := r.StartRun("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		r.RecordInput(run1, "foo.csv", "w0"),
+		r.RecordDatasetInput(run1, "b", "input.csv", "b0"),
+		r.RecordOutput(run1, "log.txt"),
+		r.RecordDatasetOutput(run1, "d", "output.csv"),
+		r.SetParameter(run1, "smoothing", "1.0"),
+		r.SetSummary(run1, "rms_error", "0.057"),
+		r.SetLabel(run1, "team", "ml"),
+		r.SetWorkloadFile(run1, "train.py"),
+	} {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := r.RecordDatasetOutput(run1, "b", "x"); err == nil {
+		t.Errorf("Expected an error writing to an input-only dataset")
+	}
+	if _, err := r.StartRun("r1"); err != ErrDuplicateRun {
+		t.Errorf("Expected ErrDuplicateRun, got %v", err)
+	}
+
+	run2, err := r.StartRun("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run2) != 36 {
+		t.Errorf("Expected a generated UUID, got %s", run2)
+	}
+
+	if err := r.FinishRun(run1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RecordOutput(run1, "late.txt"); err != ErrRunFinished {
+		t.Errorf("Expected ErrRunFinished, got %v", err)
+	}
+
+	if _, _, err := r.Commit(); !errors.Is(err, ErrUnfinishedRuns) {
+		t.Errorf("Expected ErrUnfinishedRuns committing with an unfinished run, got %v", err)
+	}
+	if err := r.FinishRun(run2, errors.New("out of memory")); err != nil {
+		t.Fatal(err)
+	}
+	r.SetOutputVersion("d", "d1")
+
+	cm, m, err := r.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Commit(); err != ErrCommitted {
+		t.Errorf("Expected ErrCommitted, got %v", err)
+	}
+
+	if cm.Success {
+		t.Errorf("Expected the commit to fail with a failed run")
+	}
+	testEqTime(t, cm.ExecStart, time.Date(2018, 10, 4, 13, 6, 1, 0, time.UTC))
+	testEqTime(t, cm.Runs[0].ExecStart, time.Date(2018, 10, 4, 13, 6, 2, 0, time.UTC))
+	testEqTime(t, cm.Runs[0].ExecEnd, time.Date(2018, 10, 4, 13, 6, 4, 0, time.UTC))
+	testEqTime(t, cm.ExecEnd, time.Date(2018, 10, 4, 13, 6, 6, 0, time.UTC))
+	testEqDsvs(t, cm.Outputs, map[string]DatasetVersion{"d": DatasetVersion{ID: "dot-d", Version: "d1"}})
+
+	testEqStr(t, m["author"], "452342")
+	testEqStr(t, m["output-dataset.d"], "dot-d@d1")
+	testEqStr(t, m["run.r1.dataset-output-files.d"], "[\"output.csv\"]")
+	testEqStr(t, m["run."+run2+".error"], "out of memory")
+
+	parsed := ParseCommitMetadata(m)
+	testEqStrs(t, parsed.Runs[0].WorkspaceOutputFiles, []string{"log.txt"})
+	testEqMap(t, parsed.Runs[0].Parameters, map[string]string{"smoothing": "1.0"})
+}
+
+func TestRunRecorderConcurrent(t *testing.T) {
+	r := NewRunRecorder(nil)
+	r.StartCommit(CommitMetadata{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := r.StartRun(fmt.Sprintf("r%d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < 10; j++ {
+				r.RecordOutput(id, fmt.Sprintf("out%d.txt", j))
+			}
+			r.FinishRun(id, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	cm, _, err := r.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.Runs) != 20 {
+		t.Fatalf("Expected 20 runs, got %d", len(cm.Runs))
+	}
+	for _, run := range cm.Runs {
+		if len(run.WorkspaceOutputFiles) != 10 {
+			t.Errorf("Expected 10 outputs for %s, got %d", run.RunID, len(run.WorkspaceOutputFiles))
+		}
+	}
+}
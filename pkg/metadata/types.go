@@ -87,10 +87,57 @@ const (
 	RunAuthority_Correction
 )
 
-// type InputFile records the version of a file used as input
+// String returns the name used for a run authority in flat metadata:
+// "workload", "derived" or "correction". Unknown values are treated as
+// corrections, as they are when parsing.
+func (a RunAuthority) String() string {
+	switch a {
+	case RunAuthority_Workload:
+		return "workload"
+	case RunAuthority_Derived:
+		return "derived"
+	default:
+		return "correction"
+	}
+}
+
+// ParseRunAuthority parses the name of a run authority, as String returns
+// it. Unknown names are treated as corrections, which attract attention.
+func ParseRunAuthority(s string) RunAuthority {
+	switch s {
+	case "workload":
+		return RunAuthority_Workload
+	case "derived":
+		return RunAuthority_Derived
+	default:
+		return RunAuthority_Correction
+	}
+}
+
+// ResolveDataset returns the version of the dataset attached under a name,
+// falling back to a dot with the name as its ID, at an unknown version, if
+// there is none.
+func ResolveDataset(dsvs map[string]DatasetVersion, name string) DatasetVersion {
+	dsv, ok := dsvs[name]
+	if !ok {
+		return DatasetVersion{ID: DotID(name)}
+	}
+	return dsv
+}
+
+// type InputFile records the version of a file used as input, and the
+// hex-encoded SHA-256 of its contents if known.
 type InputFile struct {
 	Filename string `json:"filename"`
 	Version  string `json:"version"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// type OutputFile records the hex-encoded SHA-256 of the contents of a
+// file written by a run.
+type OutputFile struct {
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
 }
 
 // type RunMetadata records the final result of a run.
@@ -111,6 +158,10 @@ type RunMetadata struct {
 	DatasetInputFiles  map[string][]InputFile `json:"dataset_input_files,omitempty"`
 	DatasetOutputFiles map[string][]string    `json:"dataset_output_files,omitempty"`
 
+	// Content hashes of the output files, where known, sorted by filename.
+	WorkspaceOutputHashes []OutputFile            `json:"workspace_output_hashes,omitempty"`
+	DatasetOutputHashes   map[string][]OutputFile `json:"dataset_output_hashes,omitempty"`
+
 	Labels     map[string]string `json:"labels,omitempty"`
 	Summary    map[string]string `json:"summary,omitempty"`
 	Parameters map[string]string `json:"parameters,omitempty"`
@@ -0,0 +1,168 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// type FileMismatch records a file whose local contents don't match the
+// hash recorded in the metadata.
+type FileMismatch struct {
+	// Dataset is the name of the dataset holding the file, or empty for the
+	// workspace.
+	Dataset  string
+	Filename string
+	Expected string
+	// Actual is the hash of the local file, or empty if it is missing or
+	// couldn't be hashed.
+	Actual string
+	// Problem says why the local file couldn't be hashed, if it couldn't.
+	Problem string
+}
+
+func (m FileMismatch) String() string {
+	name := m.Filename
+	if m.Dataset != "" {
+		name = m.Dataset + ":" + m.Filename
+	}
+	if m.Problem != "" {
+		return fmt.Sprintf("%s %s, expected %s", name, m.Problem, m.Expected)
+	}
+	if m.Actual == "" {
+		return fmt.Sprintf("%s is missing, expected %s", name, m.Expected)
+	}
+	return fmt.Sprintf("%s has hash %s, expected %s", name, m.Actual, m.Expected)
+}
+
+// HashFile returns the hex-encoded SHA-256 of a file's contents.
+func HashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyFiles checks the files beneath dir against a map from
+// slash-separated filenames to expected hashes, returning the mismatches
+// sorted by filename. Filenames can't refer to files outside dir. A
+// filename naming something other than a regular file, such as a
+// directory, is reported as a mismatch with a Problem.
+func VerifyFiles(dir string, hashes map[string]string) ([]FileMismatch, error) {
+	filenames := []string{}
+	for filename := range hashes {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	mismatches := []FileMismatch{}
+	for _, filename := range filenames {
+		local := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+filename), "/")))
+		info, err := os.Stat(local)
+		if err == nil && !info.Mode().IsRegular() {
+			problem := "is not a regular file"
+			if info.IsDir() {
+				problem = "is a directory"
+			}
+			mismatches = append(mismatches, FileMismatch{Filename: filename, Expected: hashes[filename], Problem: problem})
+			continue
+		}
+		actual, err := HashFile(local)
+		if os.IsNotExist(err) {
+			actual = ""
+		} else if err != nil {
+			return nil, err
+		}
+		if actual != hashes[filename] {
+			mismatches = append(mismatches, FileMismatch{Filename: filename, Expected: hashes[filename], Actual: actual})
+		}
+	}
+	return mismatches, nil
+}
+
+// VerifyRunInputs checks a run's input files that have recorded hashes
+// against a local copy of the workspace, and of each dataset by name, as
+// they were when the run started. Datasets missing from datasetDirs are
+// not checked, nor is the workspace if workspaceDir is empty.
+func VerifyRunInputs(run RunMetadata, workspaceDir string, datasetDirs map[string]string) ([]FileMismatch, error) {
+	workspace := map[string]string{}
+	for _, inf := range run.WorkspaceInputFiles {
+		if inf.Hash != "" {
+			workspace[inf.Filename] = inf.Hash
+		}
+	}
+	datasets := map[string]map[string]string{}
+	for name, ifs := range run.DatasetInputFiles {
+		datasets[name] = map[string]string{}
+		for _, inf := range ifs {
+			if inf.Hash != "" {
+				datasets[name][inf.Filename] = inf.Hash
+			}
+		}
+	}
+	return verifyDirs(workspace, datasets, workspaceDir, datasetDirs)
+}
+
+// VerifyRunOutputs checks a run's output files that have recorded hashes
+// against a local copy of the workspace, and of each dataset by name, as
+// committed. Datasets missing from datasetDirs are not checked, nor is the
+// workspace if workspaceDir is empty.
+func VerifyRunOutputs(run RunMetadata, workspaceDir string, datasetDirs map[string]string) ([]FileMismatch, error) {
+	workspace := map[string]string{}
+	for _, of := range run.WorkspaceOutputHashes {
+		workspace[of.Filename] = of.Hash
+	}
+	datasets := map[string]map[string]string{}
+	for name, ofs := range run.DatasetOutputHashes {
+		datasets[name] = map[string]string{}
+		for _, of := range ofs {
+			datasets[name][of.Filename] = of.Hash
+		}
+	}
+	return verifyDirs(workspace, datasets, workspaceDir, datasetDirs)
+}
+
+func verifyDirs(workspace map[string]string, datasets map[string]map[string]string, workspaceDir string, datasetDirs map[string]string) ([]FileMismatch, error) {
+	mismatches := []FileMismatch{}
+	if workspaceDir != "" {
+		found, err := VerifyFiles(workspaceDir, workspace)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, found...)
+	}
+
+	names := []string{}
+	for name := range datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dir, ok := datasetDirs[name]
+		if !ok {
+			continue
+		}
+		found, err := VerifyFiles(dir, datasets[name])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range found {
+			m.Dataset = name
+			mismatches = append(mismatches, m)
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyRunFiles(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	dataset, err := ioutil.TempDir("", "dataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataset)
+
+	os.Mkdir(filepath.Join(workspace, "data"), 0755)
+	for name, content := range map[string]string{
+		filepath.Join(workspace, "data", "foo.csv"): "1,2,3",
+		filepath.Join(workspace, "model.pkl"):       "tampered",
+		filepath.Join(dataset, "output.csv"):        "4,5,6",
+	} {
+		err := ioutil.WriteFile(name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := RunMetadata{
+		WorkspaceInputFiles: []InputFile{
+			InputFile{Filename: "data/foo.csv", Version: "w0", Hash: testHash("1,2,3")},
+			InputFile{Filename: "unhashed.csv", Version: "w0"},
+		},
+		WorkspaceOutputHashes: []OutputFile{
+			OutputFile{Filename: "model.pkl", Hash: testHash("model")},
+			OutputFile{Filename: "missing.txt", Hash: testHash("log")},
+			OutputFile{Filename: "data", Hash: testHash("dir")},
+		},
+		DatasetOutputHashes: map[string][]OutputFile{
+			"d": []OutputFile{OutputFile{Filename: "output.csv", Hash: testHash("7,8,9")}},
+			"e": []OutputFile{OutputFile{Filename: "unchecked.csv", Hash: testHash("x")}},
+		},
+	}
+
+	mismatches, err := VerifyRunInputs(run, workspace, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("Expected inputs to match, got %v", mismatches)
+	}
+
+	mismatches, err = VerifyRunOutputs(run, workspace, map[string]string{"d": dataset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 4 {
+		t.Fatalf("Expected 4 mismatches, got %v", mismatches)
+	}
+	testEqStr(t, mismatches[0].String(), "data is a directory, expected "+testHash("dir"))
+	testEqStr(t, mismatches[1].String(), "missing.txt is missing, expected "+testHash("log"))
+	testEqStr(t, mismatches[2].Filename, "model.pkl")
+	testEqStr(t, mismatches[2].Actual, testHash("tampered"))
+	testEqStr(t, mismatches[3].String(), "d:output.csv has hash "+testHash("4,5,6")+", expected "+testHash("7,8,9"))
+}
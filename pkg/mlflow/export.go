@@ -0,0 +1,280 @@
+package mlflow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// Run statuses, as stored in a run's meta.yaml.
+const (
+	RunStatus_Running   = 1
+	RunStatus_Scheduled = 2
+	RunStatus_Finished  = 3
+	RunStatus_Failed    = 4
+	RunStatus_Killed    = 5
+)
+
+// Tags used to carry Dotscience fields that have no MLflow equivalent.
+const (
+	TagRunID         = "dotscience.run_id"
+	TagCommitID      = "dotscience.commit_id"
+	TagAuthority     = "dotscience.authority"
+	TagError         = "dotscience.error"
+	TagWorkloadImage = "dotscience.workload_image"
+	TagSummaryPrefix = "dotscience.summary."
+
+	TagUser       = "mlflow.user"
+	TagRunName    = "mlflow.runName"
+	TagSourceName = "mlflow.source.name"
+)
+
+// type Exporter writes Dotscience runs into an MLflow FileStore directory.
+type Exporter struct {
+	// Root is the mlruns directory.
+	Root string
+
+	// ExperimentID and ExperimentName identify the experiment that runs are
+	// written into. ExperimentID defaults to "0", the MLflow default
+	// experiment, and ExperimentName to "Default".
+	ExperimentID   string
+	ExperimentName string
+}
+
+func (e Exporter) experimentID() string {
+	if e.ExperimentID == "" {
+		return "0"
+	}
+	return e.ExperimentID
+}
+
+func (e Exporter) experimentName() string {
+	if e.ExperimentName == "" {
+		return "Default"
+	}
+	return e.ExperimentName
+}
+
+// Export writes every run of every commit into the experiment, creating the
+// experiment if it does not already exist. Runs that are already present are
+// replaced. It is an error for two Dotscience runs to map to the same MLflow
+// run ID.
+func (e Exporter) Export(commits []metadata.CommitMetadata) error {
+	expDir, err := filepath.Abs(filepath.Join(e.Root, e.experimentID()))
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(expDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(filepath.Join(expDir, "meta.yaml"))
+	if os.IsNotExist(err) {
+		now := strconv.FormatInt(toMillis(time.Now()), 10)
+		err = writeMeta(filepath.Join(expDir, "meta.yaml"), [][2]string{
+			{"artifact_location", quote("file://" + filepath.ToSlash(expDir))},
+			{"creation_time", now},
+			{"experiment_id", quote(e.experimentID())},
+			{"last_update_time", now},
+			{"lifecycle_stage", "active"},
+			{"name", quote(e.experimentName())},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, cm := range commits {
+		for _, run := range cm.Runs {
+			err = e.exportRun(expDir, cm, run)
+			if err != nil {
+				return fmt.Errorf("exporting run %s: %s", run.RunID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RunUUID converts a Dotscience run ID into an MLflow run ID, which is a
+// UUID with the dashes removed. Run IDs that differ only in case or dashes
+// map to the same MLflow run ID; Export reports such collisions.
+func RunUUID(runID string) string {
+	return strings.ToLower(strings.Replace(runID, "-", "", -1))
+}
+
+func (e Exporter) exportRun(expDir string, cm metadata.CommitMetadata, run metadata.RunMetadata) error {
+	runUUID := RunUUID(run.RunID)
+	if err := checkKey(runUUID); err != nil || strings.Contains(runUUID, "/") {
+		return fmt.Errorf("invalid run ID %q", run.RunID)
+	}
+	runDir := filepath.Join(expDir, runUUID)
+
+	// Replace the run if it has been exported before, so that no params,
+	// metrics or tags are left over from last time, but not if it belongs
+	// to another Dotscience run.
+	previous, err := ioutil.ReadFile(filepath.Join(runDir, "tags", TagRunID))
+	if err == nil && string(previous) != run.RunID {
+		return fmt.Errorf("runs %s and %s both map to MLflow run %s", previous, run.RunID, runUUID)
+	}
+	err = os.RemoveAll(runDir)
+	if err != nil {
+		return err
+	}
+	for _, sub := range []string{"params", "metrics", "tags", "artifacts"} {
+		err := os.MkdirAll(filepath.Join(runDir, sub), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	start := run.ExecStart
+	if start.IsZero() {
+		start = cm.ExecStart
+	}
+	end := run.ExecEnd
+	if end.IsZero() {
+		end = cm.ExecEnd
+	}
+
+	status := RunStatus_Finished
+	if !run.Success || run.ErrorMessage != nil {
+		status = RunStatus_Failed
+	}
+
+	err = writeMeta(filepath.Join(runDir, "meta.yaml"), [][2]string{
+		{"artifact_uri", quote("file://" + filepath.ToSlash(filepath.Join(runDir, "artifacts")))},
+		{"end_time", millisOrNull(end)},
+		{"entry_point_name", "''"},
+		{"experiment_id", quote(e.experimentID())},
+		{"lifecycle_stage", "active"},
+		{"name", quote(run.Description)},
+		{"run_id", runUUID},
+		{"run_uuid", runUUID},
+		{"source_name", quote(run.WorkloadFile)},
+		{"source_type", "4"},
+		{"source_version", "''"},
+		{"start_time", millisOrNull(start)},
+		{"status", strconv.Itoa(status)},
+		{"tags", "[]"},
+		{"user_id", quote(cm.SubmitterID)},
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range run.Parameters {
+		err = writeKeyFile(filepath.Join(runDir, "params"), k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	tags := map[string]string{}
+	for k, v := range run.Labels {
+		tags[k] = v
+	}
+
+	// Metrics are logged at the end of the run, or failing that at the
+	// latest time known
+	metricTime := end
+	if metricTime.IsZero() {
+		metricTime = start
+	}
+	for k, v := range run.Summary {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || metricTime.IsZero() {
+			// MLflow metrics must be numeric and timestamped; keep the
+			// value as a tag so that it isn't lost.
+			tags[TagSummaryPrefix+k] = v
+			continue
+		}
+		line := fmt.Sprintf("%d %s 0\n", toMillis(metricTime), strconv.FormatFloat(f, 'g', -1, 64))
+		err = writeKeyFile(filepath.Join(runDir, "metrics"), k, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	tags[TagRunID] = run.RunID
+	tags[TagAuthority] = run.Authority.String()
+	setIfNotEmpty(tags, TagCommitID, run.CommitID)
+	setIfNotEmpty(tags, TagUser, cm.SubmitterID)
+	setIfNotEmpty(tags, TagRunName, run.Description)
+	setIfNotEmpty(tags, TagSourceName, run.WorkloadFile)
+	setIfNotEmpty(tags, TagWorkloadImage, cm.WorkloadImage)
+	if run.ErrorMessage != nil {
+		tags[TagError] = *run.ErrorMessage
+	}
+	for k, v := range tags {
+		err = writeKeyFile(filepath.Join(runDir, "tags"), k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setIfNotEmpty(m map[string]string, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
+
+// checkKey rejects keys that would escape the directory they are written
+// to. MLflow allows "/" in keys, which the FileStore maps to subdirectories.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+func writeKeyFile(dir, key, value string) error {
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.FromSlash(key))
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(value), 0644)
+}
+
+// writeMeta writes a flat YAML mapping, in the order given.
+func writeMeta(path string, fields [][2]string) error {
+	var b strings.Builder
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%s: %s\n", f[0], f[1])
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// quote renders s as a single-quoted YAML scalar.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func millisOrNull(t time.Time) string {
+	if t.IsZero() {
+		return "null"
+	}
+	return strconv.FormatInt(toMillis(t), 10)
+}
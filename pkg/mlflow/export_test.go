@@ -0,0 +1,183 @@
+package mlflow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testReadFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("Reading %s: %s", path, err)
+		return ""
+	}
+	return string(b)
+}
+
+func testCommits() []metadata.CommitMetadata {
+	oops := "out of memory"
+	return []metadata.CommitMetadata{
+		{
+			SubmitterID:   "452342",
+			WorkloadImage: "busybox",
+			Runs: []metadata.RunMetadata{
+				{
+					RunID:        "02ecdc67-c49e-4d76-abe8-1ee13f2884b7",
+					Success:      true,
+					WorkloadFile: "train.py",
+					Parameters:   map[string]string{"smoothing": "1.0"},
+					Summary:      map[string]string{"rms_error": "0.057", "model": "linear"},
+					Labels:       map[string]string{"team": "ml"},
+					ExecStart:    time.Date(2018, 10, 4, 13, 6, 7, 225000000, time.UTC),
+					ExecEnd:      time.Date(2018, 10, 4, 13, 6, 8, 225000000, time.UTC),
+				},
+			},
+		},
+		{
+			SubmitterID: "452342",
+			Runs: []metadata.RunMetadata{
+				{
+					RunID:        "cd351be8-3ba9-4c5e-ad26-429d6d6033de",
+					ErrorMessage: &oops,
+				},
+			},
+		},
+	}
+}
+
+func TestExport(t *testing.T) {
+	root, err := ioutil.TempDir("", "mlruns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	err = Exporter{Root: root, ExperimentID: "7", ExperimentName: "it's mine"}.Export(testCommits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expMeta := testReadFile(t, filepath.Join(root, "7", "meta.yaml"))
+	if !strings.Contains(expMeta, "name: 'it''s mine'\n") {
+		t.Errorf("Unexpected experiment meta.yaml:\n%s", expMeta)
+	}
+
+	runDir := filepath.Join(root, "7", "02ecdc67c49e4d76abe81ee13f2884b7")
+	runMeta := testReadFile(t, filepath.Join(runDir, "meta.yaml"))
+	for _, want := range []string{
+		"start_time: 1538658367225\n",
+		"end_time: 1538658368225\n",
+		"status: 3\n",
+		"user_id: '452342'\n",
+	} {
+		if !strings.Contains(runMeta, want) {
+			t.Errorf("Wanted %q in meta.yaml:\n%s", want, runMeta)
+		}
+	}
+
+	if got := testReadFile(t, filepath.Join(runDir, "params", "smoothing")); got != "1.0" {
+		t.Errorf("Wanted param 1.0, got %q", got)
+	}
+	if got := testReadFile(t, filepath.Join(runDir, "metrics", "rms_error")); got != "1538658368225 0.057 0\n" {
+		t.Errorf("Unexpected metric %q", got)
+	}
+	if got := testReadFile(t, filepath.Join(runDir, "tags", "team")); got != "ml" {
+		t.Errorf("Wanted label tag, got %q", got)
+	}
+	if got := testReadFile(t, filepath.Join(runDir, "tags", TagSummaryPrefix+"model")); got != "linear" {
+		t.Errorf("Wanted non-numeric summary as tag, got %q", got)
+	}
+	if got := testReadFile(t, filepath.Join(runDir, "tags", TagRunID)); got != "02ecdc67-c49e-4d76-abe8-1ee13f2884b7" {
+		t.Errorf("Wanted run ID tag, got %q", got)
+	}
+
+	failedMeta := testReadFile(t, filepath.Join(root, "7", "cd351be83ba94c5ead26429d6d6033de", "meta.yaml"))
+	if !strings.Contains(failedMeta, "status: 4\n") || !strings.Contains(failedMeta, "end_time: null\n") {
+		t.Errorf("Unexpected failed run meta.yaml:\n%s", failedMeta)
+	}
+}
+
+func TestExportRejectsEscapingKeys(t *testing.T) {
+	root, err := ioutil.TempDir("", "mlruns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	err = Exporter{Root: root}.Export([]metadata.CommitMetadata{{
+		Runs: []metadata.RunMetadata{{
+			RunID:      "02ecdc67-c49e-4d76-abe8-1ee13f2884b7",
+			Parameters: map[string]string{"../../evil": "x"},
+		}},
+	}})
+	if err == nil {
+		t.Errorf("Expected an error for a parameter key containing ..")
+	}
+}
+
+func TestExportMetricTimes(t *testing.T) {
+	root, err := ioutil.TempDir("", "mlruns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	err = Exporter{Root: root}.Export([]metadata.CommitMetadata{{
+		ExecStart: time.Date(2018, 10, 4, 13, 6, 7, 225000000, time.UTC),
+		Runs: []metadata.RunMetadata{
+			{RunID: "02ecdc67-c49e-4d76-abe8-1ee13f2884b7", Summary: map[string]string{"rms_error": "0.057"}},
+		},
+	}, {
+		Runs: []metadata.RunMetadata{
+			{RunID: "cd351be8-3ba9-4c5e-ad26-429d6d6033de", Summary: map[string]string{"rms_error": "0.057"}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testReadFile(t, filepath.Join(root, "0", "02ecdc67c49e4d76abe81ee13f2884b7", "metrics", "rms_error")); got != "1538658367225 0.057 0\n" {
+		t.Errorf("Wanted the metric at the start time, got %q", got)
+	}
+	// With no time at all, the metric is kept as a tag
+	runDir := filepath.Join(root, "0", "cd351be83ba94c5ead26429d6d6033de")
+	if got := testReadFile(t, filepath.Join(runDir, "tags", TagSummaryPrefix+"rms_error")); got != "0.057" {
+		t.Errorf("Wanted the metric as a tag, got %q", got)
+	}
+}
+
+func TestExportReplacesRuns(t *testing.T) {
+	root, err := ioutil.TempDir("", "mlruns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	commits := testCommits()
+	err = Exporter{Root: root}.Export(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(commits[0].Runs[0].Parameters, "smoothing")
+	err = Exporter{Root: root}.Export(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(root, "0", "02ecdc67c49e4d76abe81ee13f2884b7", "params", "smoothing"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected the stale parameter to be removed, got %v", err)
+	}
+
+	// A different run that maps to the same MLflow run ID is refused
+	commits[1].Runs[0].RunID = "02ECDC67C49E4D76ABE81EE13F2884B7"
+	err = Exporter{Root: root}.Export(commits)
+	if err == nil {
+		t.Errorf("Expected an error for colliding run IDs")
+	}
+}
@@ -0,0 +1,375 @@
+package mlflow
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// type ImportedRun is a single MLflow run, converted into a commit holding
+// one Dotscience run.
+type ImportedRun struct {
+	ExperimentID   string
+	ExperimentName string
+
+	// Commit is the parsed form of the run.
+	Commit metadata.CommitMetadata
+
+	// Metadata is Commit in the flat Dotscience Run Commit Metadata format.
+	Metadata map[string]string
+}
+
+// Import reads every active run of every experiment in an MLflow FileStore
+// directory. Runs are returned ordered by experiment, then start time. Runs
+// that haven't finished, including those still running, are imported as
+// failed, with their MLflow status as the error message.
+func Import(root string) ([]ImportedRun, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ImportedRun{}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		runs, err := ImportExperiment(filepath.Join(root, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, runs...)
+	}
+	return result, nil
+}
+
+// ImportExperiment reads every active run in a single experiment directory.
+// Runs are returned ordered by start time.
+func ImportExperiment(expDir string) ([]ImportedRun, error) {
+	expMeta, err := readMeta(filepath.Join(expDir, "meta.yaml"))
+	if os.IsNotExist(err) {
+		// Not an experiment
+		return []ImportedRun{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if expMeta["lifecycle_stage"] == "deleted" {
+		return []ImportedRun{}, nil
+	}
+
+	entries, err := ioutil.ReadDir(expDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ImportedRun{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		runDir := filepath.Join(expDir, entry.Name())
+		_, err := os.Stat(filepath.Join(runDir, "meta.yaml"))
+		if os.IsNotExist(err) {
+			// Experiment tags and the like, rather than a run
+			continue
+		}
+		run, ok, err := importRun(runDir)
+		if err != nil {
+			return nil, fmt.Errorf("importing run %s: %s", runDir, err)
+		}
+		if ok {
+			run.ExperimentID = expMeta["experiment_id"]
+			run.ExperimentName = expMeta["name"]
+			result = append(result, run)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		ri, rj := result[i].Commit.Runs[0], result[j].Commit.Runs[0]
+		if !ri.ExecStart.Equal(rj.ExecStart) {
+			return ri.ExecStart.Before(rj.ExecStart)
+		}
+		return ri.RunID < rj.RunID
+	})
+	return result, nil
+}
+
+func importRun(runDir string) (ImportedRun, bool, error) {
+	meta, err := readMeta(filepath.Join(runDir, "meta.yaml"))
+	if err != nil {
+		return ImportedRun{}, false, err
+	}
+	if meta["lifecycle_stage"] == "deleted" {
+		return ImportedRun{}, false, nil
+	}
+
+	params, err := readKeyFiles(filepath.Join(runDir, "params"))
+	if err != nil {
+		return ImportedRun{}, false, err
+	}
+	tags, err := readKeyFiles(filepath.Join(runDir, "tags"))
+	if err != nil {
+		return ImportedRun{}, false, err
+	}
+	metrics, err := readMetrics(filepath.Join(runDir, "metrics"))
+	if err != nil {
+		return ImportedRun{}, false, err
+	}
+	artifacts, err := listArtifacts(runDir, meta["artifact_uri"])
+	if err != nil {
+		return ImportedRun{}, false, err
+	}
+
+	runUUID := meta["run_uuid"]
+	if runUUID == "" {
+		runUUID = meta["run_id"]
+	}
+	if runUUID == "" {
+		runUUID = filepath.Base(runDir)
+	}
+
+	run := metadata.RunMetadata{
+		RunID:                runIDFromUUID(runUUID),
+		Authority:            metadata.RunAuthority_Workload,
+		Description:          meta["name"],
+		WorkloadFile:         meta["source_name"],
+		Success:              true,
+		WorkspaceOutputFiles: artifacts,
+		Labels:               map[string]string{},
+		Summary:              metrics,
+		Parameters:           params,
+		ExecStart:            fromMillis(meta["start_time"]),
+		ExecEnd:              fromMillis(meta["end_time"]),
+	}
+
+	// Only finished runs succeeded; runs still running or scheduled may
+	// yet fail
+	status, _ := strconv.Atoi(meta["status"])
+	if status != RunStatus_Finished {
+		run.Success = false
+		message := "MLflow run " + statusName(status)
+		run.ErrorMessage = &message
+	}
+
+	cm := metadata.CommitMetadata{
+		SubmitterID: meta["user_id"],
+		ExecStart:   run.ExecStart,
+		ExecEnd:     run.ExecEnd,
+	}
+
+	for k, v := range tags {
+		switch {
+		case k == TagRunID:
+			run.RunID = v
+		case k == TagCommitID:
+			run.CommitID = v
+		case k == TagAuthority:
+			run.Authority = metadata.ParseRunAuthority(v)
+		case k == TagError:
+			message := v
+			run.ErrorMessage = &message
+			run.Success = false
+		case k == TagWorkloadImage:
+			cm.WorkloadImage = v
+		case k == TagUser:
+			cm.SubmitterID = v
+		case k == TagRunName:
+			run.Description = v
+		case k == TagSourceName:
+			run.WorkloadFile = v
+		case strings.HasPrefix(k, TagSummaryPrefix):
+			run.Summary[strings.TrimPrefix(k, TagSummaryPrefix)] = v
+		default:
+			run.Labels[k] = v
+		}
+	}
+
+	cm.Success = run.Success
+	cm.Runs = []metadata.RunMetadata{run}
+
+	return ImportedRun{
+		Commit:   cm,
+		Metadata: metadata.EncodeCommitMetadata(cm),
+	}, true, nil
+}
+
+// runIDFromUUID turns an MLflow run ID back into a dashed UUID, if it looks
+// like one.
+func runIDFromUUID(u string) string {
+	if len(u) != 32 {
+		return u
+	}
+	for _, c := range u {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return u
+		}
+	}
+	return u[0:8] + "-" + u[8:12] + "-" + u[12:16] + "-" + u[16:20] + "-" + u[20:32]
+}
+
+func statusName(status int) string {
+	switch status {
+	case RunStatus_Running:
+		return "RUNNING"
+	case RunStatus_Scheduled:
+		return "SCHEDULED"
+	case RunStatus_Finished:
+		return "FINISHED"
+	case RunStatus_Failed:
+		return "FAILED"
+	case RunStatus_Killed:
+		return "KILLED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func fromMillis(s string) time.Time {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// readMeta reads the top-level scalar keys of a meta.yaml file. MLflow only
+// writes flat mappings there, so nested values are ignored.
+func readMeta(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == ' ' || line[0] == '-' || line[0] == '#' {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		result[line[:idx]] = unquote(strings.TrimSpace(line[idx+1:]))
+	}
+	return result, scanner.Err()
+}
+
+func unquote(v string) string {
+	switch {
+	case v == "null" || v == "~":
+		return ""
+	case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+		return strings.Replace(v[1:len(v)-1], "''", "'", -1)
+	case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return v[1 : len(v)-1]
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// readKeyFiles reads a params or tags directory, where each file is named
+// after its key and holds the value. Keys containing "/" are stored in
+// subdirectories.
+func readKeyFiles(dir string) (map[string]string, error) {
+	result := map[string]string{}
+	err := walkFiles(dir, func(key, path string) error {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		result[key] = string(b)
+		return nil
+	})
+	return result, err
+}
+
+// readMetrics reads a metrics directory, keeping the latest value of each
+// metric. Each line of a metric file is "TIMESTAMP VALUE [STEP]".
+func readMetrics(dir string) (map[string]string, error) {
+	result := map[string]string{}
+	err := walkFiles(dir, func(key, path string) error {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var bestStep, bestTime int64
+		found := false
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			ts, _ := strconv.ParseInt(fields[0], 10, 64)
+			var step int64
+			if len(fields) > 2 {
+				step, _ = strconv.ParseInt(fields[2], 10, 64)
+			}
+			if !found || step > bestStep || (step == bestStep && ts >= bestTime) {
+				bestStep, bestTime, found = step, ts, true
+				result[key] = fields[1]
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// listArtifacts returns the paths of the run's artifacts, relative to the
+// artifact root. Only local artifact stores can be listed.
+func listArtifacts(runDir, artifactURI string) ([]string, error) {
+	dir := filepath.Join(runDir, "artifacts")
+	if artifactURI != "" {
+		u, err := url.Parse(artifactURI)
+		if err == nil && (u.Scheme == "file" || u.Scheme == "") {
+			if _, err := os.Stat(u.Path); err == nil {
+				dir = u.Path
+			}
+		}
+	}
+
+	result := []string{}
+	err := walkFiles(dir, func(key, path string) error {
+		result = append(result, key)
+		return nil
+	})
+	sort.Strings(result)
+	return result, err
+}
+
+// walkFiles calls fn for every regular file under dir, with its slash
+// separated path relative to dir. A missing dir is treated as empty.
+func walkFiles(dir string, fn func(key, path string) error) error {
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), path)
+	})
+}
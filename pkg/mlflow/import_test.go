@@ -0,0 +1,190 @@
+package mlflow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testEqStr(t *testing.T, got, expected string) {
+	t.Helper()
+	if got != expected {
+		t.Errorf("Wanted %s, got %s", expected, got)
+	}
+}
+
+func testEqStrs(t *testing.T, got, expected []string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("Wanted %#v, got %#v", expected, got)
+		return
+	}
+	for idx, v := range expected {
+		if got[idx] != v {
+			t.Errorf("Wanted %#v, got %#v", expected, got)
+			return
+		}
+	}
+}
+
+func testEqMap(t *testing.T, got, expected map[string]string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("Wanted %#v, got %#v", expected, got)
+		return
+	}
+	for k, v := range expected {
+		gv, ok := got[k]
+		if !ok || (gv != v) {
+			t.Errorf("Wanted %#v, got %#v", expected, got)
+			return
+		}
+	}
+}
+
+func testWriteFile(t *testing.T, path, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportNativeRun(t *testing.T) {
+	root, err := ioutil.TempDir("", "mlruns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	testWriteFile(t, filepath.Join(root, "1", "meta.yaml"), "artifact_location: file:///nowhere/1\nexperiment_id: '1'\nlifecycle_stage: active\nname: legacy\n")
+	runDir := filepath.Join(root, "1", "5f1f0b6a9a1c4b4e8b1e1c2d3e4f5a6b")
+	testWriteFile(t, filepath.Join(runDir, "meta.yaml"), `artifact_uri: file:///nowhere/1/5f1f0b6a9a1c4b4e8b1e1c2d3e4f5a6b/artifacts
+end_time: 1538658368225
+entry_point_name: ''
+experiment_id: '1'
+lifecycle_stage: active
+name: ''
+run_id: 5f1f0b6a9a1c4b4e8b1e1c2d3e4f5a6b
+run_uuid: 5f1f0b6a9a1c4b4e8b1e1c2d3e4f5a6b
+source_name: ''
+source_type: 4
+source_version: ''
+start_time: 1538658367225
+status: 4
+tags: []
+user_id: alice
+`)
+	testWriteFile(t, filepath.Join(runDir, "params", "alpha"), "0.5")
+	testWriteFile(t, filepath.Join(runDir, "metrics", "loss"), "1538658367300 0.9 0\n1538658367400 0.4 1\n1538658367350 0.7 0\n")
+	testWriteFile(t, filepath.Join(runDir, "tags", "mlflow.source.name"), "train.py")
+	testWriteFile(t, filepath.Join(runDir, "tags", "stage"), "dev")
+	testWriteFile(t, filepath.Join(runDir, "artifacts", "model", "model.pkl"), "...")
+	testWriteFile(t, filepath.Join(runDir, "artifacts", "plot.png"), "...")
+
+	deleted := filepath.Join(root, "1", "00000000000000000000000000000000")
+	testWriteFile(t, filepath.Join(deleted, "meta.yaml"), "lifecycle_stage: deleted\n")
+
+	runs, err := Import(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 run, got %d", len(runs))
+	}
+	testEqStr(t, runs[0].ExperimentName, "legacy")
+
+	cm := runs[0].Commit
+	testEqStr(t, cm.SubmitterID, "alice")
+	run := cm.Runs[0]
+	testEqStr(t, run.RunID, "5f1f0b6a-9a1c-4b4e-8b1e-1c2d3e4f5a6b")
+	testEqStr(t, run.WorkloadFile, "train.py")
+	testEqStr(t, run.Parameters["alpha"], "0.5")
+	testEqStr(t, run.Summary["loss"], "0.4")
+	testEqStr(t, run.Labels["stage"], "dev")
+	testEqStrs(t, run.WorkspaceOutputFiles, []string{"model/model.pkl", "plot.png"})
+	if run.Success || run.ErrorMessage == nil {
+		t.Errorf("Expected a failed run, got %#v", run)
+	}
+	if !run.ExecStart.Equal(time.Date(2018, 10, 4, 13, 6, 7, 225000000, time.UTC)) {
+		t.Errorf("Unexpected start %v", run.ExecStart)
+	}
+
+	testEqStr(t, runs[0].Metadata["runs"], "[\"5f1f0b6a-9a1c-4b4e-8b1e-1c2d3e4f5a6b\"]")
+	testEqStr(t, runs[0].Metadata["run.5f1f0b6a-9a1c-4b4e-8b1e-1c2d3e4f5a6b.output-files"], "[\"model/model.pkl\",\"plot.png\"]")
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	root, err := ioutil.TempDir("", "mlruns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	commits := testCommits()
+	err = Exporter{Root: root}.Export(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := Import(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(runs))
+	}
+
+	// The failed run has no start time, so sorts first
+	failed, succeeded := runs[0].Commit.Runs[0], runs[1].Commit.Runs[0]
+	testEqStr(t, failed.RunID, "cd351be8-3ba9-4c5e-ad26-429d6d6033de")
+	if failed.ErrorMessage == nil || *failed.ErrorMessage != "out of memory" {
+		t.Errorf("Expected error message to survive, got %#v", failed.ErrorMessage)
+	}
+
+	want := commits[0].Runs[0]
+	testEqStr(t, succeeded.RunID, want.RunID)
+	testEqStr(t, succeeded.WorkloadFile, want.WorkloadFile)
+	testEqMap(t, succeeded.Parameters, want.Parameters)
+	testEqMap(t, succeeded.Summary, want.Summary)
+	testEqMap(t, succeeded.Labels, want.Labels)
+	testEqStr(t, runs[1].Commit.WorkloadImage, "busybox")
+	if !succeeded.ExecEnd.Equal(want.ExecEnd) {
+		t.Errorf("Wanted end %v, got %v", want.ExecEnd, succeeded.ExecEnd)
+	}
+}
+
+func TestImportRunningRun(t *testing.T) {
+	root, err := ioutil.TempDir("", "mlruns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	testWriteFile(t, filepath.Join(root, "0", "meta.yaml"), "experiment_id: '0'\nlifecycle_stage: active\nname: Default\n")
+	runDir := filepath.Join(root, "0", "5f1f0b6a9a1c4b4e8b1e1c2d3e4f5a6b")
+	testWriteFile(t, filepath.Join(runDir, "meta.yaml"), "lifecycle_stage: active\nstart_time: 1538658367225\nend_time: null\nstatus: 1\n")
+	testWriteFile(t, filepath.Join(runDir, "tags", TagAuthority), "derived")
+
+	runs, err := Import(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 run, got %d", len(runs))
+	}
+	run := runs[0].Commit.Runs[0]
+	if run.Success || run.ErrorMessage == nil || *run.ErrorMessage != "MLflow run RUNNING" {
+		t.Errorf("Expected a running run to be imported as failed, got %#v", run)
+	}
+	if run.Authority != metadata.RunAuthority_Derived {
+		t.Errorf("Wanted a derived run, got %#v", run.Authority)
+	}
+}
@@ -0,0 +1,283 @@
+package openlineage
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+const (
+	DefaultProducer  = "https://github.com/dotmesh-io/dotscience-metadata"
+	DefaultNamespace = "dotscience"
+
+	RunEventSchemaURL = "https://openlineage.io/spec/1-0-5/OpenLineage.json#/definitions/RunEvent"
+
+	ErrorMessageFacetSchemaURL   = "https://openlineage.io/spec/facets/1-0-0/ErrorMessageRunFacet.json#/$defs/ErrorMessageRunFacet"
+	DatasetVersionFacetSchemaURL = "https://openlineage.io/spec/facets/1-0-0/DatasetVersionDatasetFacet.json#/$defs/DatasetVersionDatasetFacet"
+
+	// Custom facets have no published schema, so they point back at this
+	// package.
+	customFacetSchemaURL = "https://github.com/dotmesh-io/dotscience-metadata/pkg/openlineage#"
+)
+
+// type EventType is the lifecycle transition an event describes.
+type EventType string
+
+const (
+	EventType_Start    EventType = "START"
+	EventType_Complete EventType = "COMPLETE"
+	EventType_Fail     EventType = "FAIL"
+)
+
+// type Facet is a single OpenLineage facet. Every facet carries the
+// _producer and _schemaURL fields; the remaining fields depend on the
+// facet.
+type Facet map[string]interface{}
+
+// type Run identifies the run an event belongs to.
+type Run struct {
+	RunID  string           `json:"runId"`
+	Facets map[string]Facet `json:"facets,omitempty"`
+}
+
+// type Job identifies the job that the run is an instance of.
+type Job struct {
+	Namespace string           `json:"namespace"`
+	Name      string           `json:"name"`
+	Facets    map[string]Facet `json:"facets,omitempty"`
+}
+
+// type Dataset is an input or output dataset of a run.
+type Dataset struct {
+	Namespace string           `json:"namespace"`
+	Name      string           `json:"name"`
+	Facets    map[string]Facet `json:"facets,omitempty"`
+}
+
+// type RunEvent is an OpenLineage RunEvent, ready to be marshalled as JSON
+// and posted to a collector.
+type RunEvent struct {
+	EventType EventType `json:"eventType"`
+	EventTime time.Time `json:"eventTime"`
+	Run       Run       `json:"run"`
+	Job       Job       `json:"job"`
+	Inputs    []Dataset `json:"inputs"`
+	Outputs   []Dataset `json:"outputs"`
+	Producer  string    `json:"producer"`
+	SchemaURL string    `json:"schemaURL"`
+}
+
+// type Options controls how metadata is mapped onto OpenLineage names.
+type Options struct {
+	// Namespace is used for jobs and datasets. Defaults to DefaultNamespace.
+	Namespace string
+
+	// Producer is the URI recorded as the producer of every event and
+	// facet. Defaults to DefaultProducer.
+	Producer string
+
+	// WorkspaceDotID is the ID of the workspace dot the commit belongs to;
+	// workspace files are named relative to it. If empty, workspace files
+	// are named relative to "workspace".
+	WorkspaceDotID string
+
+	// CommitID is the ID of the workspace commit, recorded in a run facet
+	// when set.
+	CommitID string
+}
+
+func (o Options) namespace() string {
+	if o.Namespace == "" {
+		return DefaultNamespace
+	}
+	return o.Namespace
+}
+
+func (o Options) producer() string {
+	if o.Producer == "" {
+		return DefaultProducer
+	}
+	return o.Producer
+}
+
+func (o Options) workspace() string {
+	if o.WorkspaceDotID == "" {
+		return "workspace"
+	}
+	return o.WorkspaceDotID
+}
+
+func (o Options) facet(schemaURL string, fields map[string]interface{}) Facet {
+	f := Facet{
+		"_producer":  o.producer(),
+		"_schemaURL": schemaURL,
+	}
+	for k, v := range fields {
+		f[k] = v
+	}
+	return f
+}
+
+// ConvertRun converts a single run, in the context of the commit it belongs
+// to, into a START event followed by a COMPLETE or FAIL event. Times the
+// run doesn't record are taken from the commit, and a missing start or end
+// time from the other. OpenLineage requires event times, so a run with no
+// times at all, in the run or the commit, has no events.
+func ConvertRun(cm metadata.CommitMetadata, run metadata.RunMetadata, opts Options) []RunEvent {
+	start := firstTime(run.ExecStart, cm.ExecStart, run.ExecEnd, cm.ExecEnd)
+	end := firstTime(run.ExecEnd, cm.ExecEnd, start)
+	if start.IsZero() {
+		return nil
+	}
+
+	job := Job{
+		Namespace: opts.namespace(),
+		Name:      jobName(run),
+	}
+
+	runFacets := map[string]Facet{}
+	if len(run.Parameters) > 0 {
+		runFacets["dotscience_parameters"] = opts.facet(customFacetSchemaURL+"ParametersRunFacet", map[string]interface{}{
+			"parameters": run.Parameters,
+		})
+	}
+	if len(run.Summary) > 0 {
+		runFacets["dotscience_summary"] = opts.facet(customFacetSchemaURL+"SummaryRunFacet", map[string]interface{}{
+			"summary": run.Summary,
+		})
+	}
+	if opts.CommitID != "" {
+		fields := map[string]interface{}{"commitId": opts.CommitID}
+		if opts.WorkspaceDotID != "" {
+			fields["workspace"] = opts.WorkspaceDotID
+		}
+		runFacets["dotscience_commit"] = opts.facet(customFacetSchemaURL+"CommitRunFacet", fields)
+	}
+
+	startEvent := RunEvent{
+		EventType: EventType_Start,
+		EventTime: start,
+		Run:       Run{RunID: run.RunID, Facets: runFacets},
+		Job:       job,
+		Inputs:    inputDatasets(cm, run, opts),
+		Outputs:   []Dataset{},
+		Producer:  opts.producer(),
+		SchemaURL: RunEventSchemaURL,
+	}
+
+	endFacets := map[string]Facet{}
+	for k, v := range runFacets {
+		endFacets[k] = v
+	}
+	endType := EventType_Complete
+	if !run.Success || run.ErrorMessage != nil {
+		endType = EventType_Fail
+		message := ""
+		if run.ErrorMessage != nil {
+			message = *run.ErrorMessage
+		}
+		endFacets["errorMessage"] = opts.facet(ErrorMessageFacetSchemaURL, map[string]interface{}{
+			"message":             message,
+			"programmingLanguage": "unknown",
+		})
+	}
+
+	endEvent := RunEvent{
+		EventType: endType,
+		EventTime: end,
+		Run:       Run{RunID: run.RunID, Facets: endFacets},
+		Job:       job,
+		Inputs:    startEvent.Inputs,
+		Outputs:   outputDatasets(cm, run, opts),
+		Producer:  opts.producer(),
+		SchemaURL: RunEventSchemaURL,
+	}
+
+	return []RunEvent{startEvent, endEvent}
+}
+
+// Convert converts every run in a commit into OpenLineage events, in the
+// order the runs appear in the commit.
+func Convert(cm metadata.CommitMetadata, opts Options) []RunEvent {
+	events := []RunEvent{}
+	for _, run := range cm.Runs {
+		events = append(events, ConvertRun(cm, run, opts)...)
+	}
+	return events
+}
+
+// WriteEvents writes events to w as newline-delimited JSON, one event per
+// line.
+func WriteEvents(w io.Writer, events []RunEvent) error {
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		err := enc.Encode(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstTime returns the first of times that is set, or the zero time.
+func firstTime(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func jobName(run metadata.RunMetadata) string {
+	if run.WorkloadFile != "" {
+		return run.WorkloadFile
+	}
+	return run.RunID
+}
+
+func (o Options) dataset(dot, filename, version string) Dataset {
+	ds := Dataset{
+		Namespace: o.namespace(),
+		Name:      dot + "/" + filename,
+	}
+	if version != "" {
+		ds.Facets = map[string]Facet{
+			"version": o.facet(DatasetVersionFacetSchemaURL, map[string]interface{}{
+				"datasetVersion": version,
+			}),
+		}
+	}
+	return ds
+}
+
+func inputDatasets(cm metadata.CommitMetadata, run metadata.RunMetadata, opts Options) []Dataset {
+	result := []Dataset{}
+	for _, f := range run.WorkspaceInputFiles {
+		result = append(result, opts.dataset(opts.workspace(), f.Filename, f.Version))
+	}
+	for _, name := range metadata.SortedInputDatasets(run.DatasetInputFiles) {
+		dot := string(metadata.ResolveDataset(cm.Inputs, name).ID)
+		for _, f := range run.DatasetInputFiles[name] {
+			result = append(result, opts.dataset(dot, f.Filename, f.Version))
+		}
+	}
+	return result
+}
+
+func outputDatasets(cm metadata.CommitMetadata, run metadata.RunMetadata, opts Options) []Dataset {
+	result := []Dataset{}
+	for _, f := range run.WorkspaceOutputFiles {
+		result = append(result, opts.dataset(opts.workspace(), f, opts.CommitID))
+	}
+	for _, name := range metadata.SortedOutputDatasets(run.DatasetOutputFiles) {
+		dsv := metadata.ResolveDataset(cm.Outputs, name)
+		dot, version := string(dsv.ID), dsv.Version
+		for _, f := range run.DatasetOutputFiles[name] {
+			result = append(result, opts.dataset(dot, f, version))
+		}
+	}
+	return result
+}
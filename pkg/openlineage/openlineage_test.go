@@ -0,0 +1,132 @@
+package openlineage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testCommit() metadata.CommitMetadata {
+	oops := "division by zero"
+	return metadata.CommitMetadata{
+		Success:   true,
+		ExecStart: time.Date(2018, 10, 4, 13, 6, 7, 0, time.UTC),
+		ExecEnd:   time.Date(2018, 10, 4, 13, 6, 10, 0, time.UTC),
+		Inputs: map[string]metadata.DatasetVersion{
+			"b": metadata.DatasetVersion{ID: "dot-b", Version: "b1"},
+		},
+		Outputs: map[string]metadata.DatasetVersion{
+			"d": metadata.DatasetVersion{ID: "dot-d", Version: "d2"},
+		},
+		Runs: []metadata.RunMetadata{
+			{
+				RunID:                "02ecdc67-c49e-4d76-abe8-1ee13f2884b7",
+				Success:              true,
+				WorkloadFile:         "train.py",
+				WorkspaceInputFiles:  []metadata.InputFile{{Filename: "foo.csv", Version: "w0"}},
+				WorkspaceOutputFiles: []string{"log.txt"},
+				DatasetInputFiles:    map[string][]metadata.InputFile{"b": {{Filename: "input.csv", Version: "b1"}}},
+				DatasetOutputFiles:   map[string][]string{"d": {"output.csv"}},
+				Parameters:           map[string]string{"smoothing": "1.0"},
+				Summary:              map[string]string{"rms_error": "0.057"},
+				ExecStart:            time.Date(2018, 10, 4, 13, 6, 7, 225000000, time.UTC),
+				ExecEnd:              time.Date(2018, 10, 4, 13, 6, 8, 225000000, time.UTC),
+			},
+			{
+				RunID:        "cd351be8-3ba9-4c5e-ad26-429d6d6033de",
+				Success:      false,
+				ErrorMessage: &oops,
+			},
+		},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	events := Convert(testCommit(), Options{WorkspaceDotID: "dot-a", CommitID: "c1"})
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+
+	start, end := events[0], events[1]
+	if start.EventType != EventType_Start || end.EventType != EventType_Complete {
+		t.Errorf("Wanted START/COMPLETE, got %s/%s", start.EventType, end.EventType)
+	}
+	if start.Job.Name != "train.py" || start.Job.Namespace != DefaultNamespace {
+		t.Errorf("Unexpected job %#v", start.Job)
+	}
+	if !start.EventTime.Equal(time.Date(2018, 10, 4, 13, 6, 7, 225000000, time.UTC)) {
+		t.Errorf("Unexpected start time %v", start.EventTime)
+	}
+	if len(start.Inputs) != 2 || start.Inputs[0].Name != "dot-a/foo.csv" || start.Inputs[1].Name != "dot-b/input.csv" {
+		t.Errorf("Unexpected inputs %#v", start.Inputs)
+	}
+	if len(end.Outputs) != 2 || end.Outputs[0].Name != "dot-a/log.txt" || end.Outputs[1].Name != "dot-d/output.csv" {
+		t.Errorf("Unexpected outputs %#v", end.Outputs)
+	}
+	if v := end.Outputs[1].Facets["version"]["datasetVersion"]; v != "d2" {
+		t.Errorf("Wanted output version d2, got %v", v)
+	}
+	if _, ok := end.Run.Facets["dotscience_parameters"]; !ok {
+		t.Errorf("Missing parameters facet in %#v", end.Run.Facets)
+	}
+
+	fail := events[3]
+	if fail.EventType != EventType_Fail {
+		t.Errorf("Wanted FAIL, got %s", fail.EventType)
+	}
+	if m := fail.Run.Facets["errorMessage"]["message"]; m != "division by zero" {
+		t.Errorf("Wanted error message, got %v", m)
+	}
+	if !fail.EventTime.Equal(time.Date(2018, 10, 4, 13, 6, 10, 0, time.UTC)) {
+		t.Errorf("Expected commit end time as fallback, got %v", fail.EventTime)
+	}
+}
+
+func TestWriteEvents(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteEvents(&buf, Convert(testCommit(), Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e map[string]interface{}
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			t.Errorf("Line %d is not JSON: %s", lines, err)
+		}
+		if e["schemaURL"] != RunEventSchemaURL {
+			t.Errorf("Unexpected schemaURL %v", e["schemaURL"])
+		}
+		lines++
+	}
+	if lines != 4 {
+		t.Errorf("Wanted 4 lines, got %d", lines)
+	}
+}
+
+func TestConvertTimes(t *testing.T) {
+	end := time.Date(2018, 10, 4, 13, 6, 10, 0, time.UTC)
+	cm := metadata.CommitMetadata{
+		ExecEnd: end,
+		Runs:    []metadata.RunMetadata{{RunID: "r1", Success: true}},
+	}
+	events := Convert(cm, Options{CommitID: "c1"})
+	if len(events) != 2 || !events[0].EventTime.Equal(end) || !events[1].EventTime.Equal(end) {
+		t.Errorf("Expected both events at the commit end time, got %#v", events)
+	}
+	if _, ok := events[0].Run.Facets["dotscience_commit"]["workspace"]; ok {
+		t.Errorf("Expected no workspace in the commit facet, got %#v", events[0].Run.Facets["dotscience_commit"])
+	}
+
+	cm.ExecEnd = time.Time{}
+	if events := Convert(cm, Options{}); len(events) != 0 {
+		t.Errorf("Expected no events for a run with no times, got %#v", events)
+	}
+}
@@ -0,0 +1,315 @@
+package provenance
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+const (
+	StatementType       = "https://in-toto.io/Statement/v0.1"
+	PredicateTypeSLSA02 = "https://slsa.dev/provenance/v0.2"
+
+	// BuildType identifies the structure of the invocation recorded by this
+	// package.
+	BuildType = "https://github.com/dotmesh-io/dotscience-metadata/provenance/run@v1"
+
+	// DigestDotmeshCommit is the digest algorithm name used for dotmesh
+	// commit IDs, which identify the content of a dot at a point in time.
+	DigestDotmeshCommit = "dotmeshCommit"
+)
+
+// type DigestSet maps digest algorithms to hex-encoded digests.
+type DigestSet map[string]string
+
+// type Subject is an artifact that a statement is about.
+type Subject struct {
+	Name   string    `json:"name"`
+	Digest DigestSet `json:"digest"`
+}
+
+// type Statement is an in-toto attestation statement.
+type Statement struct {
+	Type          string        `json:"_type"`
+	Subject       []Subject     `json:"subject"`
+	PredicateType string        `json:"predicateType"`
+	Predicate     SLSAPredicate `json:"predicate"`
+}
+
+// type SLSAPredicate is a SLSA v0.2 provenance predicate.
+type SLSAPredicate struct {
+	Builder    Builder    `json:"builder"`
+	BuildType  string     `json:"buildType"`
+	Invocation Invocation `json:"invocation"`
+	Metadata   *Metadata  `json:"metadata,omitempty"`
+	Materials  []Material `json:"materials,omitempty"`
+}
+
+// type Builder identifies the platform that ran the workload.
+type Builder struct {
+	ID string `json:"id"`
+}
+
+// type ConfigSource records the workload image that defined the run.
+type ConfigSource struct {
+	URI        string    `json:"uri,omitempty"`
+	Digest     DigestSet `json:"digest,omitempty"`
+	EntryPoint string    `json:"entryPoint,omitempty"`
+}
+
+// type Invocation records how the run was started.
+type Invocation struct {
+	ConfigSource ConfigSource           `json:"configSource"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	Environment  map[string]interface{} `json:"environment,omitempty"`
+}
+
+// type Completeness records which parts of the provenance are known to be
+// complete.
+type Completeness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+// type Metadata records when the run happened.
+type Metadata struct {
+	BuildInvocationID string       `json:"buildInvocationId,omitempty"`
+	BuildStartedOn    *time.Time   `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn   *time.Time   `json:"buildFinishedOn,omitempty"`
+	Completeness      Completeness `json:"completeness"`
+	Reproducible      bool         `json:"reproducible"`
+}
+
+// type Material is an input to the run.
+type Material struct {
+	URI    string    `json:"uri"`
+	Digest DigestSet `json:"digest,omitempty"`
+}
+
+// type Options supplies context that the commit metadata does not record.
+type Options struct {
+	// WorkspaceDotID and CommitID identify the workspace dot and the commit
+	// being attested. The commit ID is used as the digest of workspace
+	// output files.
+	WorkspaceDotID string
+	CommitID       string
+
+	// BuilderID overrides the builder ID derived from the runner.
+	BuilderID string
+
+	// Digests, if set, is consulted for additional digests (such as a
+	// sha256 of the file content) of each subject and material, by URI.
+	Digests func(uri string) DigestSet
+}
+
+// DotURI returns the URI used for a file in a dot, or for the dot itself if
+// filename is empty.
+func DotURI(dot, filename string) string {
+	u := "dotmesh://" + url.PathEscape(dot)
+	if filename != "" {
+		u += "/" + strings.TrimPrefix(filename, "/")
+	}
+	return u
+}
+
+// BuilderID returns the builder ID recorded for a commit's runner.
+func BuilderID(cm metadata.CommitMetadata) string {
+	id := "urn:dotscience:runner:" + url.PathEscape(cm.RunnerName)
+	if cm.RunnerVersion != "" {
+		id += "@" + url.PathEscape(cm.RunnerVersion)
+	}
+	return id
+}
+
+func (o Options) digest(uri string, base DigestSet) DigestSet {
+	result := DigestSet{}
+	for k, v := range base {
+		if v != "" {
+			result[k] = v
+		}
+	}
+	if o.Digests != nil {
+		for k, v := range o.Digests(uri) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// Generate returns a provenance statement for each successful workload run
+// in the commit. Each statement's subjects are the workspace and dataset
+// files written by the run, and the versions of the output datasets it
+// wrote to. Correction runs, failed runs and runs with no outputs are
+// skipped, as there is nothing trustworthy to attest.
+func Generate(cm metadata.CommitMetadata, opts Options) []Statement {
+	result := []Statement{}
+	for _, run := range cm.Runs {
+		if run.Authority == metadata.RunAuthority_Correction || !run.Success || run.ErrorMessage != nil {
+			continue
+		}
+		subjects := runSubjects(cm, run, opts)
+		if len(subjects) == 0 {
+			continue
+		}
+		result = append(result, Statement{
+			Type:          StatementType,
+			Subject:       subjects,
+			PredicateType: PredicateTypeSLSA02,
+			Predicate:     runPredicate(cm, run, opts),
+		})
+	}
+	return result
+}
+
+func runSubjects(cm metadata.CommitMetadata, run metadata.RunMetadata, opts Options) []Subject {
+	subjects := []Subject{}
+	for _, f := range run.WorkspaceOutputFiles {
+		uri := DotURI(opts.WorkspaceDotID, f)
+		subjects = append(subjects, Subject{Name: uri, Digest: opts.digest(uri, DigestSet{DigestDotmeshCommit: opts.CommitID})})
+	}
+	for _, name := range metadata.SortedOutputDatasets(run.DatasetOutputFiles) {
+		dsv := metadata.ResolveDataset(cm.Outputs, name)
+		dot, version := string(dsv.ID), dsv.Version
+		uri := DotURI(dot, "")
+		subjects = append(subjects, Subject{Name: uri, Digest: opts.digest(uri, DigestSet{DigestDotmeshCommit: version})})
+		for _, f := range run.DatasetOutputFiles[name] {
+			uri := DotURI(dot, f)
+			subjects = append(subjects, Subject{Name: uri, Digest: opts.digest(uri, DigestSet{DigestDotmeshCommit: version})})
+		}
+	}
+
+	// Subjects need at least one digest to be verifiable
+	withDigests := []Subject{}
+	for _, s := range subjects {
+		if len(s.Digest) > 0 {
+			withDigests = append(withDigests, s)
+		}
+	}
+	return withDigests
+}
+
+func runPredicate(cm metadata.CommitMetadata, run metadata.RunMetadata, opts Options) SLSAPredicate {
+	builderID := opts.BuilderID
+	if builderID == "" {
+		builderID = BuilderID(cm)
+	}
+
+	params := map[string]interface{}{}
+	if len(cm.WorkloadCommand) > 0 {
+		params["command"] = cm.WorkloadCommand
+	}
+	if len(run.Parameters) > 0 {
+		params["parameters"] = run.Parameters
+	}
+
+	env := map[string]interface{}{}
+	if len(cm.WorkloadEnvironment) > 0 {
+		env["variables"] = cm.WorkloadEnvironment
+	}
+	if cm.RunnerPlatform != "" {
+		env["platform"] = cm.RunnerPlatform
+	}
+	if cm.RunnerPlatformVersion != "" {
+		env["platformVersion"] = cm.RunnerPlatformVersion
+	}
+	if cm.WorkloadType != "" {
+		env["workloadType"] = cm.WorkloadType
+	}
+
+	md := &Metadata{
+		BuildInvocationID: run.RunID,
+		Completeness: Completeness{
+			Parameters:  true,
+			Environment: len(cm.WorkloadEnvironment) > 0,
+			Materials:   false,
+		},
+	}
+	if !run.ExecStart.IsZero() {
+		t := run.ExecStart.UTC()
+		md.BuildStartedOn = &t
+	}
+	if !run.ExecEnd.IsZero() {
+		t := run.ExecEnd.UTC()
+		md.BuildFinishedOn = &t
+	}
+
+	return SLSAPredicate{
+		Builder:   Builder{ID: builderID},
+		BuildType: BuildType,
+		Invocation: Invocation{
+			ConfigSource: ConfigSource{
+				URI:        imageURI(cm.WorkloadImage),
+				Digest:     imageDigest(cm.WorkloadImageHash),
+				EntryPoint: run.WorkloadFile,
+			},
+			Parameters:  params,
+			Environment: env,
+		},
+		Metadata:  md,
+		Materials: runMaterials(cm, run, opts),
+	}
+}
+
+func runMaterials(cm metadata.CommitMetadata, run metadata.RunMetadata, opts Options) []Material {
+	materials := []Material{}
+	if cm.WorkloadImage != "" {
+		materials = append(materials, Material{
+			URI:    imageURI(cm.WorkloadImage),
+			Digest: imageDigest(cm.WorkloadImageHash),
+		})
+	}
+	for _, f := range run.WorkspaceInputFiles {
+		uri := DotURI(opts.WorkspaceDotID, f.Filename)
+		materials = append(materials, Material{URI: uri, Digest: opts.digest(uri, DigestSet{DigestDotmeshCommit: f.Version})})
+	}
+	for _, name := range metadata.SortedInputDatasets(run.DatasetInputFiles) {
+		dsv := metadata.ResolveDataset(cm.Inputs, name)
+		dot, version := string(dsv.ID), dsv.Version
+		uri := DotURI(dot, "")
+		materials = append(materials, Material{URI: uri, Digest: opts.digest(uri, DigestSet{DigestDotmeshCommit: version})})
+		for _, f := range run.DatasetInputFiles[name] {
+			uri := DotURI(dot, f.Filename)
+			materials = append(materials, Material{URI: uri, Digest: opts.digest(uri, DigestSet{DigestDotmeshCommit: f.Version})})
+		}
+	}
+	return materials
+}
+
+func imageURI(image string) string {
+	if image == "" {
+		return ""
+	}
+	return "docker://" + image
+}
+
+// imageDigest extracts the digest from a pinned image reference such as
+// busybox@sha256:2a03...
+func imageDigest(hash string) DigestSet {
+	idx := strings.LastIndex(hash, "@")
+	if idx < 0 {
+		return nil
+	}
+	parts := strings.SplitN(hash[idx+1:], ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	return DigestSet{parts[0]: parts[1]}
+}
+
+// WriteStatements writes statements to w as newline-delimited JSON, the
+// format used for in-toto attestation bundles.
+func WriteStatements(w io.Writer, statements []Statement) error {
+	enc := json.NewEncoder(w)
+	for _, s := range statements {
+		err := enc.Encode(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
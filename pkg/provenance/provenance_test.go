@@ -0,0 +1,102 @@
+package provenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testCommit() metadata.CommitMetadata {
+	oops := "failed"
+	return metadata.CommitMetadata{
+		WorkloadImage:       "busybox",
+		WorkloadImageHash:   "busybox@sha256:2a03a6059f21e150ae84b0973863609494aad70f0a80eaeb64bddd8d92465812",
+		WorkloadCommand:     []string{"sh", "-c", "python train.py"},
+		WorkloadEnvironment: map[string]string{"DEBUG_MODE": "YES"},
+		RunnerName:          "bob",
+		RunnerVersion:       "Runner rev. 63db3d0",
+		Inputs:              map[string]metadata.DatasetVersion{"b": {ID: "dot-b", Version: "b1"}},
+		Outputs:             map[string]metadata.DatasetVersion{"d": {ID: "dot-d", Version: "d2"}},
+		Runs: []metadata.RunMetadata{
+			{
+				RunID:                "r1",
+				Success:              true,
+				WorkspaceInputFiles:  []metadata.InputFile{{Filename: "foo.csv", Version: "w0"}},
+				DatasetInputFiles:    map[string][]metadata.InputFile{"b": {{Filename: "input.csv", Version: "b0"}}},
+				WorkspaceOutputFiles: []string{"model.pkl"},
+				DatasetOutputFiles:   map[string][]string{"d": {"output.csv"}},
+				Parameters:           map[string]string{"alpha": "0.5"},
+				ExecStart:            time.Date(2018, 10, 4, 13, 6, 7, 0, time.UTC),
+				ExecEnd:              time.Date(2018, 10, 4, 13, 6, 8, 0, time.UTC),
+			},
+			{RunID: "r2", ErrorMessage: &oops, WorkspaceOutputFiles: []string{"x"}},
+			{RunID: "r3", Success: true, Authority: metadata.RunAuthority_Correction, WorkspaceOutputFiles: []string{"y"}},
+		},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	statements := Generate(testCommit(), Options{
+		WorkspaceDotID: "dot-a",
+		CommitID:       "c1",
+		Digests: func(uri string) DigestSet {
+			if uri == "dotmesh://dot-a/model.pkl" {
+				return DigestSet{"sha256": "abc"}
+			}
+			return nil
+		},
+	})
+	if len(statements) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(statements))
+	}
+	s := statements[0]
+	if s.Type != StatementType || s.PredicateType != PredicateTypeSLSA02 {
+		t.Errorf("Unexpected statement header %#v", s)
+	}
+
+	if len(s.Subject) != 3 {
+		t.Fatalf("Expected 3 subjects, got %#v", s.Subject)
+	}
+	if s.Subject[0].Name != "dotmesh://dot-a/model.pkl" || s.Subject[0].Digest["sha256"] != "abc" || s.Subject[0].Digest[DigestDotmeshCommit] != "c1" {
+		t.Errorf("Unexpected subject %#v", s.Subject[0])
+	}
+	if s.Subject[1].Name != "dotmesh://dot-d" || s.Subject[1].Digest[DigestDotmeshCommit] != "d2" {
+		t.Errorf("Unexpected dataset version subject %#v", s.Subject[1])
+	}
+
+	p := s.Predicate
+	if p.Builder.ID != "urn:dotscience:runner:bob@Runner%20rev.%2063db3d0" {
+		t.Errorf("Unexpected builder %s", p.Builder.ID)
+	}
+	if p.Invocation.ConfigSource.Digest["sha256"] != "2a03a6059f21e150ae84b0973863609494aad70f0a80eaeb64bddd8d92465812" {
+		t.Errorf("Unexpected config source %#v", p.Invocation.ConfigSource)
+	}
+	if p.Metadata.BuildInvocationID != "r1" || p.Metadata.BuildStartedOn == nil {
+		t.Errorf("Unexpected metadata %#v", p.Metadata)
+	}
+
+	// The image, a workspace file, a dataset version and a dataset file
+	if len(p.Materials) != 4 {
+		t.Fatalf("Expected 4 materials, got %#v", p.Materials)
+	}
+	if p.Materials[3].URI != "dotmesh://dot-b/input.csv" || p.Materials[3].Digest[DigestDotmeshCommit] != "b0" {
+		t.Errorf("Unexpected material %#v", p.Materials[3])
+	}
+
+	var buf bytes.Buffer
+	err := WriteStatements(&buf, statements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded["_type"] != StatementType {
+		t.Errorf("Unexpected JSON %s", buf.String())
+	}
+}
@@ -0,0 +1,566 @@
+package rocrate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+const (
+	MetadataFilename = "ro-crate-metadata.json"
+
+	ContextURL = "https://w3id.org/ro/crate/1.1/context"
+	SpecURL    = "https://w3id.org/ro/crate/1.1"
+
+	completedStatus = "http://schema.org/CompletedActionStatus"
+	failedStatus    = "http://schema.org/FailedActionStatus"
+)
+
+// Directories within the crate that files are packaged under.
+const (
+	WorkspacePrefix = "workspace/"
+	DatasetsPrefix  = "datasets/"
+	LogsPrefix      = "logs/"
+)
+
+// type Entity is a single node of the crate's JSON-LD graph.
+type Entity map[string]interface{}
+
+func ref(id string) Entity {
+	return Entity{"@id": id}
+}
+
+// type Options controls how a crate is built.
+type Options struct {
+	// Name and Description describe the crate as a whole. Name defaults to
+	// a description of the commit.
+	Name        string
+	Description string
+
+	// License is a URL or SPDX identifier for the crate's contents.
+	License string
+
+	// DatasetDirs maps dataset names, as used in the commit's Inputs and
+	// Outputs, onto local directories. Datasets that are not listed are
+	// looked for in a subdirectory of the workspace directory with the
+	// dataset's name.
+	DatasetDirs map[string]string
+
+	// AllowMissing describes files that are not present locally, without
+	// packaging them, rather than failing. As they are not in the crate,
+	// their identifiers are their crate paths prefixed with "#".
+	AllowMissing bool
+}
+
+// type Crate is an RO-Crate describing a single commit, along with the
+// local files that it packages.
+type Crate struct {
+	Graph []Entity
+
+	// Files maps paths within the crate to local paths.
+	Files map[string]string
+
+	// modTime stamps the metadata file in archives, so that the same
+	// commit always gives the same archive.
+	modTime time.Time
+}
+
+type fileUse struct {
+	ref     Entity
+	local   string
+	version string
+	input   bool
+	output  bool
+}
+
+type builder struct {
+	cm    metadata.CommitMetadata
+	dir   string
+	opts  Options
+	files map[string]*fileUse
+	other []Entity
+	seen  map[string]bool
+}
+
+// Build describes a commit as an RO-Crate. Each run becomes a CreateAction
+// whose instrument is the workload image, whose object is its input files,
+// parameters and the workload command, and whose result is its output files
+// and summary. Workspace files and exec logs are read from dir.
+func Build(cm metadata.CommitMetadata, dir string, opts Options) (*Crate, error) {
+	b := &builder{
+		cm:    cm,
+		dir:   dir,
+		opts:  opts,
+		files: map[string]*fileUse{},
+		seen:  map[string]bool{},
+	}
+
+	actions := []Entity{}
+	for _, run := range cm.Runs {
+		for name := range run.DatasetInputFiles {
+			if err := checkDatasetName(name); err != nil {
+				return nil, err
+			}
+		}
+		for name := range run.DatasetOutputFiles {
+			if err := checkDatasetName(name); err != nil {
+				return nil, err
+			}
+		}
+		actions = append(actions, b.action(run))
+	}
+
+	for _, log := range cm.ExecLogs {
+		b.files[LogsPrefix+cleanPath(log)] = &fileUse{local: localPath(dir, log)}
+	}
+
+	ids := make([]string, 0, len(b.files))
+	for id := range b.files {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	crate := &Crate{Files: map[string]string{}, modTime: b.commitTime()}
+	if crate.modTime.IsZero() {
+		crate.modTime = time.Unix(0, 0)
+	}
+	fileEntities := []Entity{}
+	hasPart := []Entity{}
+	for _, id := range ids {
+		use := b.files[id]
+		entity := Entity{
+			"@id":   id,
+			"@type": "File",
+			"name":  path.Base(id),
+		}
+		if use.version != "" && !use.output {
+			entity["version"] = use.version
+		}
+		if strings.HasPrefix(id, LogsPrefix) {
+			entity["encodingFormat"] = "text/plain"
+			entity["about"] = actionRefs(cm)
+		}
+
+		info, err := os.Stat(use.local)
+		if err == nil && info.Mode().IsRegular() {
+			entity["contentSize"] = strconv.FormatInt(info.Size(), 10)
+			entity["dateModified"] = info.ModTime().UTC().Format(time.RFC3339)
+			crate.Files[id] = use.local
+			hasPart = append(hasPart, ref(id))
+		} else if opts.AllowMissing {
+			// References to the file, from the runs, change with it
+			entity["@id"] = "#" + id
+			use.ref["@id"] = "#" + id
+		} else {
+			if err == nil {
+				err = fmt.Errorf("not a regular file")
+			}
+			return nil, fmt.Errorf("%s: %s", use.local, err)
+		}
+		fileEntities = append(fileEntities, entity)
+	}
+
+	root := Entity{
+		"@id":           "./",
+		"@type":         "Dataset",
+		"name":          b.name(),
+		"datePublished": b.published(),
+		"hasPart":       hasPart,
+		"mentions":      actionRefs(cm),
+	}
+	if opts.Description != "" {
+		root["description"] = opts.Description
+	} else if cm.Message != "" {
+		root["description"] = cm.Message
+	}
+	if opts.License != "" {
+		root["license"] = opts.License
+	}
+
+	crate.Graph = append(crate.Graph,
+		Entity{
+			"@id":        MetadataFilename,
+			"@type":      "CreativeWork",
+			"conformsTo": ref(SpecURL),
+			"about":      ref("./"),
+		},
+		root,
+	)
+	crate.Graph = append(crate.Graph, actions...)
+	crate.Graph = append(crate.Graph, b.other...)
+	crate.Graph = append(crate.Graph, fileEntities...)
+	return crate, nil
+}
+
+func (b *builder) name() string {
+	if b.opts.Name != "" {
+		return b.opts.Name
+	}
+	if b.cm.WorkloadImage != "" {
+		return fmt.Sprintf("Dotscience commit of %d runs of %s", len(b.cm.Runs), b.cm.WorkloadImage)
+	}
+	return fmt.Sprintf("Dotscience commit of %d runs", len(b.cm.Runs))
+}
+
+func (b *builder) published() string {
+	t := b.commitTime()
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// commitTime returns when the commit was made, as near as is known, or the
+// zero time.
+func (b *builder) commitTime() time.Time {
+	if !b.cm.ExecEnd.IsZero() {
+		return b.cm.ExecEnd
+	}
+	return b.cm.ExecStart
+}
+
+func actionRefs(cm metadata.CommitMetadata) []Entity {
+	result := make([]Entity, len(cm.Runs))
+	for idx, run := range cm.Runs {
+		result[idx] = ref(actionID(run))
+	}
+	return result
+}
+
+func actionID(run metadata.RunMetadata) string {
+	return "#run-" + run.RunID
+}
+
+// addOther adds a contextual entity to the graph, once.
+func (b *builder) addOther(e Entity) Entity {
+	id := e["@id"].(string)
+	if !b.seen[id] {
+		b.seen[id] = true
+		b.other = append(b.other, e)
+	}
+	return ref(id)
+}
+
+// checkDatasetName rejects dataset names that can't be used as a directory
+// name in the crate, or in the workspace directory, without escaping it.
+func checkDatasetName(name string) error {
+	if name == "" || name == "." || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid dataset name %q", name)
+	}
+	return nil
+}
+
+func (b *builder) datasetDir(name string) string {
+	if dir, ok := b.opts.DatasetDirs[name]; ok {
+		return dir
+	}
+	return filepath.Join(b.dir, name)
+}
+
+func (b *builder) useFile(id, local, version string, output bool) Entity {
+	use, ok := b.files[id]
+	if !ok {
+		use = &fileUse{ref: ref(id), local: local}
+		b.files[id] = use
+	}
+	if output {
+		use.output = true
+	} else {
+		use.input = true
+		if use.version == "" {
+			use.version = version
+		}
+	}
+	return use.ref
+}
+
+func (b *builder) action(run metadata.RunMetadata) Entity {
+	objects := []Entity{}
+	results := []Entity{}
+
+	for _, f := range run.WorkspaceInputFiles {
+		objects = append(objects, b.useFile(WorkspacePrefix+cleanPath(f.Filename), localPath(b.dir, f.Filename), f.Version, false))
+	}
+	for _, name := range metadata.SortedInputDatasets(run.DatasetInputFiles) {
+		for _, f := range run.DatasetInputFiles[name] {
+			objects = append(objects, b.useFile(DatasetsPrefix+name+"/"+cleanPath(f.Filename), localPath(b.datasetDir(name), f.Filename), f.Version, false))
+		}
+	}
+	for _, f := range run.WorkspaceOutputFiles {
+		results = append(results, b.useFile(WorkspacePrefix+cleanPath(f), localPath(b.dir, f), "", true))
+	}
+	for _, name := range metadata.SortedOutputDatasets(run.DatasetOutputFiles) {
+		for _, f := range run.DatasetOutputFiles[name] {
+			results = append(results, b.useFile(DatasetsPrefix+name+"/"+cleanPath(f), localPath(b.datasetDir(name), f), "", true))
+		}
+	}
+
+	if len(b.cm.WorkloadCommand) > 0 {
+		command, _ := json.Marshal(b.cm.WorkloadCommand)
+		objects = append(objects, b.addOther(Entity{
+			"@id":   "#workload-command",
+			"@type": "PropertyValue",
+			"name":  "command",
+			"value": string(command),
+		}))
+	}
+	for _, k := range metadata.SortedKeys(run.Parameters) {
+		objects = append(objects, b.addOther(Entity{
+			"@id":   "#run-" + run.RunID + "-parameter-" + k,
+			"@type": "PropertyValue",
+			"name":  k,
+			"value": run.Parameters[k],
+		}))
+	}
+	for _, k := range metadata.SortedKeys(run.Summary) {
+		results = append(results, b.addOther(Entity{
+			"@id":   "#run-" + run.RunID + "-summary-" + k,
+			"@type": "PropertyValue",
+			"name":  k,
+			"value": run.Summary[k],
+		}))
+	}
+
+	action := Entity{
+		"@id":    actionID(run),
+		"@type":  "CreateAction",
+		"name":   actionName(run),
+		"object": objects,
+		"result": results,
+	}
+	if run.Description != "" {
+		action["description"] = run.Description
+	}
+	if !run.ExecStart.IsZero() {
+		action["startTime"] = run.ExecStart.UTC().Format(time.RFC3339Nano)
+	}
+	if !run.ExecEnd.IsZero() {
+		action["endTime"] = run.ExecEnd.UTC().Format(time.RFC3339Nano)
+	}
+	if run.Success && run.ErrorMessage == nil {
+		action["actionStatus"] = ref(completedStatus)
+	} else {
+		action["actionStatus"] = ref(failedStatus)
+		if run.ErrorMessage != nil {
+			action["error"] = *run.ErrorMessage
+		}
+	}
+
+	if b.cm.WorkloadImage != "" {
+		image := Entity{
+			"@id":   "#workload-image",
+			"@type": "SoftwareApplication",
+			"name":  b.cm.WorkloadImage,
+		}
+		if b.cm.WorkloadImageHash != "" {
+			image["identifier"] = b.cm.WorkloadImageHash
+		}
+		action["instrument"] = b.addOther(image)
+	}
+	if b.cm.SubmitterID != "" {
+		action["agent"] = b.addOther(Entity{
+			"@id":        "#submitter-" + b.cm.SubmitterID,
+			"@type":      "Person",
+			"identifier": b.cm.SubmitterID,
+		})
+	}
+	return action
+}
+
+func actionName(run metadata.RunMetadata) string {
+	switch run.Authority {
+	case metadata.RunAuthority_Correction:
+		return "Correction run " + run.RunID
+	case metadata.RunAuthority_Derived:
+		return "Derived run " + run.RunID
+	}
+	if run.WorkloadFile != "" {
+		return "Run of " + run.WorkloadFile
+	}
+	return "Run " + run.RunID
+}
+
+// cleanPath normalises a recorded filename so that it stays inside the
+// crate directory it is placed in.
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+func localPath(dir, p string) string {
+	return filepath.Join(dir, filepath.FromSlash(cleanPath(p)))
+}
+
+// WriteMetadata writes the crate's ro-crate-metadata.json.
+func (c *Crate) WriteMetadata(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"@context": ContextURL,
+		"@graph":   c.Graph,
+	})
+}
+
+func (c *Crate) sortedFiles() []string {
+	ids := make([]string, 0, len(c.Files))
+	for id := range c.Files {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// WriteZip writes the crate, with its metadata file at the root and its
+// packaged files beneath it, as a zip archive.
+func (c *Crate) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	mw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     MetadataFilename,
+		Method:   zip.Deflate,
+		Modified: c.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	err = c.WriteMetadata(mw)
+	if err != nil {
+		return err
+	}
+	for _, id := range c.sortedFiles() {
+		err = zipFile(zw, id, c.Files[id])
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func zipFile(zw *zip.Writer, name, local string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// WriteTar writes the crate, with its metadata file at the root and its
+// packaged files beneath it, as a tar archive.
+func (c *Crate) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	var meta strings.Builder
+	err := c.WriteMetadata(&meta)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    MetadataFilename,
+		Mode:    0644,
+		Size:    int64(meta.Len()),
+		ModTime: c.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(tw, meta.String())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range c.sortedFiles() {
+		err = tarFile(tw, id, c.Files[id])
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func tarFile(tw *tar.Writer, name, local string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	err = tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+// Export builds a crate for a commit and writes it to archivePath, as a zip
+// archive if the path ends in ".zip" and as a tar archive otherwise. The
+// crate's metadata file is also written next to the archive.
+func Export(cm metadata.CommitMetadata, dir, archivePath string, opts Options) error {
+	crate, err := Build(cm, dir, opts)
+	if err != nil {
+		return err
+	}
+
+	mf, err := os.Create(filepath.Join(filepath.Dir(archivePath), MetadataFilename))
+	if err != nil {
+		return err
+	}
+	defer mf.Close()
+	err = crate.WriteMetadata(mf)
+	if err != nil {
+		return err
+	}
+
+	af, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer af.Close()
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		err = crate.WriteZip(af)
+	} else {
+		err = crate.WriteTar(af)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = af.Close()
+	if err != nil {
+		return err
+	}
+	return mf.Close()
+}
@@ -0,0 +1,261 @@
+package rocrate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testWriteFile(t *testing.T, path, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSetup(t *testing.T) (string, metadata.CommitMetadata) {
+	dir, err := ioutil.TempDir("", "rocrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWriteFile(t, filepath.Join(dir, "foo.csv"), "a,b\n1,2\n")
+	testWriteFile(t, filepath.Join(dir, "model.pkl"), "model")
+	testWriteFile(t, filepath.Join(dir, "b", "input.csv"), "x\n")
+	testWriteFile(t, filepath.Join(dir, "run1", "workload-stdout.log"), "hello\n")
+
+	oops := "bad input"
+	cm := metadata.CommitMetadata{
+		SubmitterID:       "452342",
+		WorkloadImage:     "busybox",
+		WorkloadImageHash: "busybox@sha256:2a03",
+		WorkloadCommand:   []string{"python", "train.py"},
+		ExecLogs:          []string{"run1/workload-stdout.log"},
+		ExecEnd:           time.Date(2018, 10, 4, 13, 6, 10, 0, time.UTC),
+		Runs: []metadata.RunMetadata{
+			{
+				RunID:                "r1",
+				Success:              true,
+				WorkspaceInputFiles:  []metadata.InputFile{{Filename: "foo.csv", Version: "w0"}},
+				DatasetInputFiles:    map[string][]metadata.InputFile{"b": {{Filename: "input.csv", Version: "b0"}}},
+				WorkspaceOutputFiles: []string{"model.pkl"},
+				Parameters:           map[string]string{"alpha": "0.5"},
+				Summary:              map[string]string{"loss": "0.1"},
+			},
+			{
+				RunID:        "r2",
+				ErrorMessage: &oops,
+				Authority:    metadata.RunAuthority_Correction,
+			},
+		},
+	}
+	return dir, cm
+}
+
+func testFindEntity(crate *Crate, id string) Entity {
+	for _, e := range crate.Graph {
+		if e["@id"] == id {
+			return e
+		}
+	}
+	return nil
+}
+
+func TestBuild(t *testing.T) {
+	dir, cm := testSetup(t)
+	defer os.RemoveAll(dir)
+
+	crate, err := Build(cm, dir, Options{License: "CC-BY-4.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(crate.Files) != 4 {
+		t.Errorf("Expected 4 packaged files, got %#v", crate.Files)
+	}
+
+	run := testFindEntity(crate, "#run-r1")
+	if run == nil {
+		t.Fatalf("No CreateAction for r1")
+	}
+	if run["@type"] != "CreateAction" || run["instrument"].(Entity)["@id"] != "#workload-image" {
+		t.Errorf("Unexpected action %#v", run)
+	}
+	// foo.csv, input.csv, the command and one parameter
+	if n := len(run["object"].([]Entity)); n != 4 {
+		t.Errorf("Expected 4 objects, got %d", n)
+	}
+	// model.pkl and one summary value
+	if n := len(run["result"].([]Entity)); n != 2 {
+		t.Errorf("Expected 2 results, got %d", n)
+	}
+
+	failed := testFindEntity(crate, "#run-r2")
+	if failed["error"] != "bad input" || failed["actionStatus"].(Entity)["@id"] != failedStatus {
+		t.Errorf("Unexpected failed action %#v", failed)
+	}
+
+	input := testFindEntity(crate, "datasets/b/input.csv")
+	if input == nil || input["version"] != "b0" || input["contentSize"] != "2" {
+		t.Errorf("Unexpected dataset input entity %#v", input)
+	}
+	log := testFindEntity(crate, "logs/run1/workload-stdout.log")
+	if log == nil {
+		t.Errorf("Missing log entity")
+	}
+
+	var buf bytes.Buffer
+	err = crate.WriteMetadata(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["@context"] != ContextURL {
+		t.Errorf("Unexpected context %v", doc["@context"])
+	}
+}
+
+func TestBuildMissingFiles(t *testing.T) {
+	dir, cm := testSetup(t)
+	defer os.RemoveAll(dir)
+	cm.Runs[0].WorkspaceOutputFiles = append(cm.Runs[0].WorkspaceOutputFiles, "../../etc/gone.txt")
+
+	_, err := Build(cm, dir, Options{})
+	if err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+
+	crate, err := Build(cm, dir, Options{AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testFindEntity(crate, "workspace/etc/gone.txt") != nil || testFindEntity(crate, "#workspace/etc/gone.txt") == nil {
+		t.Errorf("Expected missing file to be described with a local identifier")
+	}
+	results := testFindEntity(crate, "#run-r1")["result"].([]Entity)
+	if results[1]["@id"] != "#workspace/etc/gone.txt" {
+		t.Errorf("Expected the run to refer to the missing file's identifier, got %#v", results)
+	}
+	if _, ok := crate.Files["workspace/etc/gone.txt"]; ok {
+		t.Errorf("Did not expect missing file to be packaged")
+	}
+}
+
+func TestWriteArchives(t *testing.T) {
+	dir, cm := testSetup(t)
+	defer os.RemoveAll(dir)
+	crate, err := Build(cm, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var zbuf bytes.Buffer
+	err = crate.WriteZip(&zbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	for _, want := range []string{MetadataFilename, "workspace/foo.csv", "datasets/b/input.csv", "logs/run1/workload-stdout.log"} {
+		if !names[want] {
+			t.Errorf("Zip is missing %s", want)
+		}
+	}
+
+	var tbuf bytes.Buffer
+	err = crate.WriteTar(&tbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&tbuf)
+	count := 0
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != 5 {
+		t.Errorf("Expected 5 tar entries, got %d", count)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, cm := testSetup(t)
+	defer os.RemoveAll(dir)
+	out, err := ioutil.TempDir("", "rocrate-out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	err = Export(cm, dir, filepath.Join(out, "crate.zip"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"crate.zip", MetadataFilename} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Errorf("Expected %s to be written: %s", name, err)
+		}
+	}
+}
+
+func TestBuildDatasetNames(t *testing.T) {
+	dir, cm := testSetup(t)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"../../x", "a/b", `a\b`, ".", ""} {
+		cm.Runs[0].DatasetInputFiles = map[string][]metadata.InputFile{name: {{Filename: "input.csv"}}}
+		_, err := Build(cm, dir, Options{AllowMissing: true})
+		if err == nil {
+			t.Errorf("Expected an error for dataset name %q", name)
+		}
+	}
+}
+
+func TestWriteTarModTime(t *testing.T) {
+	dir, cm := testSetup(t)
+	defer os.RemoveAll(dir)
+	crate, err := Build(cm, dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = crate.WriteTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := tar.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.ModTime.Equal(cm.ExecEnd) {
+		t.Errorf("Wanted the metadata stamped %v, got %v", cm.ExecEnd, header.ModTime)
+	}
+}
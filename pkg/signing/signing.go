@@ -0,0 +1,243 @@
+package signing
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Keys of the metadata map reserved for signatures. Everything under
+// KeyPrefix is excluded from the signed content, apart from KeyKeyID and
+// KeyCovered, which are signed along with the keys they list.
+const (
+	KeyPrefix    = "signature."
+	KeySignature = "signature.ed25519"
+	KeyKeyID     = "signature.key-id"
+	KeyCovered   = "signature.keys"
+)
+
+// signingContext separates these signatures from any other use of the same
+// key.
+const signingContext = "dotscience-metadata-signature-v1\n"
+
+var (
+	ErrNotSigned    = errors.New("metadata is not signed")
+	ErrUnknownKey   = errors.New("metadata is signed by an untrusted key")
+	ErrBadSignature = errors.New("metadata signature does not match")
+	ErrUncovered    = errors.New("metadata has keys not covered by its signature")
+)
+
+// KeyID returns the identifier of a public key: the first eight bytes of
+// its SHA-256 hash, in hex.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// type TrustStore holds the public keys whose signatures are accepted.
+type TrustStore struct {
+	keys map[string]ed25519.PublicKey
+}
+
+// NewTrustStore returns an empty trust store.
+func NewTrustStore() *TrustStore {
+	return &TrustStore{keys: map[string]ed25519.PublicKey{}}
+}
+
+// Add trusts a public key, returning its key ID.
+func (ts *TrustStore) Add(pub ed25519.PublicKey) (string, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("public key is %d bytes, expected %d", len(pub), ed25519.PublicKeySize)
+	}
+	id := KeyID(pub)
+	ts.keys[id] = pub
+	return id, nil
+}
+
+// Lookup returns the trusted key with the given ID.
+func (ts *TrustStore) Lookup(id string) (ed25519.PublicKey, bool) {
+	pub, ok := ts.keys[id]
+	return pub, ok
+}
+
+// LoadTrustStore reads public keys, one per line, as base64 optionally
+// followed by a space and a comment. Blank lines and lines starting with #
+// are ignored.
+func LoadTrustStore(r io.Reader) (*TrustStore, error) {
+	ts := NewTrustStore()
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		pub, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		_, err = ts.Add(ed25519.PublicKey(pub))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	return ts, scanner.Err()
+}
+
+// CanonicalBytes returns the bytes that are signed for a metadata map: the
+// listed keys and their values, as a JSON list of [key, value] pairs sorted
+// by key. Keys missing from the map are an error.
+func CanonicalBytes(m map[string]string, keys []string) ([]byte, error) {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	pairs := make([][2]string, 0, len(sorted))
+	for idx, k := range sorted {
+		if idx > 0 && sorted[idx-1] == k {
+			continue
+		}
+		v, ok := m[k]
+		if !ok {
+			return nil, fmt.Errorf("signed key %q is missing", k)
+		}
+		pairs = append(pairs, [2]string{k, v})
+	}
+	b, err := json.Marshal(pairs)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(signingContext), b...), nil
+}
+
+// Sign returns a copy of m with a signature over all of its keys added.
+// Any existing signature is replaced.
+func Sign(m map[string]string, priv ed25519.PrivateKey) (map[string]string, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("private key is %d bytes, expected %d", len(priv), ed25519.PrivateKeySize)
+	}
+
+	result := map[string]string{}
+	covered := []string{}
+	for k, v := range m {
+		if strings.HasPrefix(k, KeyPrefix) {
+			continue
+		}
+		result[k] = v
+		covered = append(covered, k)
+	}
+	sort.Strings(covered)
+
+	coveredJSON, err := json.Marshal(covered)
+	if err != nil {
+		return nil, err
+	}
+	result[KeyKeyID] = KeyID(priv.Public().(ed25519.PublicKey))
+	result[KeyCovered] = string(coveredJSON)
+
+	msg, err := CanonicalBytes(result, append(covered, KeyKeyID, KeyCovered))
+	if err != nil {
+		return nil, err
+	}
+	result[KeySignature] = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+	return result, nil
+}
+
+// type Result describes a verified signature.
+type Result struct {
+	// KeyID is the ID of the key that made the signature.
+	KeyID string
+
+	// Covered lists the keys protected by the signature.
+	Covered []string
+
+	// Uncovered lists keys present in the map that the signature does not
+	// protect, because they were added after signing.
+	Uncovered []string
+}
+
+// Verify checks the signature on a metadata map against the trust store.
+// It fails if the map is unsigned, the signer is not trusted, any covered
+// key has been changed or removed since signing, or any key has been added
+// since signing. Added keys fail with ErrUncovered, and are listed in the
+// result.
+func Verify(m map[string]string, ts *TrustStore) (Result, error) {
+	result, err := VerifyCovered(m, ts)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Uncovered) > 0 {
+		return result, ErrUncovered
+	}
+	return result, nil
+}
+
+// VerifyCovered is like Verify, but allows keys added since signing, such
+// as labels attached later, and reports them in the result. Callers must
+// only trust the values of the keys in Result.Covered.
+func VerifyCovered(m map[string]string, ts *TrustStore) (Result, error) {
+	sig, hasSig := m[KeySignature]
+	keyID, hasKeyID := m[KeyKeyID]
+	coveredJSON, hasCovered := m[KeyCovered]
+	if !hasSig || !hasKeyID || !hasCovered {
+		return Result{}, ErrNotSigned
+	}
+
+	pub, ok := ts.Lookup(keyID)
+	if !ok {
+		return Result{KeyID: keyID}, ErrUnknownKey
+	}
+
+	covered := []string{}
+	err := json.Unmarshal([]byte(coveredJSON), &covered)
+	if err != nil {
+		return Result{KeyID: keyID}, fmt.Errorf("%s: %s", KeyCovered, err)
+	}
+	for _, k := range covered {
+		if strings.HasPrefix(k, KeyPrefix) {
+			return Result{KeyID: keyID}, fmt.Errorf("%s lists reserved key %q", KeyCovered, k)
+		}
+	}
+
+	rawSig, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return Result{KeyID: keyID}, ErrBadSignature
+	}
+
+	msg, err := CanonicalBytes(m, append(append([]string{}, covered...), KeyKeyID, KeyCovered))
+	if err != nil {
+		// A covered key has been removed
+		return Result{KeyID: keyID}, ErrBadSignature
+	}
+	if !ed25519.Verify(pub, msg, rawSig) {
+		return Result{KeyID: keyID}, ErrBadSignature
+	}
+
+	isCovered := map[string]bool{}
+	for _, k := range covered {
+		isCovered[k] = true
+	}
+	uncovered := []string{}
+	for k := range m {
+		if !isCovered[k] && k != KeySignature && k != KeyKeyID && k != KeyCovered {
+			uncovered = append(uncovered, k)
+		}
+	}
+	sort.Strings(uncovered)
+	sort.Strings(covered)
+
+	return Result{
+		KeyID:     keyID,
+		Covered:   covered,
+		Uncovered: uncovered,
+	}, nil
+}
@@ -0,0 +1,153 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T, seed byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	s := make([]byte, ed25519.SeedSize)
+	for i := range s {
+		s[i] = seed
+	}
+	priv := ed25519.NewKeyFromSeed(s)
+	return priv.Public().(ed25519.PublicKey), priv
+}
+
+func testMetadata() map[string]string {
+	return map[string]string{
+		"type":                     "dotscience.run.v1",
+		"author":                   "452342",
+		"runs":                     "[\"r1\"]",
+		"run.r1.summary.rms_error": "0.057",
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	pub, priv := testKey(t, 1)
+	ts := NewTrustStore()
+	id, err := ts.Add(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := Sign(testMetadata(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed[KeyKeyID] != id {
+		t.Errorf("Wanted key ID %s, got %s", id, signed[KeyKeyID])
+	}
+
+	result, err := Verify(signed, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Covered) != 4 || len(result.Uncovered) != 0 {
+		t.Errorf("Unexpected result %#v", result)
+	}
+
+	// Re-signing replaces the old signature
+	resigned, err := Sign(signed, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resigned[KeySignature] != signed[KeySignature] {
+		t.Errorf("Expected re-signing to be deterministic")
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	pub, priv := testKey(t, 1)
+	ts := NewTrustStore()
+	ts.Add(pub)
+
+	signed, err := Sign(testMetadata(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := copyMap(signed)
+	changed["run.r1.summary.rms_error"] = "0.001"
+	if _, err := Verify(changed, ts); err != ErrBadSignature {
+		t.Errorf("Expected ErrBadSignature for a changed value, got %v", err)
+	}
+
+	removed := copyMap(signed)
+	delete(removed, "author")
+	if _, err := Verify(removed, ts); err != ErrBadSignature {
+		t.Errorf("Expected ErrBadSignature for a removed key, got %v", err)
+	}
+
+	narrowed := copyMap(signed)
+	narrowed[KeyCovered] = "[\"type\"]"
+	if _, err := Verify(narrowed, ts); err != ErrBadSignature {
+		t.Errorf("Expected ErrBadSignature for a changed key list, got %v", err)
+	}
+
+	added := copyMap(signed)
+	added["run.r1.label.approved"] = "yes"
+	result, err := Verify(added, ts)
+	if err != ErrUncovered {
+		t.Errorf("Expected ErrUncovered for an added key, got %v", err)
+	}
+	if len(result.Uncovered) != 1 || result.Uncovered[0] != "run.r1.label.approved" {
+		t.Errorf("Unexpected uncovered keys %#v", result.Uncovered)
+	}
+
+	result, err = VerifyCovered(added, ts)
+	if err != nil {
+		t.Errorf("Expected VerifyCovered to report added keys rather than fail, got %v", err)
+	}
+	if len(result.Uncovered) != 1 || result.Uncovered[0] != "run.r1.label.approved" {
+		t.Errorf("Unexpected uncovered keys %#v", result.Uncovered)
+	}
+
+	// Keys under the reserved prefix aren't signed either
+	reserved := copyMap(signed)
+	reserved["signature.note"] = "trust me"
+	if _, err := Verify(reserved, ts); err != ErrUncovered {
+		t.Errorf("Expected ErrUncovered for an added reserved key, got %v", err)
+	}
+}
+
+func TestVerifyUntrusted(t *testing.T) {
+	_, priv := testKey(t, 2)
+	signed, err := Sign(testMetadata(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(signed, NewTrustStore()); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+	if _, err := Verify(testMetadata(), NewTrustStore()); err != ErrNotSigned {
+		t.Errorf("Expected ErrNotSigned, got %v", err)
+	}
+}
+
+func TestLoadTrustStore(t *testing.T) {
+	pub, _ := testKey(t, 3)
+	ts, err := LoadTrustStore(strings.NewReader("# team keys\n\n" + base64.StdEncoding.EncodeToString(pub) + " alice@example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ts.Lookup(KeyID(pub)); !ok {
+		t.Errorf("Expected key to be trusted")
+	}
+
+	_, err = LoadTrustStore(strings.NewReader("AAAA\n"))
+	if err == nil {
+		t.Errorf("Expected an error for a short key")
+	}
+}
+
+func copyMap(m map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
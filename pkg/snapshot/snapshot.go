@@ -0,0 +1,280 @@
+// Package snapshot records the contents of a workspace or dataset
+// directory on local disk, so that changes to it can be found and checked
+// against run metadata.
+package snapshot
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// type File records one regular file in a snapshot.
+type File struct {
+	// Path is slash-separated and relative to the snapshot's directory.
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	SHA256  string      `json:"sha256"`
+}
+
+// type Snapshot is the set of regular files in a directory, sorted by path.
+type Snapshot struct {
+	Files []File `json:"files"`
+}
+
+// type Options controls how a snapshot is taken.
+type Options struct {
+	// Ignore lists patterns of paths to leave out, in the style of
+	// .gitignore: a pattern containing a slash is matched against the
+	// whole relative path, otherwise against each path element. A trailing
+	// slash matches directories only. Patterns use path.Match syntax.
+	Ignore []string
+
+	// Workers is how many files to hash at once; zero means one per CPU.
+	// Listing the directories is not parallelised.
+	Workers int
+
+	// Previous, if set, is an earlier snapshot of the same directory.
+	// Files whose size, mode and modification time are unchanged since
+	// then are not hashed again.
+	Previous *Snapshot
+}
+
+type ignorePattern struct {
+	pattern string
+	dirOnly bool
+	full    bool
+}
+
+func parseIgnore(patterns []string) []ignorePattern {
+	result := []ignorePattern{}
+	for _, p := range patterns {
+		ip := ignorePattern{pattern: p}
+		if strings.HasSuffix(ip.pattern, "/") {
+			ip.dirOnly = true
+			ip.pattern = strings.TrimSuffix(ip.pattern, "/")
+		}
+		if strings.Contains(ip.pattern, "/") {
+			ip.full = true
+			ip.pattern = strings.TrimPrefix(ip.pattern, "/")
+		}
+		if ip.pattern != "" {
+			result = append(result, ip)
+		}
+	}
+	return result
+}
+
+func ignored(patterns []ignorePattern, rel string, isDir bool) bool {
+	for _, p := range patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		name := path.Base(rel)
+		if p.full {
+			name = rel
+		}
+		if ok, _ := path.Match(p.pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Take snapshots the regular files beneath dir. Symbolic links and other
+// special files are not followed or recorded. The directory tree is walked
+// serially; only the hashing of the files found is spread across
+// opts.Workers goroutines.
+func Take(dir string, opts Options) (*Snapshot, error) {
+	patterns := parseIgnore(opts.Ignore)
+	files := []File{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignored(patterns, rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		files = append(files, File{
+			Path:    rel,
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	previous := map[string]File{}
+	if opts.Previous != nil {
+		previous = opts.Previous.byPath()
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				f := &files[idx]
+				if old, ok := previous[f.Path]; ok && old.Size == f.Size && old.Mode == f.Mode && old.ModTime.Equal(f.ModTime) {
+					f.SHA256 = old.SHA256
+					continue
+				}
+				hash, err := metadata.HashFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+				if err != nil {
+					errs <- err
+					return
+				}
+				f.SHA256 = hash
+			}
+		}()
+	}
+
+	var hashErr error
+dispatch:
+	for idx := range files {
+		select {
+		case jobs <- idx:
+		case hashErr = <-errs:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if hashErr == nil {
+		select {
+		case hashErr = <-errs:
+		default:
+		}
+	}
+	if hashErr != nil {
+		return nil, hashErr
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return &Snapshot{Files: files}, nil
+}
+
+func (s *Snapshot) byPath() map[string]File {
+	result := map[string]File{}
+	for _, f := range s.Files {
+		result[f.Path] = f
+	}
+	return result
+}
+
+// Lookup returns the file at path p, if it is in the snapshot.
+func (s *Snapshot) Lookup(p string) (File, bool) {
+	idx := sort.Search(len(s.Files), func(i int) bool { return s.Files[i].Path >= p })
+	if idx < len(s.Files) && s.Files[idx].Path == p {
+		return s.Files[idx], true
+	}
+	return File{}, false
+}
+
+// Hashes returns the snapshot's content hashes, for use with
+// metadata.CorrectionRun.
+func (s *Snapshot) Hashes() metadata.FileHashes {
+	result := metadata.FileHashes{}
+	for _, f := range s.Files {
+		result[f.Path] = f.SHA256
+	}
+	return result
+}
+
+// type Diff lists the paths that changed between two snapshots, each
+// sorted. A file is modified if its contents or permissions changed.
+type Diff struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Deleted  []string `json:"deleted"`
+}
+
+// Compare returns the changes from before to after.
+func Compare(before, after *Snapshot) Diff {
+	d := Diff{Added: []string{}, Modified: []string{}, Deleted: []string{}}
+	old := before.byPath()
+	for _, f := range after.Files {
+		o, ok := old[f.Path]
+		if !ok {
+			d.Added = append(d.Added, f.Path)
+		} else if o.SHA256 != f.SHA256 || o.Mode.Perm() != f.Mode.Perm() {
+			d.Modified = append(d.Modified, f.Path)
+		}
+	}
+	current := after.byPath()
+	for _, f := range before.Files {
+		if _, ok := current[f.Path]; !ok {
+			d.Deleted = append(d.Deleted, f.Path)
+		}
+	}
+	return d
+}
+
+// Changed returns every added, modified and deleted path, sorted; the
+// form used for a run's output files.
+func (d Diff) Changed() []string {
+	result := []string{}
+	result = append(result, d.Added...)
+	result = append(result, d.Modified...)
+	result = append(result, d.Deleted...)
+	sort.Strings(result)
+	return result
+}
+
+// Empty reports whether nothing changed.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Modified) == 0 && len(d.Deleted) == 0
+}
+
+// Write writes a snapshot as JSON.
+func Write(w io.Writer, s *Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// Read reads a snapshot written by Write.
+func Read(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	err := json.NewDecoder(r).Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].Path < s.Files[j].Path })
+	return s, nil
+}
@@ -0,0 +1,132 @@
+package snapshot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testWriteFile(t *testing.T, dir, name, content string) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(p, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testEqStrs(t *testing.T, got, expected []string) {
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wanted %#v, got %#v", expected, got)
+	}
+}
+
+func testPaths(s *Snapshot) []string {
+	result := []string{}
+	for _, f := range s.Files {
+		result = append(result, f.Path)
+	}
+	return result
+}
+
+func TestTakeAndCompare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testWriteFile(t, dir, "train.py", "print('hello')")
+	testWriteFile(t, dir, "data/a.csv", "1,2,3")
+	testWriteFile(t, dir, "data/b.csv", "4,5,6")
+	testWriteFile(t, dir, "data/cache.pyc", "junk")
+	testWriteFile(t, dir, ".git/HEAD", "ref")
+	testWriteFile(t, dir, "build/out.o", "obj")
+
+	opts := Options{Ignore: []string{"*.pyc", ".git/", "/build"}, Workers: 2}
+	before, err := Take(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEqStrs(t, testPaths(before), []string{"data/a.csv", "data/b.csv", "train.py"})
+
+	f, ok := before.Lookup("train.py")
+	if !ok {
+		t.Fatalf("Expected to find train.py")
+	}
+	if f.Size != 14 || f.SHA256 != "96f43d529af3430cb6b0e2c02f6b38ef1a121e8a31d2d09a3ebb716f2f35c9de" {
+		t.Errorf("Unexpected file %#v", f)
+	}
+	if before.Hashes()["data/a.csv"] == "" {
+		t.Errorf("Expected a hash for data/a.csv")
+	}
+
+	testWriteFile(t, dir, "data/a.csv", "1,2,3,4")
+	testWriteFile(t, dir, "model.pkl", "model")
+	os.Remove(filepath.Join(dir, "data", "b.csv"))
+
+	after, err := Take(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Compare(before, after)
+	testEqStrs(t, d.Added, []string{"model.pkl"})
+	testEqStrs(t, d.Modified, []string{"data/a.csv"})
+	testEqStrs(t, d.Deleted, []string{"data/b.csv"})
+	testEqStrs(t, d.Changed(), []string{"data/a.csv", "data/b.csv", "model.pkl"})
+	if !Compare(after, after).Empty() {
+		t.Errorf("Expected no changes between a snapshot and itself")
+	}
+}
+
+func TestTakeReusesPreviousHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testWriteFile(t, dir, "a.txt", "a")
+
+	mtime := time.Date(2018, 10, 4, 13, 6, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(dir, "a.txt"), mtime, mtime)
+	previous, err := Take(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous.Files[0].SHA256 = "remembered"
+	s, err := Take(dir, Options{Previous: previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Files[0].SHA256 != "remembered" {
+		t.Errorf("Expected the previous hash to be reused, got %s", s.Files[0].SHA256)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	s := &Snapshot{Files: []File{
+		File{Path: "b", Size: 1, Mode: 0644, ModTime: time.Date(2018, 10, 4, 13, 6, 0, 0, time.UTC), SHA256: "bb"},
+		File{Path: "a", Size: 2, Mode: 0600, ModTime: time.Date(2018, 10, 4, 13, 7, 0, 0, time.UTC), SHA256: "aa"},
+	}}
+	var buf bytes.Buffer
+	err := Write(&buf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEqStrs(t, testPaths(read), []string{"a", "b"})
+	if !reflect.DeepEqual(read.Files[1], s.Files[0]) {
+		t.Errorf("Wanted %#v, got %#v", s.Files[0], read.Files[1])
+	}
+}
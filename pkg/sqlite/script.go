@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// type Script is a DB that writes each statement, with its arguments
+// inlined as SQL literals, to a writer, for piping into the sqlite3 command
+// line tool. Placeholders must not appear inside string literals in the
+// statements.
+type Script struct {
+	w io.Writer
+}
+
+// NewScript returns a Script writing to w.
+func NewScript(w io.Writer) *Script {
+	return &Script{w: w}
+}
+
+type scriptResult struct{}
+
+func (scriptResult) LastInsertId() (int64, error) { return 0, nil }
+func (scriptResult) RowsAffected() (int64, error) { return 0, nil }
+
+// Begin starts a transaction in the script.
+func (s *Script) Begin() error {
+	_, err := io.WriteString(s.w, "BEGIN;\n")
+	return err
+}
+
+// Commit ends a transaction in the script.
+func (s *Script) Commit() error {
+	_, err := io.WriteString(s.w, "COMMIT;\n")
+	return err
+}
+
+func (s *Script) Exec(query string, args ...interface{}) (sql.Result, error) {
+	parts := strings.Split(query, "?")
+	if len(parts) != len(args)+1 {
+		return nil, fmt.Errorf("statement has %d placeholders but %d arguments", len(parts)-1, len(args))
+	}
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for idx, arg := range args {
+		lit, err := literal(arg)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(lit)
+		b.WriteString(parts[idx+1])
+	}
+	b.WriteString(";\n")
+	_, err := io.WriteString(s.w, b.String())
+	if err != nil {
+		return nil, err
+	}
+	return scriptResult{}, nil
+}
+
+func literal(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return "'" + strings.Replace(value, "'", "''", -1) + "'", nil
+	case bool:
+		if value {
+			return "1", nil
+		}
+		return "0", nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return "NULL", nil
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	default:
+		return "", fmt.Errorf("can't write %T as a SQL literal", v)
+	}
+}
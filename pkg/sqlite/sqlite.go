@@ -0,0 +1,440 @@
+// Package sqlite exports commit metadata into a normalised SQLite database
+// for analysis in SQL.
+//
+// The exporter writes through the DB interface, which *sql.DB and *sql.Tx
+// satisfy, so the caller chooses and registers the SQLite driver. Without
+// a driver, NewScript writes the same statements as a script for the
+// sqlite3 command line tool.
+//
+// Every table is keyed by the workspace dot ID and commit ID. Timestamps
+// are stored as UTC text in the form "2006-01-02T15:04:05.000Z", which
+// SQLite's date and time functions understand, and booleans as 0 or 1.
+// Numbers that are NaN or infinite, which SQLite can't store, are NULL.
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+// TimeFormat is the layout of timestamps in the database.
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// type DB executes SQL statements with ? placeholders.
+type DB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Schema creates the tables, indexes and views, if they don't exist.
+var Schema = []string{
+	`CREATE TABLE IF NOT EXISTS commits (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		submitter_id TEXT,
+		success INTEGER NOT NULL,
+		message TEXT,
+		workload_type TEXT,
+		workload_image TEXT,
+		workload_image_hash TEXT,
+		workload_command TEXT,
+		exec_start TEXT,
+		exec_end TEXT,
+		exec_cpu_seconds REAL,
+		exec_peak_ram_bytes INTEGER,
+		runner_name TEXT,
+		runner_version TEXT,
+		runner_platform TEXT,
+		runner_platform_version TEXT,
+		runner_ram_bytes INTEGER,
+		PRIMARY KEY (dot_id, commit_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS runs (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		authority TEXT NOT NULL,
+		description TEXT,
+		workload_file TEXT,
+		success INTEGER NOT NULL,
+		error_message TEXT,
+		exec_start TEXT,
+		exec_end TEXT,
+		duration_seconds REAL,
+		PRIMARY KEY (dot_id, commit_id, run_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS run_parameters (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (dot_id, commit_id, run_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS run_summary (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		number REAL,
+		PRIMARY KEY (dot_id, commit_id, run_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS run_labels (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (dot_id, commit_id, run_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS run_inputs (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		version TEXT,
+		hash TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS run_outputs (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		hash TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS dataset_inputs (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		dataset TEXT NOT NULL,
+		dataset_dot_id TEXT,
+		dataset_version TEXT,
+		filename TEXT NOT NULL,
+		version TEXT,
+		hash TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS dataset_outputs (
+		dot_id TEXT NOT NULL,
+		commit_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		dataset TEXT NOT NULL,
+		dataset_dot_id TEXT,
+		dataset_version TEXT,
+		filename TEXT NOT NULL,
+		hash TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS commits_exec_start ON commits (exec_start)`,
+	`CREATE INDEX IF NOT EXISTS commits_submitter ON commits (submitter_id)`,
+	`CREATE INDEX IF NOT EXISTS runs_run_id ON runs (run_id)`,
+	`CREATE INDEX IF NOT EXISTS runs_exec_start ON runs (exec_start)`,
+	`CREATE INDEX IF NOT EXISTS run_parameters_name ON run_parameters (name, value)`,
+	`CREATE INDEX IF NOT EXISTS run_summary_name ON run_summary (name)`,
+	`CREATE INDEX IF NOT EXISTS run_labels_name ON run_labels (name, value)`,
+	`CREATE INDEX IF NOT EXISTS run_inputs_commit ON run_inputs (dot_id, commit_id, run_id)`,
+	`CREATE INDEX IF NOT EXISTS run_inputs_filename ON run_inputs (filename)`,
+	`CREATE INDEX IF NOT EXISTS run_outputs_commit ON run_outputs (dot_id, commit_id, run_id)`,
+	`CREATE INDEX IF NOT EXISTS run_outputs_filename ON run_outputs (filename)`,
+	`CREATE INDEX IF NOT EXISTS dataset_inputs_commit ON dataset_inputs (dot_id, commit_id, run_id)`,
+	`CREATE INDEX IF NOT EXISTS dataset_inputs_dataset ON dataset_inputs (dataset_dot_id, filename)`,
+	`CREATE INDEX IF NOT EXISTS dataset_outputs_commit ON dataset_outputs (dot_id, commit_id, run_id)`,
+	`CREATE INDEX IF NOT EXISTS dataset_outputs_dataset ON dataset_outputs (dataset_dot_id, filename)`,
+	// Summary values that are finite numbers, as numbers
+	`CREATE VIEW IF NOT EXISTS run_summary_numeric AS
+		SELECT dot_id, commit_id, run_id, name, number AS value
+		FROM run_summary
+		WHERE number IS NOT NULL`,
+}
+
+// tables lists the tables holding a commit's rows, children first.
+var tables = []string{
+	"run_parameters", "run_summary", "run_labels",
+	"run_inputs", "run_outputs", "dataset_inputs", "dataset_outputs",
+	"runs", "commits",
+}
+
+// CreateSchema creates the tables, indexes and views, if they don't exist.
+func CreateSchema(db DB) error {
+	for _, stmt := range Schema {
+		_, err := db.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRunIDs returns an error wrapping metadata.ErrDuplicateRun if two runs
+// in a commit share a run ID, which the runs table's key forbids.
+func checkRunIDs(commits []metadata.WorkspaceCommit) error {
+	for _, c := range commits {
+		seen := map[string]bool{}
+		for _, run := range c.Metadata.Runs {
+			if seen[run.RunID] {
+				return fmt.Errorf("commit %s, run %s: %w", c.CommitID, run.RunID, metadata.ErrDuplicateRun)
+			}
+			seen[run.RunID] = true
+		}
+	}
+	return nil
+}
+
+// Export creates the schema if need be, and exports commits from the
+// workspace dot dotID. Pass a *sql.Tx to export them atomically. Nothing is
+// written if any commit has two runs with the same run ID.
+func Export(db DB, dotID string, commits []metadata.WorkspaceCommit) error {
+	err := checkRunIDs(commits)
+	if err != nil {
+		return err
+	}
+	err = CreateSchema(db)
+	if err != nil {
+		return err
+	}
+	for _, c := range commits {
+		err := ExportCommit(db, dotID, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync exports those of commits that the database doesn't hold yet, in a
+// transaction, so a database can be kept up to date as commits arrive.
+// Dotmesh commits never change, so commits already exported are skipped.
+func Sync(db *sql.DB, dotID string, commits []metadata.WorkspaceCommit) error {
+	err := checkRunIDs(commits)
+	if err != nil {
+		return err
+	}
+	err = CreateSchema(db)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT commit_id FROM commits WHERE dot_id = ?", dotID)
+	if err != nil {
+		return err
+	}
+	exported := map[string]bool{}
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		exported[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, c := range commits {
+		if exported[c.CommitID] {
+			continue
+		}
+		err := ExportCommit(tx, dotID, c)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ExportCommit writes the rows of one commit, replacing any already
+// written for it. Nothing is written if two of its runs have the same run
+// ID.
+func ExportCommit(db DB, dotID string, c metadata.WorkspaceCommit) error {
+	err := checkRunIDs([]metadata.WorkspaceCommit{c})
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		_, err := db.Exec("DELETE FROM "+table+" WHERE dot_id = ? AND commit_id = ?", dotID, c.CommitID)
+		if err != nil {
+			return err
+		}
+	}
+
+	cm := c.Metadata
+	command, _ := json.Marshal(cm.WorkloadCommand)
+	_, err = db.Exec(`INSERT INTO commits (
+		dot_id, commit_id, submitter_id, success, message,
+		workload_type, workload_image, workload_image_hash, workload_command,
+		exec_start, exec_end, exec_cpu_seconds, exec_peak_ram_bytes,
+		runner_name, runner_version, runner_platform, runner_platform_version, runner_ram_bytes
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		dotID, c.CommitID, nullString(cm.SubmitterID), cm.Success, nullString(cm.Message),
+		nullString(cm.WorkloadType), nullString(cm.WorkloadImage), nullString(cm.WorkloadImageHash), nullString(nullJSON(command)),
+		nullTime(cm.ExecStart), nullTime(cm.ExecEnd), nullFloat(cm.ExecCPUSecondsUsed), nullInt(cm.ExecPeakRAMBytes),
+		nullString(cm.RunnerName), nullString(cm.RunnerVersion), nullString(cm.RunnerPlatform), nullString(cm.RunnerPlatformVersion), nullInt(cm.RunnerRAMBytes),
+	)
+	if err != nil {
+		return err
+	}
+
+	for idx, run := range cm.Runs {
+		err := exportRun(db, dotID, c.CommitID, idx, cm, run)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportRun(db DB, dotID, commitID string, position int, cm metadata.CommitMetadata, run metadata.RunMetadata) error {
+	var errorMessage interface{}
+	if run.ErrorMessage != nil {
+		errorMessage = *run.ErrorMessage
+	}
+	var duration interface{}
+	if !run.ExecStart.IsZero() && !run.ExecEnd.IsZero() {
+		duration = finite(run.ExecEnd.Sub(run.ExecStart).Seconds())
+	}
+	_, err := db.Exec(`INSERT INTO runs (
+		dot_id, commit_id, run_id, position, authority, description, workload_file,
+		success, error_message, exec_start, exec_end, duration_seconds
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		dotID, commitID, run.RunID, position, run.Authority.String(), nullString(run.Description), nullString(run.WorkloadFile),
+		run.Success, errorMessage, nullTime(run.ExecStart), nullTime(run.ExecEnd), duration,
+	)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range run.Summary {
+		// The number column holds the value if it is a finite number, for
+		// the run_summary_numeric view
+		var number interface{}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			number = finite(f)
+		}
+		_, err := db.Exec("INSERT INTO run_summary (dot_id, commit_id, run_id, name, value, number) VALUES (?, ?, ?, ?, ?, ?)",
+			dotID, commitID, run.RunID, name, value, number)
+		if err != nil {
+			return err
+		}
+	}
+	for table, values := range map[string]map[string]string{
+		"run_parameters": run.Parameters,
+		"run_labels":     run.Labels,
+	} {
+		for name, value := range values {
+			_, err := db.Exec("INSERT INTO "+table+" (dot_id, commit_id, run_id, name, value) VALUES (?, ?, ?, ?, ?)",
+				dotID, commitID, run.RunID, name, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, inf := range run.WorkspaceInputFiles {
+		_, err := db.Exec("INSERT INTO run_inputs (dot_id, commit_id, run_id, filename, version, hash) VALUES (?, ?, ?, ?, ?, ?)",
+			dotID, commitID, run.RunID, inf.Filename, nullString(inf.Version), nullString(inf.Hash))
+		if err != nil {
+			return err
+		}
+	}
+	hashes := map[string]string{}
+	for _, of := range run.WorkspaceOutputHashes {
+		hashes[of.Filename] = of.Hash
+	}
+	for _, f := range run.WorkspaceOutputFiles {
+		_, err := db.Exec("INSERT INTO run_outputs (dot_id, commit_id, run_id, filename, hash) VALUES (?, ?, ?, ?, ?)",
+			dotID, commitID, run.RunID, f, nullString(hashes[f]))
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, ifs := range run.DatasetInputFiles {
+		dsv := cm.Inputs[name]
+		for _, inf := range ifs {
+			_, err := db.Exec(`INSERT INTO dataset_inputs (
+				dot_id, commit_id, run_id, dataset, dataset_dot_id, dataset_version, filename, version, hash
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				dotID, commitID, run.RunID, name, nullString(string(dsv.ID)), nullString(dsv.Version),
+				inf.Filename, nullString(inf.Version), nullString(inf.Hash))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for name, files := range run.DatasetOutputFiles {
+		dsv := cm.Outputs[name]
+		hashes := map[string]string{}
+		for _, of := range run.DatasetOutputHashes[name] {
+			hashes[of.Filename] = of.Hash
+		}
+		for _, f := range files {
+			_, err := db.Exec(`INSERT INTO dataset_outputs (
+				dot_id, commit_id, run_id, dataset, dataset_dot_id, dataset_version, filename, hash
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				dotID, commitID, run.RunID, name, nullString(string(dsv.ID)), nullString(dsv.Version),
+				f, nullString(hashes[f]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The null* helpers map empty or unknown values to NULL
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullJSON(b []byte) string {
+	if string(b) == "null" {
+		return ""
+	}
+	return string(b)
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(TimeFormat)
+}
+
+func nullFloat(f float64) interface{} {
+	if f <= 0 {
+		return nil
+	}
+	return finite(f)
+}
+
+func finite(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+func nullInt(i int64) interface{} {
+	if i <= 0 {
+		return nil
+	}
+	return i
+}
@@ -0,0 +1,276 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testCommits() []metadata.WorkspaceCommit {
+	oops := "out of memory"
+	return []metadata.WorkspaceCommit{
+		metadata.WorkspaceCommit{
+			CommitID: "c1",
+			Metadata: metadata.CommitMetadata{
+				SubmitterID:        "452342",
+				Success:            false,
+				WorkloadImage:      "busybox",
+				WorkloadCommand:    []string{"sh", "-c", "echo 'hi'"},
+				Inputs:             map[string]metadata.DatasetVersion{"b": metadata.DatasetVersion{ID: "dot-b", Version: "b1"}},
+				Outputs:            map[string]metadata.DatasetVersion{"d": metadata.DatasetVersion{ID: "dot-d", Version: "d1"}},
+				ExecStart:          time.Date(2018, 10, 4, 13, 6, 7, 0, time.UTC),
+				ExecEnd:            time.Date(2018, 10, 4, 13, 6, 10, 0, time.UTC),
+				ExecCPUSecondsUsed: math.Inf(1),
+				Runs: []metadata.RunMetadata{
+					metadata.RunMetadata{
+						RunID:                 "r1",
+						Success:               true,
+						Parameters:            map[string]string{"smoothing": "1.0"},
+						Summary:               map[string]string{"rms_error": "0.057", "verdict": "good", "version": "1.2.3", "range": "1-2", "loss": "NaN", "steps": "1e3"},
+						Labels:                map[string]string{"team": "ml"},
+						WorkspaceInputFiles:   []metadata.InputFile{metadata.InputFile{Filename: "foo.csv", Version: "w0", Hash: "aaaa"}},
+						WorkspaceOutputFiles:  []string{"model.pkl"},
+						WorkspaceOutputHashes: []metadata.OutputFile{metadata.OutputFile{Filename: "model.pkl", Hash: "bbbb"}},
+						DatasetInputFiles:     map[string][]metadata.InputFile{"b": []metadata.InputFile{metadata.InputFile{Filename: "input.csv", Version: "b0"}}},
+						DatasetOutputFiles:    map[string][]string{"d": []string{"output.csv"}},
+						ExecStart:             time.Date(2018, 10, 4, 13, 6, 7, 0, time.UTC),
+						ExecEnd:               time.Date(2018, 10, 4, 13, 6, 9, 500000000, time.UTC),
+					},
+					metadata.RunMetadata{
+						RunID:        "r2",
+						Authority:    metadata.RunAuthority_Correction,
+						ErrorMessage: &oops,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestScript(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewScript(&buf)
+	_, err := s.Exec("INSERT INTO t VALUES (?, ?, ?, ?, ?, ?)", "it's", nil, true, int64(3), 1.5, math.NaN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "INSERT INTO t VALUES ('it''s', NULL, 1, 3, 1.5, NULL);\n" {
+		t.Errorf("Unexpected script %q", got)
+	}
+	if _, err := s.Exec("SELECT ?"); err == nil {
+		t.Errorf("Expected an error for a missing argument")
+	}
+}
+
+func TestExportDuplicateRunIDs(t *testing.T) {
+	commits := testCommits()
+	last := &commits[len(commits)-1].Metadata
+	last.Runs = append(last.Runs, last.Runs[0])
+
+	var buf bytes.Buffer
+	err := Export(NewScript(&buf), "dot1", commits)
+	if !errors.Is(err, metadata.ErrDuplicateRun) {
+		t.Errorf("Expected ErrDuplicateRun, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be written, got %q", buf.String())
+	}
+}
+
+func TestExportWithSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+
+	var buf bytes.Buffer
+	s := NewScript(&buf)
+	s.Begin()
+	err = Export(s, "ws", testCommits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Exporting again replaces the commit's rows
+	err = Export(s, "ws", testCommits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Commit()
+
+	buf.WriteString(`
+SELECT COUNT(*) FROM commits;
+SELECT COUNT(*) FROM runs;
+SELECT run_id, authority, success, error_message, duration_seconds FROM runs ORDER BY position;
+SELECT workload_command, exec_start, exec_cpu_seconds FROM commits;
+SELECT name, value FROM run_summary_numeric ORDER BY name;
+SELECT filename, version, hash FROM run_inputs;
+SELECT filename, hash FROM run_outputs;
+SELECT dataset, dataset_dot_id, dataset_version, filename FROM dataset_inputs;
+SELECT dataset, dataset_dot_id, dataset_version, filename FROM dataset_outputs;
+SELECT name, value FROM run_labels;
+`)
+	cmd := exec.Command(sqlite3, ":memory:")
+	cmd.Stdin = &buf
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 failed: %s\n%s", err, out)
+	}
+
+	expected := strings.Join([]string{
+		"1",
+		"2",
+		"r1|workload|1||2.5",
+		"r2|correction|0|out of memory|",
+		"[\"sh\",\"-c\",\"echo 'hi'\"]|2018-10-04T13:06:07.000Z|",
+		"rms_error|0.057",
+		"steps|1000.0",
+		"foo.csv|w0|aaaa",
+		"model.pkl|bbbb",
+		"b|dot-b|b1|input.csv",
+		"d|dot-d|d1|output.csv",
+		"team|ml",
+	}, "\n") + "\n"
+	if string(out) != expected {
+		t.Errorf("Wanted:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+// fakeDriver is a database/sql driver that logs the statements it is given,
+// and answers queries with the commit IDs in exported.
+type fakeDriver struct {
+	mu       sync.Mutex
+	log      []string
+	exported []string
+	fail     string
+}
+
+var testDriver = &fakeDriver{}
+
+func init() {
+	sql.Register("sqlite-fake", testDriver)
+}
+
+func (d *fakeDriver) record(query string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fail != "" && strings.Contains(query, d.fail) {
+		return errors.New("failed: " + query)
+	}
+	// Log the verb and table, such as "INSERT commits"
+	words := strings.Fields(query)
+	d.log = append(d.log, words[0]+" "+words[2])
+	return nil
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.d, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.log = append(c.d.log, "BEGIN")
+	return fakeTx{c.d}, nil
+}
+
+type fakeTx struct{ d *fakeDriver }
+
+func (tx fakeTx) end(stmt string) error {
+	tx.d.mu.Lock()
+	defer tx.d.mu.Unlock()
+	tx.d.log = append(tx.d.log, stmt)
+	return nil
+}
+
+func (tx fakeTx) Commit() error   { return tx.end("COMMIT") }
+func (tx fakeTx) Rollback() error { return tx.end("ROLLBACK") }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.HasPrefix(s.query, "CREATE") {
+		return driver.RowsAffected(0), nil
+	}
+	return driver.RowsAffected(1), s.d.record(s.query)
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{ids: s.d.exported}, nil
+}
+
+type fakeRows struct{ ids []string }
+
+func (r *fakeRows) Columns() []string { return []string{"commit_id"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.ids) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.ids[0]
+	r.ids = r.ids[1:]
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	db, err := sql.Open("sqlite-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c2 := testCommits()[0]
+	c2.CommitID = "c2"
+	c2.Metadata.Runs = c2.Metadata.Runs[1:]
+	commits := append(testCommits(), c2)
+
+	// c1 is exported already, so only c2 is written
+	testDriver.exported = []string{"c1"}
+	err = Sync(db, "ws", commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := testDriver.log
+	if log[0] != "BEGIN" || log[len(log)-1] != "COMMIT" {
+		t.Errorf("Expected the export in a transaction, got %v", log)
+	}
+	inserts := 0
+	for _, stmt := range log {
+		if strings.HasPrefix(stmt, "INSERT") {
+			inserts++
+		}
+	}
+	// One commit and one run
+	if inserts != 2 {
+		t.Errorf("Expected 2 inserts, got %v", log)
+	}
+
+	// A failed export is rolled back
+	testDriver.log = nil
+	testDriver.exported = nil
+	testDriver.fail = "INSERT INTO runs"
+	err = Sync(db, "ws", commits)
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+	if got := testDriver.log[len(testDriver.log)-1]; got != "ROLLBACK" {
+		t.Errorf("Expected a rollback, got %v", testDriver.log)
+	}
+}
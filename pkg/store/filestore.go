@@ -0,0 +1,350 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+const (
+	// LogFilename is the name of the log a FileStore keeps in its
+	// directory.
+	LogFilename = "commits.jsonl"
+	// IndexFilename is the name of the index checkpoint a FileStore keeps
+	// in its directory.
+	IndexFilename = "index.json"
+	// LockFilename is the name of the file a FileStore locks while it is
+	// open.
+	LockFilename = "lock"
+)
+
+var (
+	ErrClosed = errors.New("store is closed")
+	ErrLocked = errors.New("store is open in another process")
+)
+
+type logEntry struct {
+	Key
+	Metadata map[string]string `json:"metadata"`
+}
+
+// type checkpoint is the contents of the index file: the index as it was
+// once the first Offset bytes, or Lines lines, of the log were read.
+type checkpoint struct {
+	Offset  int64   `json:"offset"`
+	Lines   int     `json:"lines"`
+	Entries []entry `json:"entries"`
+}
+
+// type FileStore is a Store kept in a directory on local disk. Commits are
+// appended to a log, one JSON object per line, and synced before Put
+// returns. The indexes are held in memory, and say where in the log to read
+// each record from, so every query reads and parses the records it returns
+// afresh. Close saves the indexes to an index file, along with the log
+// offset they cover; Open loads them and reads only the log beyond that
+// offset, so after a crash only the commits put since the last Close are
+// read again. The index file is trusted to match the log before its
+// offset: delete it to rebuild the indexes from the whole log.
+//
+// A FileStore may be used from several goroutines at once. Only one process
+// may open a directory at a time, which is enforced by locking a file in it
+// where the platform allows.
+type FileStore struct {
+	dir  string
+	lock *os.File
+
+	mu    sync.RWMutex
+	f     *os.File
+	size  int64
+	lines int
+	idx   *index
+
+	// failed is set if a Put failed and the log couldn't be truncated
+	// back, so may end in part of an entry. Open discards it, but no more
+	// can be appended until then.
+	failed error
+}
+
+// Open opens the store in dir, creating it if need be. It fails with
+// ErrLocked if another process has the store open. A partial last line of
+// the log, left by a crash while writing it, is discarded. Any other line
+// that can't be read is an error, naming the line, and the log is left as
+// it is.
+func Open(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockFile(filepath.Join(dir, LockFilename))
+	if err != nil {
+		return nil, err
+	}
+	s, err := open(dir)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s.lock = lock
+	return s, nil
+}
+
+func open(dir string) (*FileStore, error) {
+	f, err := os.OpenFile(filepath.Join(dir, LogFilename), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{dir: dir, f: f, idx: newIndex()}
+
+	cp := s.readCheckpoint()
+	s.idx.load(cp.Entries)
+	s.size, s.lines = cp.Offset, cp.Lines
+
+	_, err = f.Seek(s.size, 0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A last line without a newline was never completely written
+			break
+		}
+		if err == nil {
+			var e logEntry
+			err = json.Unmarshal(line, &e)
+			if err == nil {
+				s.add(e.Key, e.Metadata, int64(len(line)))
+				continue
+			}
+		}
+		f.Close()
+		return nil, fmt.Errorf("%s line %d: %v", LogFilename, s.lines+1, err)
+	}
+
+	err = f.Truncate(s.size)
+	if err == nil {
+		_, err = f.Seek(s.size, 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// readCheckpoint returns the saved index, if there is one and it still fits
+// the log, or an empty one to rebuild the index from the start of the log.
+func (s *FileStore) readCheckpoint() checkpoint {
+	empty := checkpoint{}
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, IndexFilename))
+	if err != nil {
+		return empty
+	}
+	var cp checkpoint
+	if json.Unmarshal(b, &cp) != nil || cp.Offset < 0 {
+		return empty
+	}
+	if cp.Offset == 0 {
+		return cp
+	}
+
+	// The last entry before the offset is the latest version of its
+	// commit, so is in the index; check the log still holds it there
+	for _, e := range cp.Entries {
+		if e.Offset+e.Length != cp.Offset {
+			continue
+		}
+		r, err := s.read(e)
+		if err != nil || r.Key != e.Key {
+			return empty
+		}
+		return cp
+	}
+	return empty
+}
+
+// add indexes a log entry of the given length, written at the end of the
+// log.
+func (s *FileStore) add(key Key, raw map[string]string, length int64) {
+	r := NewRecord(key, raw)
+	e := entry{
+		Key:    key,
+		Offset: s.size,
+		Length: length,
+		Commit: r.Commit != nil,
+		Terms:  recordTerms(r),
+	}
+	if r.Commit != nil {
+		e.ExecStart = r.Commit.ExecStart
+	}
+	s.idx.put(e)
+	s.size += length
+	s.lines++
+}
+
+// read reads and parses the log entry e refers to.
+func (s *FileStore) read(e entry) (Record, error) {
+	b := make([]byte, e.Length)
+	_, err := s.f.ReadAt(b, e.Offset)
+	if err != nil {
+		return Record{}, err
+	}
+	var le logEntry
+	err = json.Unmarshal(b, &le)
+	if err != nil {
+		return Record{}, fmt.Errorf("%s at offset %d: %v", LogFilename, e.Offset, err)
+	}
+	return NewRecord(le.Key, le.Metadata), nil
+}
+
+func (s *FileStore) Put(key Key, raw map[string]string) error {
+	b, err := json.Marshal(logEntry{Key: key, Metadata: raw})
+	if err != nil {
+		return err
+	}
+	line := append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return ErrClosed
+	}
+	if s.failed != nil {
+		return s.failed
+	}
+	_, err = s.f.Write(line)
+	if err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		// Drop any part of the entry that was written, so that the next
+		// entry starts on a line of its own
+		terr := s.f.Truncate(s.size)
+		if terr == nil {
+			_, terr = s.f.Seek(s.size, 0)
+		}
+		if terr != nil {
+			s.failed = fmt.Errorf("log may hold a partial entry: %v", terr)
+		}
+		return err
+	}
+	s.add(key, raw, int64(len(line)))
+	return nil
+}
+
+func (s *FileStore) Get(key Key) (Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.f == nil {
+		return Record{}, ErrClosed
+	}
+	e, ok := s.idx.entries[key]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return s.read(e)
+}
+
+// query reads the records of the entries f finds in the index, under the
+// read lock.
+func (s *FileStore) query(f func(idx *index) []entry) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.f == nil {
+		return nil, ErrClosed
+	}
+	entries := f(s.idx)
+	result := make([]Record, len(entries))
+	for i, e := range entries {
+		r, err := s.read(e)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = r
+	}
+	return result, nil
+}
+
+func (s *FileStore) ByRunID(runID string) ([]Record, error) {
+	return s.query(func(idx *index) []entry { return idx.lookup(idx.byRunID[runID]) })
+}
+
+func (s *FileStore) BySubmitter(submitterID string) ([]Record, error) {
+	return s.query(func(idx *index) []entry { return idx.lookup(idx.bySubmitter[submitterID]) })
+}
+
+func (s *FileStore) ByLabel(name, value string) ([]Record, error) {
+	return s.query(func(idx *index) []entry { return idx.lookup(idx.byLabel[[2]string{name, value}]) })
+}
+
+func (s *FileStore) ByDataset(dotID metadata.DotID) ([]Record, error) {
+	return s.query(func(idx *index) []entry { return idx.lookup(idx.byDataset[dotID]) })
+}
+
+func (s *FileStore) ByFile(file metadata.FileRef) ([]Record, error) {
+	file.Filename = cleanFilename(file.Filename)
+	return s.query(func(idx *index) []entry { return idx.lookup(idx.byFile[file]) })
+}
+
+func (s *FileStore) ByExecStart(from, to time.Time) ([]Record, error) {
+	return s.query(func(idx *index) []entry { return idx.byExecStart(from, to) })
+}
+
+// writeCheckpoint saves the index, replacing the index file atomically.
+func (s *FileStore) writeCheckpoint() error {
+	cp := checkpoint{Offset: s.size, Lines: s.lines, Entries: make([]entry, 0, len(s.idx.entries))}
+	for _, e := range s.idx.entries {
+		cp.Entries = append(cp.Entries, e)
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(s.dir, IndexFilename)
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filename + ".tmp")
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// Close saves the index and closes the store.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return ErrClosed
+	}
+	err := s.writeCheckpoint()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	if lerr := s.lock.Close(); err == nil {
+		err = lerr
+	}
+	return err
+}
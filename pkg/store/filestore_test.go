@@ -0,0 +1,365 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+func testEqStrs(t *testing.T, got, expected []string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wanted %#v, got %#v", expected, got)
+	}
+}
+
+func testCommit(author, start, runID string, extra map[string]string) map[string]string {
+	m := map[string]string{
+		"type":                           "dotscience.run.v1",
+		"author":                         author,
+		"exec.start":                     start,
+		"runs":                           "[\"" + runID + "\"]",
+		"run." + runID + ".authority":    "workload",
+		"run." + runID + ".label.team":   "ml",
+		"run." + runID + ".input-files":  "[\"raw.csv@w0\"]",
+		"run." + runID + ".output-files": "[\"model.pkl\"]",
+	}
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testKeys := func(records []Record, err error) []string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := []string{}
+		for _, r := range records {
+			result = append(result, r.DotID+"/"+r.CommitID)
+		}
+		return result
+	}
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	puts := []struct {
+		key Key
+		raw map[string]string
+	}{
+		{Key{"ws", "c1"}, testCommit("alice", "20181004T130000", "r1", map[string]string{"input-dataset.b": "dot-b@b1"})},
+		{Key{"ws", "c2"}, testCommit("bob", "20181005T130000", "r2", map[string]string{"output-dataset.d": "dot-d@d1", "run.r2.dataset-output-files.d": "[\"out.csv\"]"})},
+		{Key{"ws", "c3"}, testCommit("alice", "20181003T130000", "r3", nil)},
+		{Key{"dot-d", "d1"}, map[string]string{"type": "dotscience.run-output.v1", "workspace": "ws", "run.r2.dataset-output-files": "[\"out.csv\"]"}},
+	}
+	for _, p := range puts {
+		err := s.Put(p.key, p.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(s Store) {
+		r, err := s.Get(Key{"ws", "c1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Commit == nil || r.Commit.SubmitterID != "alice" || r.Raw["author"] != "alice" {
+			t.Errorf("Unexpected record %#v", r)
+		}
+		if _, err := s.Get(Key{"ws", "nope"}); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+
+		testEqStrs(t, testKeys(s.ByRunID("r2")), []string{"ws/c2"})
+		testEqStrs(t, testKeys(s.BySubmitter("alice")), []string{"ws/c3", "ws/c1"})
+		testEqStrs(t, testKeys(s.ByLabel("team", "ml")), []string{"ws/c3", "ws/c1", "ws/c2"})
+		testEqStrs(t, testKeys(s.ByDataset("dot-d")), []string{"dot-d/d1", "ws/c2"})
+		testEqStrs(t, testKeys(s.ByDataset("dot-b")), []string{"ws/c1"})
+		testEqStrs(t, testKeys(s.ByFile(metadata.FileRef{Filename: "raw.csv"})), []string{"ws/c3", "ws/c1", "ws/c2"})
+		testEqStrs(t, testKeys(s.ByFile(metadata.FileRef{Dataset: "d", Filename: "out.csv"})), []string{"ws/c2"})
+		testEqStrs(t, testKeys(s.ByExecStart(
+			time.Date(2018, 10, 4, 0, 0, 0, 0, time.UTC),
+			time.Date(2018, 10, 5, 13, 0, 0, 0, time.UTC),
+		)), []string{"ws/c1"})
+	}
+	check(s)
+
+	// Replacing a commit re-indexes it
+	err = s.Put(Key{"ws", "c3"}, testCommit("carol", "20181006T130000", "r3", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEqStrs(t, testKeys(s.BySubmitter("alice")), []string{"ws/c1"})
+	testEqStrs(t, testKeys(s.ByExecStart(time.Time{}, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))), []string{"ws/c1", "ws/c2", "ws/c3"})
+	err = s.Put(Key{"ws", "c3"}, puts[2].raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := s.Get(Key{"ws", "c1"}); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+
+	// Simulate a crash part way through writing an entry
+	f, err := os.OpenFile(filepath.Join(dir, LogFilename), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"dot\": \"ws\", \"commit\": \"c4\", \"meta")
+	f.Close()
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check(s)
+	if _, err := s.Get(Key{"ws", "c4"}); err != ErrNotFound {
+		t.Errorf("Expected the partial entry to be discarded, got %v", err)
+	}
+	err = s.Put(Key{"ws", "c4"}, testCommit("dave", "20181007T130000", "r4", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, LogFilename))
+	lines := 0
+	for _, c := range b {
+		if c == '\n' {
+			lines++
+		}
+	}
+	if lines != 7 {
+		t.Errorf("Expected 7 log entries, got %d", lines)
+	}
+}
+
+func TestFileStoreCorruptLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, commitID := range []string{"c1", "c2", "c3"} {
+		err = s.Put(Key{"ws", commitID}, testCommit("alice", "20181004T130000", "r"+commitID[1:], nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// Corrupt the middle entry
+	filename := filepath.Join(dir, LogFilename)
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	lines[1] = "{\"dot\": \"ws\", \"commit\": garbage}\n"
+	corrupt := strings.Join(lines, "")
+	err = ioutil.WriteFile(filename, []byte(corrupt), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(dir)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error naming line 2, got %v", err)
+	}
+	b, _ = ioutil.ReadFile(filename)
+	if string(b) != corrupt {
+		t.Errorf("Expected the log to be left as it was, got %s", b)
+	}
+}
+
+func TestFileStoreCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err != ErrLocked {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+	for _, commitID := range []string{"c1", "c2"} {
+		err = s.Put(Key{"ws", commitID}, testCommit("alice", "20181004T130000", "r"+commitID[1:], nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, IndexFilename)); err != nil {
+		t.Fatalf("Expected an index file: %s", err)
+	}
+
+	// Overwrite the first entry with one of the same length: as it is
+	// before the checkpoint, Open doesn't read it
+	filename := filepath.Join(dir, LogFilename)
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(b), "alice", "alicf", 1)
+	err = ioutil.WriteFile(filename, []byte(edited), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put(Key{"ws", "c3"}, testCommit("bob", "20181005T130000", "r3", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Crash, without saving the index
+	s.f.Close()
+	s.lock.Close()
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	records, err := s.BySubmitter("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("Expected the index to come from the checkpoint, got %#v", records)
+	}
+	if records, _ := s.BySubmitter("alicf"); len(records) != 0 {
+		t.Errorf("Did not expect the log before the checkpoint to be indexed, got %#v", records)
+	}
+	r, err := s.Get(Key{"ws", "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Commit.SubmitterID != "alicf" {
+		t.Errorf("Expected records to be read from the log, got %#v", r.Commit)
+	}
+	records, err = s.ByRunID("r3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].CommitID != "c3" {
+		t.Errorf("Expected entries after the checkpoint to be read, got %#v", records)
+	}
+}
+
+func TestFileStoreRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	err = s.Put(Key{"ws", "c1"}, testCommit("alice", "20181004T130000", "r1", map[string]string{
+		"run.r1.input-files": "[\"./data/../raw.csv@w0\"]",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range []string{"raw.csv", "/raw.csv", "./raw.csv"} {
+		records, err := s.ByFile(metadata.FileRef{Filename: filename})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Errorf("Expected %s to find c1, got %#v", filename, records)
+		}
+	}
+
+	// Changing a record doesn't change the store
+	r, err := s.Get(Key{"ws", "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Raw["author"] = "mallory"
+	r.Commit.Runs[0].Labels["team"] = "ops"
+	r, err = s.Get(Key{"ws", "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Raw["author"] != "alice" || r.Commit.Runs[0].Labels["team"] != "ml" {
+		t.Errorf("Expected a fresh copy of the record, got %#v", r)
+	}
+}
+
+func TestFileStorePutFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put(Key{"ws", "c1"}, testCommit("alice", "20181004T130000", "r1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A handle that can neither be written nor truncated
+	f := s.f
+	s.f, err = os.Open(filepath.Join(dir, LogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(Key{"ws", "c2"}, testCommit("bob", "20181005T130000", "r2", nil)); err == nil {
+		t.Errorf("Expected the write to fail")
+	}
+	s.f.Close()
+	s.f = f
+	if err := s.Put(Key{"ws", "c3"}, testCommit("bob", "20181005T130000", "r3", nil)); err == nil {
+		t.Errorf("Expected no more writes after a write that couldn't be undone")
+	}
+	s.Close()
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Get(Key{"ws", "c1"}); err != nil {
+		t.Errorf("Expected c1 to survive, got %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package store
+
+import (
+	"os"
+)
+
+// lockFile opens a lock file. This platform has no file locking to keep
+// other processes out, so it is up to the caller not to open a store
+// twice.
+func lockFile(filename string) (*os.File, error) {
+	return os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+}
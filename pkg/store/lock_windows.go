@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile opens a lock file without sharing it, failing with ErrLocked if
+// another process has it open. The lock is released when the file is
+// closed, or the process exits.
+func lockFile(filename string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errorSharingViolation {
+		return nil, ErrLocked
+	} else if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), filename), nil
+}
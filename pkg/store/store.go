@@ -0,0 +1,282 @@
+// Package store keeps commit metadata from many dots, indexed so that
+// tools can find the commits they need without re-reading and re-parsing
+// everything.
+package store
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dotmesh-io/dotscience-metadata/pkg/metadata"
+)
+
+var ErrNotFound = errors.New("commit not found")
+
+// type Key identifies a commit in a dot.
+type Key struct {
+	DotID    string `json:"dot"`
+	CommitID string `json:"commit"`
+}
+
+// type Record is a commit's metadata, in raw and parsed forms. Exactly one
+// of Commit and Dataset is set if the metadata is of a known type.
+type Record struct {
+	Key
+	Raw map[string]string
+
+	// Commit is set for "dotscience.run.v1" workspace commits
+	Commit *metadata.CommitMetadata
+	// Dataset is set for "dotscience.run-output.v1" dataset commits
+	Dataset *metadata.DatasetCommitMetadata
+}
+
+// NewRecord parses raw commit metadata into a Record.
+func NewRecord(key Key, raw map[string]string) Record {
+	r := Record{Key: key, Raw: raw}
+	switch raw["type"] {
+	case "dotscience.run.v1":
+		cm := metadata.ParseCommitMetadata(raw)
+		r.Commit = &cm
+	case "dotscience.run-output.v1":
+		dcm := metadata.ParseDatasetCommitMetadata(raw)
+		r.Dataset = &dcm
+	}
+	return r
+}
+
+// type Store holds commit metadata. Queries return records sorted by
+// the commit's ExecStart, then by key; records without an ExecStart come
+// first.
+type Store interface {
+	// Put adds or replaces the metadata of a commit.
+	Put(key Key, raw map[string]string) error
+	// Get returns a commit, or ErrNotFound.
+	Get(key Key) (Record, error)
+
+	// ByRunID returns the workspace commits containing a run.
+	ByRunID(runID string) ([]Record, error)
+	// BySubmitter returns the workspace commits submitted by a user.
+	BySubmitter(submitterID string) ([]Record, error)
+	// ByLabel returns the workspace commits with a run carrying a label.
+	ByLabel(name, value string) ([]Record, error)
+	// ByDataset returns the workspace commits using a dot as an input or
+	// output dataset, and the dataset commits of that dot.
+	ByDataset(dotID metadata.DotID) ([]Record, error)
+	// ByFile returns the workspace commits with a run that read or wrote a
+	// file.
+	ByFile(file metadata.FileRef) ([]Record, error)
+	// ByExecStart returns the workspace commits whose ExecStart is in the
+	// range [from, to).
+	ByExecStart(from, to time.Time) ([]Record, error)
+
+	Close() error
+}
+
+// cleanFilename normalises a slash-separated filename relative to the root
+// of a dot, so that "a.csv" and "./a.csv" index alike.
+func cleanFilename(filename string) string {
+	return strings.TrimPrefix(path.Clean("/"+filename), "/")
+}
+
+// type terms is what a record is indexed under.
+type terms struct {
+	RunIDs     []string           `json:"run_ids,omitempty"`
+	Submitters []string           `json:"submitters,omitempty"`
+	Labels     [][2]string        `json:"labels,omitempty"`
+	Datasets   []metadata.DotID   `json:"datasets,omitempty"`
+	Files      []metadata.FileRef `json:"files,omitempty"`
+}
+
+func recordTerms(r Record) terms {
+	var t terms
+	if r.Dataset != nil {
+		t.Datasets = append(t.Datasets, metadata.DotID(r.DotID))
+	}
+	cm := r.Commit
+	if cm == nil {
+		return t
+	}
+	if cm.SubmitterID != "" {
+		t.Submitters = append(t.Submitters, cm.SubmitterID)
+	}
+	for _, dsv := range cm.Inputs {
+		t.Datasets = append(t.Datasets, dsv.ID)
+	}
+	for _, dsv := range cm.Outputs {
+		t.Datasets = append(t.Datasets, dsv.ID)
+	}
+	for _, run := range cm.Runs {
+		t.RunIDs = append(t.RunIDs, run.RunID)
+		for k, v := range run.Labels {
+			t.Labels = append(t.Labels, [2]string{k, v})
+		}
+		for _, inf := range run.WorkspaceInputFiles {
+			t.Files = append(t.Files, metadata.FileRef{Filename: cleanFilename(inf.Filename)})
+		}
+		for _, f := range run.WorkspaceOutputFiles {
+			t.Files = append(t.Files, metadata.FileRef{Filename: cleanFilename(f)})
+		}
+		for name, ifs := range run.DatasetInputFiles {
+			for _, inf := range ifs {
+				t.Files = append(t.Files, metadata.FileRef{Dataset: name, Filename: cleanFilename(inf.Filename)})
+			}
+		}
+		for name, fs := range run.DatasetOutputFiles {
+			for _, f := range fs {
+				t.Files = append(t.Files, metadata.FileRef{Dataset: name, Filename: cleanFilename(f)})
+			}
+		}
+	}
+	return t
+}
+
+// type entry locates the latest log entry of a commit, and holds what it is
+// indexed under, so that the index can be saved and loaded without the
+// records themselves.
+type entry struct {
+	Key
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+
+	// Commit is true for workspace commits, which have an ExecStart,
+	// possibly zero
+	Commit    bool      `json:"workspace,omitempty"`
+	ExecStart time.Time `json:"exec_start"`
+	Terms     terms     `json:"terms"`
+}
+
+// index is the in-memory side of a store: where each commit's record is,
+// and indexes over them.
+type index struct {
+	entries map[Key]entry
+
+	byRunID     map[string]map[Key]bool
+	bySubmitter map[string]map[Key]bool
+	byLabel     map[[2]string]map[Key]bool
+	byDataset   map[metadata.DotID]map[Key]bool
+	byFile      map[metadata.FileRef]map[Key]bool
+
+	// byStart holds the workspace commits, sorted by ExecStart, then key
+	byStart []Key
+}
+
+func newIndex() *index {
+	return &index{
+		entries:     map[Key]entry{},
+		byRunID:     map[string]map[Key]bool{},
+		bySubmitter: map[string]map[Key]bool{},
+		byLabel:     map[[2]string]map[Key]bool{},
+		byDataset:   map[metadata.DotID]map[Key]bool{},
+		byFile:      map[metadata.FileRef]map[Key]bool{},
+	}
+}
+
+// terms adds a commit to, or removes it from, every index entry it
+// belongs under.
+func (idx *index) terms(key Key, t terms, add bool) {
+	set := func(m map[Key]bool) map[Key]bool {
+		if m == nil {
+			m = map[Key]bool{}
+		}
+		if add {
+			m[key] = true
+		} else {
+			delete(m, key)
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	}
+
+	for _, id := range t.RunIDs {
+		idx.byRunID[id] = set(idx.byRunID[id])
+	}
+	for _, id := range t.Submitters {
+		idx.bySubmitter[id] = set(idx.bySubmitter[id])
+	}
+	for _, label := range t.Labels {
+		idx.byLabel[label] = set(idx.byLabel[label])
+	}
+	for _, dot := range t.Datasets {
+		idx.byDataset[dot] = set(idx.byDataset[dot])
+	}
+	for _, f := range t.Files {
+		idx.byFile[f] = set(idx.byFile[f])
+	}
+}
+
+func (idx *index) less(a, b Key) bool {
+	ta, tb := idx.entries[a].ExecStart, idx.entries[b].ExecStart
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	if a.DotID != b.DotID {
+		return a.DotID < b.DotID
+	}
+	return a.CommitID < b.CommitID
+}
+
+func (idx *index) put(e entry) {
+	if old, ok := idx.entries[e.Key]; ok {
+		idx.terms(old.Key, old.Terms, false)
+		for i, k := range idx.byStart {
+			if k == e.Key {
+				idx.byStart = append(idx.byStart[:i], idx.byStart[i+1:]...)
+				break
+			}
+		}
+	}
+	idx.entries[e.Key] = e
+	idx.terms(e.Key, e.Terms, true)
+	if e.Commit {
+		i := sort.Search(len(idx.byStart), func(i int) bool { return !idx.less(idx.byStart[i], e.Key) })
+		idx.byStart = append(idx.byStart, Key{})
+		copy(idx.byStart[i+1:], idx.byStart[i:])
+		idx.byStart[i] = e.Key
+	}
+}
+
+// load fills an empty index with entries for distinct keys, sorting byStart
+// once rather than on every insertion.
+func (idx *index) load(entries []entry) {
+	for _, e := range entries {
+		idx.entries[e.Key] = e
+		idx.terms(e.Key, e.Terms, true)
+		if e.Commit {
+			idx.byStart = append(idx.byStart, e.Key)
+		}
+	}
+	sort.Slice(idx.byStart, func(i, j int) bool { return idx.less(idx.byStart[i], idx.byStart[j]) })
+}
+
+func (idx *index) lookup(keys map[Key]bool) []entry {
+	sorted := []Key{}
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return idx.less(sorted[i], sorted[j]) })
+	result := make([]entry, len(sorted))
+	for i, k := range sorted {
+		result[i] = idx.entries[k]
+	}
+	return result
+}
+
+func (idx *index) byExecStart(from, to time.Time) []entry {
+	start := sort.Search(len(idx.byStart), func(i int) bool {
+		return !idx.entries[idx.byStart[i]].ExecStart.Before(from)
+	})
+	result := []entry{}
+	for _, k := range idx.byStart[start:] {
+		e := idx.entries[k]
+		if !e.ExecStart.Before(to) {
+			break
+		}
+		result = append(result, e)
+	}
+	return result
+}